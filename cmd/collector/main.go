@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path"
+	"regexp"
 	"strings"
 	"time"
 
@@ -21,11 +24,26 @@ import (
 
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 
+	"github.com/prometheus/prometheus/prompb"
 	"github.com/rs/zerolog"
+	"github.com/webb-ai/k8s-agent/pkg/admission"
+	"github.com/webb-ai/k8s-agent/pkg/alerts"
 	"github.com/webb-ai/k8s-agent/pkg/api"
+	"github.com/webb-ai/k8s-agent/pkg/audit"
+	"github.com/webb-ai/k8s-agent/pkg/deploy"
+	"github.com/webb-ai/k8s-agent/pkg/encryption"
 	"github.com/webb-ai/k8s-agent/pkg/http"
+	"github.com/webb-ai/k8s-agent/pkg/provenance"
+	"github.com/webb-ai/k8s-agent/pkg/redact"
+	"github.com/webb-ai/k8s-agent/pkg/ruler"
+	"github.com/webb-ai/k8s-agent/pkg/signing"
+	"github.com/webb-ai/k8s-agent/pkg/traffic"
 	"gopkg.in/natefinch/lumberjack.v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	klog "k8s.io/klog/v2"
 
 	apiserver "k8s.io/apiserver/pkg/server"
@@ -33,6 +51,7 @@ import (
 	"github.com/webb-ai/k8s-agent/pkg/k8s"
 	controllerruntime "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
 
 var (
@@ -47,30 +66,225 @@ var (
 	eventCollectionInterval  = time.Minute * 5
 	backupCollectionInterval = time.Minute * 60
 	agentInfoPeriod          = time.Minute * 1
+	changeHistorySize        = 10
 	dataDir                  = "/app/data/"
 	metricsAddress           = ":9090"
 	healthProbeAddress       = ":9091"
 	apiServerProxyAddress    = ":9092"
 )
 
+var (
+	redactEnvVar        = false
+	redactEnvVarMode    = "drop"
+	redactionPolicyFile = ""
+	redactionSaltSecret = "webbai-agent-redaction-salt"
+)
+
+var (
+	encryptionKeyDir = ""
+	signingKeySecret = "webbai-agent-signing-key"
+)
+
+var (
+	auditWebhook    = false
+	auditJoinWindow = time.Second * 30
+)
+
+var (
+	admissionWebhook        = false
+	admissionWebhookService = "webbai-agent-admission"
+	admissionWebhookAddress = ":9443"
+	admissionWebhookPort    = 443
+	// kube-system is busy and changed by the control plane
+	admissionWebhookExcludedNamespaces = "kube-system"
+)
+
+var genericReceiversFile = ""
+
+var deployMarkerWindow = time.Hour
+
+var provenanceKeys = ""
+
+var (
+	serverAuthFile    = ""
+	serverTLSDir      = ""
+	serverMaxBodySize = int64(10 << 20)
+)
+
+var (
+	trafficPodSelector       = ""
+	trafficServerPort        = 8080
+	trafficMetricsPort       = 9090
+	trafficCollectInterval   = time.Minute * 1
+	trafficScrapeTimeout     = time.Second * 10
+	trafficScrapeConcurrency = 10
+	trafficRelabelFile       = ""
+	trafficPodIPLabels       = "instance=,src_ip=src_,dst_ip=dst_"
+)
+
+var (
+	scrapeWorkloads   = false
+	scrapeInterval    = time.Minute * 1
+	scrapeMetricNames = "up|scrape_duration_seconds|.*_requests?_total|.*_requests?_duration_seconds(_bucket|_sum|_count)?|" +
+		".*_errors?_total|grpc_server_handled_total|grpc_server_handling_seconds(_bucket|_sum|_count)?"
+)
+
+var (
+	topologyRequestsMetric = "requests_total"
+	topologyLatencyMetric  = "request_duration_seconds"
+	topologyErrorLabel     = "status_code"
+	topologyErrorValues    = "5.."
+	topologyEdgeExpiry     = time.Minute * 10
+)
+
+var (
+	deployVerificationWindow              = time.Minute * 10
+	deployVerificationErrorRatioIncrease  = 0.05
+	deployVerificationLatencyIncrease     = 1.5
+	deployVerificationRequestRateDecrease = 0.5
+	deployVerificationMinRequestRate      = 0.1
+)
+
+var (
+	ruleFiles              = ""
+	ruleEvaluationInterval = time.Minute * 1
+	ruleWindow             = time.Hour * 1
+)
+
+var (
+	clusterName              = ""
+	remoteWrite              = false
+	remoteWriteMetricNames   = scrapeMetricNames
+	remoteWriteRelabelFile   = ""
+	remoteWriteBatchSize     = 5000
+	remoteWriteFlushInterval = time.Second * 10
+	remoteWriteQueueSize     = 100000
+)
+
 var (
 	kafkaBootstrapServers = ""
 	kafkaPollingInterval  = time.Minute * 5
 )
 
-func newRotateFileLogger(dir, fileName string, maxSizeMb, maxAge, maxBackups int) zerolog.Logger {
-	writer := &lumberjack.Logger{
+func newRotateFileLogger(dir, fileName string, maxSizeMb, maxAge, maxBackups int, signer *signing.Signer) zerolog.Logger {
+	var writer io.Writer = &lumberjack.Logger{
 		Filename:   path.Join(dir, fileName),
 		MaxSize:    maxSizeMb,
 		MaxAge:     maxAge,
 		MaxBackups: maxBackups,
 		Compress:   true,
 	}
+	if signer != nil {
+		writer = signing.NewLogWriter(writer, signer)
+	}
 	return zerolog.New(writer).With().Timestamp().Logger()
 }
 
-func NewClient(agentVersion, kafkaServers string) api.Client {
-	client := http.NewWebbaiClient(agentVersion, kafkaServers)
+func newSigner(ctx context.Context, clientset kubernetes.Interface) *signing.Signer {
+	if signingKeySecret == "" {
+		klog.Infof("signing key secret not set, payloads will not be signed")
+		return nil
+	}
+	// signing was asked for, so sending unsigned payloads would silently weaken it
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		klog.Fatalf("POD_NAMESPACE must be set to persist the signing key, or set --signing-key-secret= to disable signing")
+	}
+	data, err := k8s.LoadOrCreateSecret(ctx, clientset.CoreV1().Secrets(namespace), signingKeySecret, signing.GenerateKey)
+	if err != nil {
+		klog.Fatalf("error loading signing key from secret %s/%s: %v", namespace, signingKeySecret, err)
+	}
+	signer, err := signing.NewSigner(data)
+	if err != nil {
+		klog.Fatalf("error loading signing key from secret %s/%s: %v", namespace, signingKeySecret, err)
+	}
+	klog.Infof("signing payloads with key %s", signer.KeyId())
+	return signer
+}
+
+// newRedactionPolicy combines the policy file, the env var rules and the built-in rules, in that order
+func newRedactionPolicy(ctx context.Context, clientset kubernetes.Interface) *redact.Policy {
+	policy := &redact.Policy{}
+	// hashing values the user asked for requires a salt, the built-in rules degrade gracefully without one
+	requireSalt := redactEnvVar && redactEnvVarMode == "hash"
+	if redactionPolicyFile != "" {
+		filePolicy, err := redact.LoadPolicy(redactionPolicyFile)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		policy = filePolicy
+		requireSalt = requireSalt || filePolicy.UsesHash()
+	}
+	var rules []redact.Rule
+	if redactEnvVar {
+		switch redactEnvVarMode {
+		case "drop":
+			rules = append(rules, redact.EnvVarRules(redact.ActionDrop)...)
+		case "hash":
+			rules = append(rules, redact.HashedEnvVarRules()...)
+		default:
+			klog.Fatalf("unknown redact env var mode %q", redactEnvVarMode)
+		}
+	}
+	rules = append(rules, redact.DefaultRules()...)
+	builtin, err := redact.NewPolicy("", nil, rules...)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	policy, err = policy.Merge(builtin)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	if policy.Salt == "" {
+		// the salt is needed for hash rules and secret fingerprints
+		salt, err := loadRedactionSalt(ctx, clientset)
+		if err != nil {
+			if requireSalt {
+				klog.Fatal(err)
+			}
+			klog.Warningf("hashed values will be dropped and secret changes will not be fingerprinted: %v", err)
+			return policy
+		}
+		policy, err = policy.WithSalt(salt)
+		if err != nil {
+			klog.Fatal(err)
+		}
+	}
+	return policy
+}
+
+// loadRedactionSalt loads the salt persisted in the cluster, so that hashes stay comparable across restarts and workloads
+func loadRedactionSalt(ctx context.Context, clientset kubernetes.Interface) (string, error) {
+	namespace := os.Getenv("POD_NAMESPACE")
+	if redactionSaltSecret == "" || namespace == "" {
+		return "", fmt.Errorf("no redaction salt, set one in the redaction policy or set --redaction-salt-secret and POD_NAMESPACE")
+	}
+	data, err := k8s.LoadOrCreateSecret(ctx, clientset.CoreV1().Secrets(namespace), redactionSaltSecret, redact.GenerateSalt)
+	if err != nil {
+		return "", fmt.Errorf("error loading redaction salt: %w", err)
+	}
+	salt := string(data[redact.SaltSecretKey])
+	if salt == "" {
+		return "", fmt.Errorf("secret %s/%s has no %s", namespace, redactionSaltSecret, redact.SaltSecretKey)
+	}
+	return salt, nil
+}
+
+func newEncryptor() *encryption.Encryptor {
+	if encryptionKeyDir == "" {
+		return nil
+	}
+	encryptor, err := encryption.NewEncryptor(encryptionKeyDir)
+	if err != nil {
+		// refuse to start rather than streaming unencrypted data
+		klog.Fatalf("error loading encryption key from %s: %v", encryptionKeyDir, err)
+	}
+	klog.Infof("payload encryption enabled with key from %s", encryptionKeyDir)
+	return encryptor
+}
+
+func NewClient(agentVersion, kafkaServers string, signer *signing.Signer) api.Client {
+	client := http.NewWebbaiClient(agentVersion, kafkaServers, newEncryptor(), signer)
 	if client == nil {
 		klog.Warningf("cannot initialize webb.ai http client. Will not stream data to webb.ai")
 		return &api.NoOpClient{}
@@ -78,6 +292,188 @@ func NewClient(agentVersion, kafkaServers string) api.Client {
 	return client
 }
 
+// newReceivers returns the built-in alert receivers and the generic ones configured in the receivers file
+func newReceivers() []alerts.Receiver {
+	receivers := alerts.DefaultReceivers()
+	if genericReceiversFile != "" {
+		generic, err := alerts.LoadGenericReceivers(genericReceiversFile)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		receivers = append(receivers, generic...)
+	}
+	paths := make(map[string]struct{}, len(server.Routes)+len(receivers))
+	for _, route := range server.Routes {
+		paths[route] = struct{}{}
+	}
+	for _, receiver := range receivers {
+		if _, found := paths[receiver.Source()]; found {
+			klog.Fatalf("receiver %s conflicts with another endpoint", receiver.Source())
+		}
+		paths[receiver.Source()] = struct{}{}
+	}
+	return receivers
+}
+
+// splitList splits a comma separated flag, ignoring empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func newRuler(client api.Client, enricher api.IssueEnricher) *ruler.Ruler {
+	if ruleFiles == "" {
+		klog.Infof("rule files not configured, skipping rule evaluation")
+		return nil
+	}
+	evaluator, err := ruler.NewRuler(client, splitList(ruleFiles), ruleEvaluationInterval, ruleWindow, dataDir, enricher)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	return evaluator
+}
+
+// newRequestMetrics returns how requests are measured by the traffic collector, for the topology and the verification
+// of rollouts
+func newRequestMetrics() traffic.RequestMetrics {
+	errorValues, err := regexp.Compile("^(?:" + topologyErrorValues + ")$")
+	if err != nil {
+		klog.Fatalf("error parsing topology error values %q: %v", topologyErrorValues, err)
+	}
+	return traffic.RequestMetrics{
+		Requests:    topologyRequestsMetric,
+		Latency:     topologyLatencyMetric,
+		ErrorLabel:  topologyErrorLabel,
+		ErrorValues: errorValues,
+	}
+}
+
+func newTopology(client api.Client) *traffic.Topology {
+	if topologyRequestsMetric == "" {
+		klog.Infof("topology requests metric not configured, skipping service dependency graph")
+		return nil
+	}
+	return traffic.NewTopology(client, traffic.TopologyConfig{
+		RequestMetrics: newRequestMetrics(),
+		Expiry:         topologyEdgeExpiry,
+	})
+}
+
+func newVerifier(client api.Client) (*deploy.Verifier, *traffic.SignalHistory) {
+	if topologyRequestsMetric == "" || deployVerificationWindow <= 0 {
+		klog.Infof("deploy verification not configured, skipping verification of rollouts")
+		return nil, nil
+	}
+	// rollouts are verified up to a minute after their window, against the signals of the window before them
+	signals := traffic.NewSignalHistory(newRequestMetrics(), 2*deployVerificationWindow+2*time.Minute)
+	verifier := deploy.NewVerifier(client, signals, deployVerificationWindow, deploy.Thresholds{
+		ErrorRatioIncrease:  deployVerificationErrorRatioIncrease,
+		LatencyIncrease:     deployVerificationLatencyIncrease,
+		RequestRateDecrease: deployVerificationRequestRateDecrease,
+		MinRequestRate:      deployVerificationMinRequestRate,
+	})
+	return verifier, signals
+}
+
+func newMetricsEnricher(informerFactory dynamicinformer.DynamicSharedInformerFactory, observers []traffic.Observer, client api.Client) *k8s.MetricsEnricher {
+	relabeler, err := traffic.LoadRelabeler(trafficRelabelFile)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	podIPLabels, err := k8s.ParsePodIPLabels(trafficPodIPLabels)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	return k8s.NewMetricsEnricher(client, informerFactory, podIPLabels, relabeler, observers...)
+}
+
+func newTrafficCollector(informerFactory dynamicinformer.DynamicSharedInformerFactory, signer *signing.Signer, client api.Client) *k8s.TrafficCollector {
+	if trafficPodSelector == "" {
+		klog.Infof("traffic pod selector not configured, skipping traffic collector loop")
+		return nil
+	}
+	podSelector, err := labels.Parse(trafficPodSelector)
+	if err != nil {
+		klog.Fatalf("error parsing traffic pod selector %q: %v", trafficPodSelector, err)
+	}
+	return k8s.NewTrafficCollector(
+		informerFactory,
+		trafficCollectInterval,
+		podSelector,
+		trafficServerPort,
+		trafficMetricsPort,
+		trafficScrapeTimeout,
+		trafficScrapeConcurrency,
+		newRotateFileLogger(dataDir, "k8s_traffic.log", 100, 28, 10, signer),
+		client,
+	)
+}
+
+func newWorkloadScraper(informerFactory dynamicinformer.DynamicSharedInformerFactory, discoveryClient discovery.ServerResourcesInterface, signer *signing.Signer, client api.Client) *k8s.WorkloadScraper {
+	if !scrapeWorkloads {
+		klog.Infof("workload scraping not enabled, skipping workload scraper loop")
+		return nil
+	}
+	metricNames, err := regexp.Compile("^(?:" + scrapeMetricNames + ")$")
+	if err != nil {
+		klog.Fatalf("error parsing scrape metric names %q: %v", scrapeMetricNames, err)
+	}
+	return k8s.NewWorkloadScraper(
+		informerFactory,
+		discoveryClient,
+		scrapeInterval,
+		trafficScrapeTimeout,
+		trafficScrapeConcurrency,
+		metricNames,
+		newRotateFileLogger(dataDir, "k8s_metrics.log", 100, 28, 10, signer),
+		client,
+	)
+}
+
+// newClusterLabels returns the labels identifying the cluster, the uid of the kube-system namespace identifies it
+// even if it has no name
+func newClusterLabels(ctx context.Context, clientset kubernetes.Interface) []prompb.Label {
+	var clusterLabels []prompb.Label
+	if clusterName != "" {
+		clusterLabels = append(clusterLabels, prompb.Label{Name: "cluster", Value: clusterName})
+	}
+	namespace, err := clientset.CoreV1().Namespaces().Get(ctx, "kube-system", metav1.GetOptions{})
+	if err != nil {
+		klog.Warningf("error getting the cluster uid: %v", err)
+		return clusterLabels
+	}
+	return append(clusterLabels, prompb.Label{Name: "cluster_uid", Value: string(namespace.UID)})
+}
+
+func newRelay(ctx context.Context, clientset kubernetes.Interface, client api.Client) *traffic.Relay {
+	if !remoteWrite {
+		klog.Infof("remote write not enabled, skipping remote write relay")
+		return nil
+	}
+	metricNames, err := regexp.Compile("^(?:" + remoteWriteMetricNames + ")$")
+	if err != nil {
+		klog.Fatalf("error parsing remote write metric names %q: %v", remoteWriteMetricNames, err)
+	}
+	relabeler, err := traffic.LoadRelabeler(remoteWriteRelabelFile)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	return traffic.NewRelay(
+		client,
+		metricNames,
+		relabeler,
+		newClusterLabels(ctx, clientset),
+		remoteWriteBatchSize,
+		remoteWriteFlushInterval,
+		remoteWriteQueueSize,
+	)
+}
+
 func newKafkaCollector(client api.Client) *kafka.Collector {
 	if kafkaBootstrapServers == "" {
 		klog.Infof("kafka bootstrap server not configured, skipping kafka collector loop")
@@ -96,6 +492,109 @@ func newKafkaCollector(client api.Client) *kafka.Collector {
 	return collector
 }
 
+func newManager(config *rest.Config) manager.Manager {
+	klog.Infof("creating controller manager")
+	controllerManager, err := controllerruntime.NewManager(config, controllerruntime.Options{
+		HealthProbeBindAddress:        healthProbeAddress,
+		Metrics:                       metricsserver.Options{BindAddress: metricsAddress},
+		LeaderElection:                true,
+		LeaderElectionID:              "webb-ai.k8s-resource-collector",
+		LeaderElectionNamespace:       os.Getenv("POD_NAMESPACE"),
+		LeaderElectionReleaseOnCancel: true,
+	})
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	if err := controllerManager.AddHealthzCheck("ping", healthz.Ping); err != nil {
+		klog.Fatalf("Failed to add health check endpoint: %w", err)
+	}
+	return controllerManager
+}
+
+// addRunnable adds a runnable to the manager, the agent doesn't start without any of them
+func addRunnable(controllerManager manager.Manager, runnable manager.Runnable) {
+	if err := controllerManager.Add(runnable); err != nil {
+		klog.Fatal(err)
+	}
+}
+
+func newProvenanceExtractor() *provenance.Extractor {
+	extraProvenanceKeys, err := provenance.ParseKeys(provenanceKeys)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	return provenance.NewExtractor(extraProvenanceKeys)
+}
+
+func newAuditCorrelator(client api.Client) *audit.Correlator {
+	if !auditWebhook {
+		klog.Infof("audit webhook not enabled, skipping audit correlator")
+		return nil
+	}
+	klog.Infof("creating audit correlator")
+	return audit.NewCorrelator(client, auditJoinWindow, k8s.WatchedGVRs)
+}
+
+// newAdmissionWebhook returns the admission webhook, or deletes the configuration it registered if it's disabled
+func newAdmissionWebhook(ctx context.Context, clientset kubernetes.Interface, signer *signing.Signer, client api.Client) *admission.Webhook {
+	if !admissionWebhook {
+		klog.Infof("admission webhook not enabled, skipping change intents")
+		if err := admission.Unregister(ctx, clientset, admissionWebhookService); err != nil {
+			klog.Warning(err)
+		}
+		return nil
+	}
+	klog.Infof("creating admission webhook")
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		klog.Fatalf("POD_NAMESPACE must be set to serve the admission webhook")
+	}
+	return admission.NewWebhook(
+		clientset,
+		namespace,
+		admissionWebhookService,
+		int32(admissionWebhookPort),
+		admissionWebhookAddress,
+		k8s.IntentGVRs,
+		splitList(admissionWebhookExcludedNamespaces),
+		newRotateFileLogger(dataDir, "k8s_change_intent.log", 100, 28, 10, signer),
+		client,
+	)
+}
+
+// newApiServerProxy returns the api server proxy with its alert receivers and the authentication of its routes
+func newApiServerProxy(
+	client api.Client,
+	correlator *audit.Correlator,
+	deployCorrelator *deploy.Correlator,
+	relay *traffic.Relay,
+	topology api.TopologySource,
+	enricher api.IssueEnricher,
+) *server.ApiServerProxy {
+	receivers := newReceivers()
+	authenticator, err := server.LoadAuthenticator(serverAuthFile)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	if err := authenticator.CheckRoutes(receivers); err != nil {
+		klog.Fatal(err)
+	}
+	return server.NewApiServerProxy(
+		client,
+		correlator,
+		deployCorrelator,
+		relay,
+		topology,
+		enricher,
+		receivers,
+		authenticator,
+		serverTLSDir,
+		serverMaxBodySize,
+		apiServerProxyAddress,
+	)
+}
+
 func main() {
 	var version bool
 	flag.BoolVar(&version, "version", false, "show version")
@@ -103,8 +602,58 @@ func main() {
 	flag.Float64Var(&qps, "kube-api-qps", qps, "max qps from this client to kube api server, default 20")
 	flag.IntVar(&burst, "kube-api-burst", burst, "max burst for throttle from this client to kube api server, default 30")
 	flag.DurationVar(&eventCollectionInterval, "event-collect-interval", eventCollectionInterval, "interval to collect events")
-	flag.BoolVar(&api.RedactEnvVar, "redact-env-var", false, "redact env var")
-
+	flag.IntVar(&changeHistorySize, "change-history-size", changeHistorySize, "number of recent changes kept per object and attached to the issues about it")
+	flag.BoolVar(&redactEnvVar, "redact-env-var", redactEnvVar, "redact env var")
+	flag.StringVar(&redactEnvVarMode, "redact-env-var-mode", redactEnvVarMode, "how --redact-env-var redacts env vars: drop removes env and envFrom, hash keeps names and valueFrom but replaces values with a salted hmac")
+	flag.StringVar(&redactionSaltSecret, "redaction-salt-secret", redactionSaltSecret, "name of the secret in the agent namespace persisting the salt of hashed values, used if the policy file has no salt")
+	flag.StringVar(&redactionPolicyFile, "redaction-policy-file", redactionPolicyFile, "yaml file with redaction rules evaluated before the built-in rules")
+	flag.StringVar(&signingKeySecret, "signing-key-secret", signingKeySecret, "name of the secret in the agent namespace persisting the payload signing key, set it to empty to send unsigned payloads and local logs")
+	flag.StringVar(&encryptionKeyDir, "encryption-key-dir", encryptionKeyDir, "directory with the customer public key (public-key.pem) used to encrypt payloads, disabled if empty")
+	flag.BoolVar(&auditWebhook, "audit-webhook", auditWebhook, "serve an audit webhook backend on the api server proxy at /audit, and enrich change events with the user who made them")
+	flag.DurationVar(&auditJoinWindow, "audit-join-window", auditJoinWindow, "how long change events wait for their audit event, should exceed the batch max wait of the audit webhook")
+	flag.BoolVar(&admissionWebhook, "admission-webhook", admissionWebhook, "register a validating admission webhook that allows every request and records it as a change_intent event")
+	flag.StringVar(&admissionWebhookService, "admission-webhook-service", admissionWebhookService, "name of the service in the agent namespace routing to the admission webhook, also names its configuration and certificate secret")
+	flag.StringVar(&admissionWebhookAddress, "admission-webhook-address", admissionWebhookAddress, "address the admission webhook listens on")
+	flag.IntVar(&admissionWebhookPort, "admission-webhook-port", admissionWebhookPort, "port of the admission webhook service")
+	flag.StringVar(&admissionWebhookExcludedNamespaces, "admission-webhook-excluded-namespaces", admissionWebhookExcludedNamespaces, "comma separated namespaces whose admission requests are not recorded")
+	flag.StringVar(&serverAuthFile, "server-auth-file", serverAuthFile, "yaml file configuring bearer token or hmac authentication of the api server proxy routes")
+	flag.StringVar(&serverTLSDir, "server-tls-dir", serverTLSDir, "directory with tls.crt and tls.key served by the api server proxy, plain http if empty")
+	flag.Int64Var(&serverMaxBodySize, "server-max-body-size", serverMaxBodySize, "max size in bytes of request bodies accepted by the api server proxy")
+	flag.StringVar(&provenanceKeys, "provenance-keys", provenanceKeys, "comma separated field=key pairs of extra annotations or labels with the provenance of objects, fields are tool, app, repo, revision and version")
+	flag.DurationVar(&deployMarkerWindow, "deploy-marker-window", deployMarkerWindow, "how long deploy markers posted to /deploy wait for the workload change rolling them out")
+	flag.StringVar(&genericReceiversFile, "generic-receivers-file", genericReceiversFile, "yaml file mapping the json payloads of other alert sources to issues with JSONPath")
+
+	flag.StringVar(&trafficPodSelector, "traffic-pod-selector", trafficPodSelector, "label selector of the traffic collector pods, traffic metrics are not collected if empty")
+	flag.IntVar(&trafficServerPort, "traffic-server-port", trafficServerPort, "port of the traffic collector pods receiving the pods to observe")
+	flag.IntVar(&trafficMetricsPort, "traffic-metrics-port", trafficMetricsPort, "port of the traffic collector pods serving /webbai_metrics")
+	flag.DurationVar(&trafficCollectInterval, "traffic-collect-interval", trafficCollectInterval, "interval to collect traffic metrics")
+	flag.DurationVar(&trafficScrapeTimeout, "traffic-scrape-timeout", trafficScrapeTimeout, "timeout of every traffic collector scrape")
+	flag.IntVar(&trafficScrapeConcurrency, "traffic-scrape-concurrency", trafficScrapeConcurrency, "number of traffic collector pods scraped concurrently")
+	flag.StringVar(&trafficRelabelFile, "traffic-relabel-file", trafficRelabelFile, "yaml file with the relabel_configs, drop_labels and allow/deny lists applied to traffic metrics before they're sent")
+	flag.StringVar(&trafficPodIPLabels, "traffic-pod-ip-labels", trafficPodIPLabels, "comma separated label=prefix of the traffic metrics labels with pod IPs to enrich with the metadata of their pod")
+	flag.StringVar(&topologyRequestsMetric, "topology-requests-metric", topologyRequestsMetric, "counter of the requests between pods measured by the traffic collector, the service dependency graph isn't built if empty")
+	flag.StringVar(&topologyLatencyMetric, "topology-latency-metric", topologyLatencyMetric, "histogram of the duration of the requests between pods measured by the traffic collector")
+	flag.StringVar(&topologyErrorLabel, "topology-error-label", topologyErrorLabel, "label of the requests counter telling errors")
+	flag.StringVar(&topologyErrorValues, "topology-error-values", topologyErrorValues, "regular expression of the values of the error label of failed requests, matched against whole values")
+	flag.DurationVar(&topologyEdgeExpiry, "topology-edge-expiry", topologyEdgeExpiry, "time after which edges of the service dependency graph without requests are removed")
+	flag.DurationVar(&deployVerificationWindow, "deploy-verification-window", deployVerificationWindow, "window compared before and after the rollouts of Deployments, rollouts aren't verified if 0 or without topology requests metric")
+	flag.Float64Var(&deployVerificationErrorRatioIncrease, "deploy-verification-error-ratio-increase", deployVerificationErrorRatioIncrease, "increase of the ratio of failed requests after a rollout reported as a regression, 0 disables the check")
+	flag.Float64Var(&deployVerificationLatencyIncrease, "deploy-verification-latency-increase", deployVerificationLatencyIncrease, "factor of the increase of the p95 latency after a rollout reported as a regression, 0 disables the check")
+	flag.Float64Var(&deployVerificationRequestRateDecrease, "deploy-verification-request-rate-decrease", deployVerificationRequestRateDecrease, "ratio of the decrease of the request rate after a rollout reported as a regression, 0 disables the check")
+	flag.Float64Var(&deployVerificationMinRequestRate, "deploy-verification-min-request-rate", deployVerificationMinRequestRate, "minimum requests per second received by a Deployment before its rollout to verify it")
+	flag.StringVar(&ruleFiles, "rule-files", ruleFiles, "comma separated globs of prometheus rule files evaluated over the traffic metrics, alerts are sent as issues")
+	flag.DurationVar(&ruleEvaluationInterval, "rule-evaluation-interval", ruleEvaluationInterval, "interval to evaluate rules")
+	flag.DurationVar(&ruleWindow, "rule-window", ruleWindow, "minimum time traffic metrics are kept in memory for rule evaluation")
+	flag.StringVar(&clusterName, "cluster-name", clusterName, "name of the cluster, added as the cluster label of remote written series")
+	flag.BoolVar(&remoteWrite, "remote-write", remoteWrite, "accept prometheus remote write on /remote-write of the api server proxy")
+	flag.StringVar(&remoteWriteMetricNames, "remote-write-metric-names", remoteWriteMetricNames, "regular expression of the names of the remote written metrics to send, matched against whole names")
+	flag.StringVar(&remoteWriteRelabelFile, "remote-write-relabel-file", remoteWriteRelabelFile, "yaml file with the relabel_configs, drop_labels and allow/deny lists applied to remote written series")
+	flag.IntVar(&remoteWriteBatchSize, "remote-write-batch-size", remoteWriteBatchSize, "max number of remote written series sent at once")
+	flag.DurationVar(&remoteWriteFlushInterval, "remote-write-flush-interval", remoteWriteFlushInterval, "max time remote written series wait to be sent")
+	flag.IntVar(&remoteWriteQueueSize, "remote-write-queue-size", remoteWriteQueueSize, "max number of remote written series pending, requests are rejected with 429 beyond")
+	flag.BoolVar(&scrapeWorkloads, "scrape-workloads", scrapeWorkloads, "scrape the metrics of pods annotated with prometheus.io/scrape and of ServiceMonitor and PodMonitor targets")
+	flag.DurationVar(&scrapeInterval, "scrape-interval", scrapeInterval, "interval to scrape workload metrics")
+	flag.StringVar(&scrapeMetricNames, "scrape-metric-names", scrapeMetricNames, "regular expression of the names of the workload metrics to send, matched against whole names")
 	flag.StringVar(&kafkaBootstrapServers, "kafka-bootstrap-servers", kafkaBootstrapServers, "bootstrap servers for kafka")
 	flag.DurationVar(&kafkaPollingInterval, "kafka-polling-interval", kafkaPollingInterval, "polling interval to detect kafka changes")
 
@@ -135,63 +684,91 @@ func main() {
 	config.QPS = float32(qps)
 	config.Burst = burst
 
-	klog.Infof("creating controller manager")
-	controllerManager, err := controllerruntime.NewManager(config, controllerruntime.Options{
-		HealthProbeBindAddress:        healthProbeAddress,
-		Metrics:                       metricsserver.Options{BindAddress: metricsAddress},
-		LeaderElection:                true,
-		LeaderElectionID:              "webb-ai.k8s-resource-collector",
-		LeaderElectionNamespace:       os.Getenv("POD_NAMESPACE"),
-		LeaderElectionReleaseOnCancel: true,
-	})
-	if err != nil {
-		klog.Fatal(err)
-	}
-
-	if err := controllerManager.AddHealthzCheck("ping", healthz.Ping); err != nil {
-		klog.Fatalf("Failed to add health check endpoint: %w", err)
-	}
+	controllerManager := newManager(config)
 
 	klog.Infof("creating resource collector")
 	dynamicClient := dynamic.NewForConfigOrDie(config)
 	discoveryClient := discovery.NewDiscoveryClientForConfigOrDie(config)
 	informerFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, resyncPeriod)
-	apiClient := NewClient(BuildVersion, kafkaBootstrapServers)
+	ctx := apiserver.SetupSignalContext()
+	clientset := kubernetes.NewForConfigOrDie(config)
+	api.RedactionPolicy = newRedactionPolicy(ctx, clientset)
+	api.ProvenanceExtractor = newProvenanceExtractor()
+	signer := newSigner(ctx, clientset)
+	apiClient := NewClient(BuildVersion, kafkaBootstrapServers, signer)
+	correlator := newAuditCorrelator(apiClient)
+	if correlator != nil {
+		addRunnable(controllerManager, correlator)
+		apiClient = correlator
+	}
+	deployCorrelator := deploy.NewCorrelator(apiClient, deployMarkerWindow)
+	addRunnable(controllerManager, deployCorrelator)
+	apiClient = deployCorrelator
+	// enriched traffic metrics are observed by the verifier and the topology
+	var observers []traffic.Observer
+	if verifier, signals := newVerifier(apiClient); verifier != nil {
+		addRunnable(controllerManager, verifier)
+		apiClient = verifier
+		observers = append(observers, signals)
+	}
 	collector := k8s.NewChangeCollector(
 		eventCollectionInterval,
 		backupCollectionInterval,
 		informerFactory,
 		discoveryClient,
-		newRotateFileLogger(dataDir, "k8s_resource.log", 100, 28, 10),
+		newRotateFileLogger(dataDir, "k8s_resource.log", 100, 28, 10, signer),
 		apiClient,
+		changeHistorySize,
 	)
 
 	klog.Infof("adding resource collector to controller manager")
-	if err := controllerManager.Add(collector); err != nil {
-		klog.Fatal(err)
+	addRunnable(controllerManager, collector)
+
+	// the topology is served by the api server proxy, an interface holding a nil pointer isn't nil
+	var topologySource api.TopologySource
+	if topology := newTopology(apiClient); topology != nil {
+		addRunnable(controllerManager, topology)
+		topologySource = topology
+		observers = append(observers, topology)
+	}
+	// rules are evaluated over the series sent, once enriched and relabeled
+	metricsClient := apiClient
+	if evaluator := newRuler(apiClient, collector); evaluator != nil {
+		addRunnable(controllerManager, evaluator)
+		metricsClient = evaluator
+	}
+	metricsClient = newMetricsEnricher(informerFactory, observers, metricsClient)
+	klog.Infof("creating traffic collector")
+	if trafficCollector := newTrafficCollector(informerFactory, signer, metricsClient); trafficCollector != nil {
+		addRunnable(controllerManager, trafficCollector)
+	}
+
+	klog.Infof("creating workload scraper")
+	if workloadScraper := newWorkloadScraper(informerFactory, discoveryClient, signer, metricsClient); workloadScraper != nil {
+		addRunnable(controllerManager, workloadScraper)
 	}
 
 	klog.Infof("creating kafka collector")
 	if kafkaCollector := newKafkaCollector(apiClient); kafkaCollector != nil {
-		if err := controllerManager.Add(kafkaCollector); err != nil {
-			klog.Fatal(err)
-		}
+		addRunnable(controllerManager, kafkaCollector)
 	}
 
 	klog.Infof("creating agent health controller")
 	if agentInfoController := agentinfo.NewController(agentInfoPeriod, apiClient); agentInfoController != nil {
-		if err := controllerManager.Add(agentInfoController); err != nil {
-			klog.Fatal(err)
-		}
+		addRunnable(controllerManager, agentInfoController)
+	}
+
+	if webhook := newAdmissionWebhook(ctx, clientset, signer, apiClient); webhook != nil {
+		addRunnable(controllerManager, webhook)
 	}
 
 	klog.Infof("creating api server proxy")
-	apiServerProxy := server.NewApiServerProxy(apiClient, apiServerProxyAddress)
-	if err := controllerManager.Add(apiServerProxy); err != nil {
-		klog.Fatal(err)
+	relay := newRelay(ctx, clientset, metricsClient)
+	if relay != nil {
+		addRunnable(controllerManager, relay)
 	}
+	addRunnable(controllerManager, newApiServerProxy(apiClient, correlator, deployCorrelator, relay, topologySource, collector))
 
-	ctx := apiserver.SetupSignalContext()
 	if err := controllerManager.Start(ctx); err != nil {
 		klog.Fatal(err)
 	}
@@ -0,0 +1,114 @@
+package admission
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// keys of the secret persisting the webhook certificates
+const (
+	CACertKey  = "ca.crt"
+	TLSCertKey = "tls.crt"
+	TLSKeyKey  = "tls.key"
+)
+
+// certificateValidity is long since the certificates are self-signed and only trusted by the webhook configuration
+const certificateValidity = 10 * 365 * 24 * time.Hour
+
+// ServiceDNSNames are the names the api server may use to reach the webhook service
+func ServiceDNSNames(service, namespace string) []string {
+	return []string{
+		fmt.Sprintf("%s.%s.svc", service, namespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", service, namespace),
+	}
+}
+
+// GenerateCertificates returns a generator of secret data holding a self-signed CA and a serving certificate
+// it signed for dnsNames
+func GenerateCertificates(dnsNames []string) func() (map[string][]byte, error) {
+	return func() (map[string][]byte, error) {
+		now := time.Now()
+		caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("error generating webhook CA key: %w", err)
+		}
+		caTemplate := &x509.Certificate{
+			SerialNumber:          newSerialNumber(),
+			Subject:               pkix.Name{CommonName: "webbai-agent-webhook-ca"},
+			NotBefore:             now.Add(-time.Hour),
+			NotAfter:              now.Add(certificateValidity),
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+		caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+		if err != nil {
+			return nil, fmt.Errorf("error creating webhook CA certificate: %w", err)
+		}
+
+		servingKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("error generating webhook serving key: %w", err)
+		}
+		servingTemplate := &x509.Certificate{
+			SerialNumber: newSerialNumber(),
+			Subject:      pkix.Name{CommonName: dnsNames[0]},
+			DNSNames:     dnsNames,
+			NotBefore:    now.Add(-time.Hour),
+			NotAfter:     now.Add(certificateValidity),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+		servingDer, err := x509.CreateCertificate(rand.Reader, servingTemplate, caTemplate, &servingKey.PublicKey, caKey)
+		if err != nil {
+			return nil, fmt.Errorf("error creating webhook serving certificate: %w", err)
+		}
+		servingKeyDer, err := x509.MarshalECPrivateKey(servingKey)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding webhook serving key: %w", err)
+		}
+
+		return map[string][]byte{
+			CACertKey:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDer}),
+			TLSCertKey: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: servingDer}),
+			TLSKeyKey:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: servingKeyDer}),
+		}, nil
+	}
+}
+
+// LoadCertificates parses the secret data created by GenerateCertificates, and checks the serving certificate
+// is valid for dnsNames
+func LoadCertificates(data map[string][]byte, dnsNames []string) (tls.Certificate, []byte, error) {
+	certificate, err := tls.X509KeyPair(data[TLSCertKey], data[TLSKeyKey])
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("error loading webhook serving certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("error parsing webhook serving certificate: %w", err)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(data[CACertKey]) {
+		return tls.Certificate{}, nil, fmt.Errorf("no CA certificate in %s", CACertKey)
+	}
+	for _, dnsName := range dnsNames {
+		if _, err := leaf.Verify(x509.VerifyOptions{DNSName: dnsName, Roots: roots}); err != nil {
+			return tls.Certificate{}, nil, fmt.Errorf("webhook serving certificate is not valid for %s, delete the secret to regenerate it: %w", dnsName, err)
+		}
+	}
+	return certificate, data[CACertKey], nil
+}
+
+func newSerialNumber() *big.Int {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return serial
+}
@@ -0,0 +1,38 @@
+package admission
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const OperationKey = "operation"
+const DryRunKey = "dry_run"
+
+// webhookMetrics are shared by all webhooks, metrics can only be registered once
+var webhookMetrics = NewMetrics()
+
+type Metrics struct {
+	AdmissionReviewCounter *prometheus.CounterVec
+	DroppedReviewCounter   prometheus.Counter
+}
+
+func NewMetrics() *Metrics {
+	admissionReviewCounter := promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "admission_review_total",
+			Help: "Counts the total number of admission reviews recorded. Labels: operation(CREATE|UPDATE|DELETE), dry_run(true|false)",
+		},
+		[]string{OperationKey, DryRunKey},
+	)
+	droppedReviewCounter := promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "admission_review_dropped_total",
+			Help: "Counts the admission reviews not recorded because the recording queue was full",
+		},
+	)
+
+	return &Metrics{
+		AdmissionReviewCounter: admissionReviewCounter,
+		DroppedReviewCounter:   droppedReviewCounter,
+	}
+}
@@ -0,0 +1,309 @@
+package admission
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/webb-ai/k8s-agent/pkg/api"
+	"github.com/webb-ai/k8s-agent/pkg/k8s"
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
+)
+
+const reviewPath = "/validate"
+
+// managedByLabel marks the validating webhook configurations registered by the agent
+var managedByLabel = map[string]string{"app.kubernetes.io/managed-by": "webbai-agent"}
+
+// queueSize bounds the admission requests waiting to be recorded, requests beyond it are dropped
+const queueSize = 1000
+
+// Webhook is a validating admission webhook recording change intents. It allows every request, and is registered
+// with failurePolicy Ignore so that it never blocks a change, even when the agent is down. Requests are recorded
+// by a single worker after the reply, and dropped when it falls behind.
+type Webhook struct {
+	clientset          kubernetes.Interface
+	namespace          string
+	service            string
+	servicePort        int32
+	address            string
+	resources          []schema.GroupVersionResource
+	excludedNamespaces []string
+	logger             zerolog.Logger
+	client             api.Client
+	metrics            *Metrics
+	queue              chan *admissionv1.AdmissionRequest
+}
+
+func NewWebhook(
+	clientset kubernetes.Interface,
+	namespace string,
+	service string,
+	servicePort int32,
+	address string,
+	resources []schema.GroupVersionResource,
+	excludedNamespaces []string,
+	logger zerolog.Logger,
+	client api.Client,
+) *Webhook {
+	return &Webhook{
+		clientset:          clientset,
+		namespace:          namespace,
+		service:            service,
+		servicePort:        servicePort,
+		address:            address,
+		resources:          resources,
+		excludedNamespaces: excludedNamespaces,
+		logger:             logger,
+		client:             client,
+		metrics:            webhookMetrics,
+		queue:              make(chan *admissionv1.AdmissionRequest, queueSize),
+	}
+}
+
+// NeedLeaderElection returns false, every replica behind the service has to answer admission reviews
+func (w *Webhook) NeedLeaderElection() bool {
+	return false
+}
+
+func (w *Webhook) Start(ctx context.Context) error {
+	dnsNames := ServiceDNSNames(w.service, w.namespace)
+	data, err := k8s.LoadOrCreateSecret(ctx, w.clientset.CoreV1().Secrets(w.namespace), w.service+"-tls", GenerateCertificates(dnsNames))
+	if err != nil {
+		return err
+	}
+	certificate, caBundle, err := LoadCertificates(data, dnsNames)
+	if err != nil {
+		return err
+	}
+	if err := w.register(ctx, caBundle); err != nil {
+		return err
+	}
+
+	go w.process(ctx)
+	server := &http.Server{
+		Addr:              w.address,
+		Handler:           w.handler(),
+		TLSConfig:         &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12},
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	klog.Infof("serving admission webhook on %s", w.address)
+	if err := server.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("error serving admission webhook: %w", err)
+	}
+	return nil
+}
+
+func (w *Webhook) handler() http.Handler {
+	app := gin.New()
+	app.Use(gin.Recovery())
+	app.POST(reviewPath, func(c *gin.Context) {
+		var review admissionv1.AdmissionReview
+		if err := c.BindJSON(&review); err != nil {
+			return
+		}
+		if review.Request == nil {
+			_ = c.AbortWithError(http.StatusBadRequest, fmt.Errorf("admission review has no request"))
+			return
+		}
+		// reply before recording, the api server is waiting
+		request := review.Request
+		select {
+		case w.queue <- request:
+		default:
+			w.metrics.DroppedReviewCounter.Inc()
+		}
+
+		c.JSON(http.StatusOK, &admissionv1.AdmissionReview{
+			TypeMeta: review.TypeMeta,
+			Response: &admissionv1.AdmissionResponse{UID: request.UID, Allowed: true},
+		})
+	})
+	return app
+}
+
+// process records the queued admission requests, until ctx is done
+func (w *Webhook) process(ctx context.Context) {
+	for {
+		select {
+		case request := <-w.queue:
+			w.record(request)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *Webhook) record(request *admissionv1.AdmissionRequest) {
+	oldObject, err := decodeObject(request.OldObject.Raw)
+	if err != nil {
+		klog.Errorf("error decoding old object of admission request %s: %v", request.UID, err)
+		return
+	}
+	newObject, err := decodeObject(request.Object.Raw)
+	if err != nil {
+		klog.Errorf("error decoding object of admission request %s: %v", request.UID, err)
+		return
+	}
+
+	intent := &api.Intent{
+		RequestUid:  string(request.UID),
+		Operation:   string(request.Operation),
+		DryRun:      request.DryRun != nil && *request.DryRun,
+		Username:    request.UserInfo.Username,
+		Groups:      request.UserInfo.Groups,
+		Resource:    path.Join(request.Resource.Group, request.Resource.Version, request.Resource.Resource),
+		SubResource: request.SubResource,
+		Namespace:   request.Namespace,
+		Name:        request.Name,
+	}
+	event := api.NewChangeIntentEvent(intent, oldObject, newObject)
+	w.logger.Info().Any("payload", event).Msg(string(api.ChangeIntent))
+	_ = w.client.SendChangeEvent(event)
+
+	w.metrics.AdmissionReviewCounter.With(map[string]string{
+		OperationKey: intent.Operation,
+		DryRunKey:    strconv.FormatBool(intent.DryRun),
+	}).Inc()
+}
+
+func decodeObject(raw []byte) (*unstructured.Unstructured, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	object := &unstructured.Unstructured{}
+	if err := json.Unmarshal(raw, &object.Object); err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+// register creates or updates the validating webhook configuration pointing at the service
+func (w *Webhook) register(ctx context.Context, caBundle []byte) error {
+	rules := make([]admissionregistrationv1.RuleWithOperations, 0, len(w.resources))
+	for _, gvr := range w.resources {
+		rules = append(rules, admissionregistrationv1.RuleWithOperations{
+			Operations: []admissionregistrationv1.OperationType{
+				admissionregistrationv1.Create,
+				admissionregistrationv1.Update,
+				admissionregistrationv1.Delete,
+			},
+			Rule: admissionregistrationv1.Rule{
+				APIGroups:   []string{gvr.Group},
+				APIVersions: []string{gvr.Version},
+				Resources:   []string{gvr.Resource},
+			},
+		})
+	}
+
+	// namespaces are matched by the label the api server sets to their name
+	var namespaceSelector *metav1.LabelSelector
+	if len(w.excludedNamespaces) > 0 {
+		namespaceSelector = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{
+			Key:      corev1.LabelMetadataName,
+			Operator: metav1.LabelSelectorOpNotIn,
+			Values:   w.excludedNamespaces,
+		}}}
+	}
+
+	failurePolicy := admissionregistrationv1.Ignore
+	sideEffects := admissionregistrationv1.SideEffectClassNone
+	configuration := &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   w.service,
+			Labels: managedByLabel,
+		},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{{
+			Name: "change-intent.webb.ai",
+			ClientConfig: admissionregistrationv1.WebhookClientConfig{
+				Service: &admissionregistrationv1.ServiceReference{
+					Namespace: w.namespace,
+					Name:      w.service,
+					Path:      pointer.String(reviewPath),
+					Port:      pointer.Int32(w.servicePort),
+				},
+				CABundle: caBundle,
+			},
+			Rules:                   rules,
+			NamespaceSelector:       namespaceSelector,
+			FailurePolicy:           &failurePolicy,
+			SideEffects:             &sideEffects,
+			TimeoutSeconds:          pointer.Int32(2),
+			AdmissionReviewVersions: []string{"v1"},
+		}},
+	}
+
+	configurations := w.clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+	existing, err := configurations.Get(ctx, configuration.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		klog.Infof("registering validating webhook configuration %s", configuration.Name)
+		_, err = configurations.Create(ctx, configuration, metav1.CreateOptions{})
+		if !apierrors.IsAlreadyExists(err) {
+			return wrapRegisterError(err)
+		}
+		existing, err = configurations.Get(ctx, configuration.Name, metav1.GetOptions{})
+	}
+	if err != nil {
+		return wrapRegisterError(err)
+	}
+	existing.Labels = configuration.Labels
+	existing.Webhooks = configuration.Webhooks
+	_, err = configurations.Update(ctx, existing, metav1.UpdateOptions{})
+	return wrapRegisterError(err)
+}
+
+func wrapRegisterError(err error) error {
+	if err != nil {
+		return fmt.Errorf("error registering validating webhook configuration: %w", err)
+	}
+	return nil
+}
+
+// Unregister deletes the validating webhook configuration registered by the agent, e.g. once the webhook is disabled.
+// Replicas share the configuration, so it's not deleted when one of them shuts down.
+func Unregister(ctx context.Context, clientset kubernetes.Interface, service string) error {
+	configurations := clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+	existing, err := configurations.Get(ctx, service, metav1.GetOptions{})
+	// an agent not allowed to read the configuration couldn't have registered it
+	if apierrors.IsNotFound(err) || apierrors.IsForbidden(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error getting validating webhook configuration: %w", err)
+	}
+	for key, value := range managedByLabel {
+		if existing.Labels[key] != value {
+			return nil
+		}
+	}
+	klog.Infof("deleting validating webhook configuration %s", service)
+	err = configurations.Delete(ctx, service, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("error deleting validating webhook configuration: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package admission
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/webb-ai/k8s-agent/pkg/api"
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/pointer"
+)
+
+type recordingClient struct {
+	api.NoOpClient
+	mutex  sync.Mutex
+	events []*api.ChangeEvent
+}
+
+func (r *recordingClient) SendChangeEvent(event *api.ChangeEvent) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *recordingClient) recorded() []*api.ChangeEvent {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.events
+}
+
+func TestCertificates(t *testing.T) {
+	dnsNames := ServiceDNSNames("webbai-agent-admission", "webbai")
+	data, err := GenerateCertificates(dnsNames)()
+	assert.NoError(t, err)
+
+	_, caBundle, err := LoadCertificates(data, dnsNames)
+	assert.NoError(t, err)
+	assert.Equal(t, data[CACertKey], caBundle)
+
+	_, _, err = LoadCertificates(data, ServiceDNSNames("other", "webbai"))
+	assert.Error(t, err)
+}
+
+func TestWebhookAllowsAndRecords(t *testing.T) {
+	client := &recordingClient{}
+	webhook := NewWebhook(fake.NewSimpleClientset(), "webbai", "webbai-agent-admission", 443, ":9443",
+		nil, nil, zerolog.Nop(), client)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go webhook.process(ctx)
+
+	review := &admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "request-1",
+			Resource:  metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+			Namespace: "default",
+			Name:      "nginx",
+			Operation: admissionv1.Update,
+			DryRun:    pointer.Bool(true),
+			UserInfo:  authenticationv1.UserInfo{Username: "jane@example.com"},
+			OldObject: runtime.RawExtension{Raw: []byte(`{"kind":"Deployment","metadata":{"name":"nginx"},"spec":{"replicas":1}}`)},
+			Object:    runtime.RawExtension{Raw: []byte(`{"kind":"Deployment","metadata":{"name":"nginx"},"spec":{"replicas":5}}`)},
+		},
+	}
+	body, err := json.Marshal(review)
+	assert.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	webhook.handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, reviewPath, bytes.NewReader(body)))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	var response admissionv1.AdmissionReview
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.True(t, response.Response.Allowed)
+	assert.Equal(t, review.Request.UID, response.Response.UID)
+
+	assert.Eventually(t, func() bool { return len(client.recorded()) == 1 }, time.Second, 10*time.Millisecond)
+	event := client.recorded()[0]
+	assert.Equal(t, api.ChangeIntent, event.EventType)
+	assert.Equal(t, &api.Intent{
+		RequestUid:    "request-1",
+		Operation:     "UPDATE",
+		DryRun:        true,
+		Username:      "jane@example.com",
+		Resource:      "apps/v1/deployments",
+		Namespace:     "default",
+		Name:          "nginx",
+		ChangedFields: []string{".spec.replicas"},
+	}, event.Intent)
+}
+
+func TestRegister(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	webhook := NewWebhook(clientset, "webbai", "webbai-agent-admission", 443, ":9443",
+		[]schema.GroupVersionResource{{Group: "apps", Version: "v1", Resource: "deployments"}}, []string{"kube-system"},
+		zerolog.Nop(), &api.NoOpClient{})
+
+	// registering twice updates the configuration, e.g. with a new CA bundle
+	assert.NoError(t, webhook.register(context.Background(), []byte("ca-1")))
+	assert.NoError(t, webhook.register(context.Background(), []byte("ca-2")))
+
+	configuration, err := clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().
+		Get(context.Background(), "webbai-agent-admission", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, configuration.Webhooks, 1)
+	assert.Equal(t, []byte("ca-2"), configuration.Webhooks[0].ClientConfig.CABundle)
+	assert.Equal(t, "Ignore", string(*configuration.Webhooks[0].FailurePolicy))
+	assert.Equal(t, []string{"kube-system"}, configuration.Webhooks[0].NamespaceSelector.MatchExpressions[0].Values)
+}
+
+func TestUnregister(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	webhook := NewWebhook(clientset, "webbai", "webbai-agent-admission", 443, ":9443",
+		[]schema.GroupVersionResource{{Group: "apps", Version: "v1", Resource: "deployments"}}, nil,
+		zerolog.Nop(), &api.NoOpClient{})
+	configurations := clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+
+	assert.NoError(t, Unregister(context.Background(), clientset, "webbai-agent-admission"))
+	assert.NoError(t, webhook.register(context.Background(), []byte("ca")))
+	assert.NoError(t, Unregister(context.Background(), clientset, "webbai-agent-admission"))
+	_, err := configurations.Get(context.Background(), "webbai-agent-admission", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+
+	// configurations not registered by the agent are kept
+	_, err = configurations.Create(context.Background(), &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "webbai-agent-admission"},
+	}, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	assert.NoError(t, Unregister(context.Background(), clientset, "webbai-agent-admission"))
+	_, err = configurations.Get(context.Background(), "webbai-agent-admission", metav1.GetOptions{})
+	assert.NoError(t, err)
+}
+
+func TestWebhookDropsRequestsWhenBehind(t *testing.T) {
+	client := &recordingClient{}
+	webhook := NewWebhook(fake.NewSimpleClientset(), "webbai", "webbai-agent-admission", 443, ":9443",
+		nil, nil, zerolog.Nop(), client)
+	body, err := json.Marshal(&admissionv1.AdmissionReview{Request: &admissionv1.AdmissionRequest{UID: "request-1"}})
+	assert.NoError(t, err)
+
+	// without a worker, requests beyond the queue are still allowed but not recorded
+	for i := 0; i < queueSize+1; i++ {
+		recorder := httptest.NewRecorder()
+		webhook.handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, reviewPath, bytes.NewReader(body)))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	}
+	assert.Len(t, webhook.queue, queueSize)
+}
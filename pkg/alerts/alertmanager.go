@@ -0,0 +1,107 @@
+package alerts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/webb-ai/k8s-agent/pkg/api"
+	"k8s.io/klog/v2"
+)
+
+// AlertmanagerSource is the issue source of alertmanager notifications
+const AlertmanagerSource = "alertmanager"
+
+// AlertmanagerWebhook is the version 4 payload of the alertmanager webhook receiver
+type AlertmanagerWebhook struct {
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	TruncatedAlerts   int               `json:"truncatedAlerts"`
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Alerts            []Alert           `json:"alerts"`
+}
+
+type Alert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+// AlertmanagerReceiver parses alertmanager webhook notifications
+type AlertmanagerReceiver struct{}
+
+func (r *AlertmanagerReceiver) Source() string {
+	return AlertmanagerSource
+}
+
+func (r *AlertmanagerReceiver) Parse(body []byte) (string, []*api.Issue, error) {
+	var webhook AlertmanagerWebhook
+	if err := json.Unmarshal(body, &webhook); err != nil {
+		return "", nil, fmt.Errorf("error decoding alertmanager notification: %w", err)
+	}
+	if webhook.TruncatedAlerts > 0 {
+		klog.Warningf("alertmanager truncated %d alerts of group %s", webhook.TruncatedAlerts, webhook.GroupKey)
+	}
+	return webhook.GroupKey, webhook.Issues(), nil
+}
+
+// Issues converts the alerts of the notification to issues
+func (w *AlertmanagerWebhook) Issues() []*api.Issue {
+	issues := make([]*api.Issue, 0, len(w.Alerts))
+	for _, alert := range w.Alerts {
+		fingerprint := alert.Fingerprint
+		if fingerprint == "" {
+			fingerprint = LabelsFingerprint(alert.Labels)
+		}
+		issues = append(issues, &api.Issue{
+			Fingerprint:  fingerprint,
+			Name:         alert.Labels["alertname"],
+			Status:       NormalizeStatus(alert.Status),
+			Severity:     NormalizeSeverity(alert.Labels["severity"]),
+			Labels:       alert.Labels,
+			Annotations:  alert.Annotations,
+			StartsAt:     unixOrZero(alert.StartsAt),
+			EndsAt:       unixOrZero(alert.EndsAt),
+			GeneratorURL: alert.GeneratorURL,
+			GroupKey:     w.GroupKey,
+		})
+	}
+	return issues
+}
+
+// LabelsFingerprint identifies an alert by its labels, for sources that do not fingerprint alerts
+func LabelsFingerprint(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hash := sha256.New()
+	for _, name := range names {
+		hash.Write([]byte(name))
+		hash.Write([]byte{0xff})
+		hash.Write([]byte(labels[name]))
+		hash.Write([]byte{0xff})
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// unixOrZero returns 0 for the zero time, which alertmanager uses for the end of firing alerts
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() || t.Unix() <= 0 {
+		return 0
+	}
+	return t.Unix()
+}
@@ -0,0 +1,94 @@
+package alerts
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/webb-ai/k8s-agent/pkg/api"
+)
+
+// DatadogSource is the issue source of datadog monitor notifications
+const DatadogSource = "datadog"
+
+// DatadogWebhook is the payload of the datadog webhook integration. Datadog payloads are templates,
+// this is the template documented in the README, with every field a template variable.
+type DatadogWebhook struct {
+	Id              string `json:"id"`
+	AlertId         string `json:"alert_id"`
+	Title           string `json:"title"`
+	Body            string `json:"body"`
+	AlertTransition string `json:"alert_transition"`
+	AlertStatus     string `json:"alert_status"`
+	AlertType       string `json:"alert_type"`
+	AlertScope      string `json:"alert_scope"`
+	Priority        string `json:"priority"`
+	Tags            string `json:"tags"`
+	Date            string `json:"date"`
+	Link            string `json:"link"`
+	AggregKey       string `json:"aggreg_key"`
+}
+
+// DatadogReceiver parses datadog webhook notifications
+type DatadogReceiver struct{}
+
+func (r *DatadogReceiver) Source() string {
+	return DatadogSource
+}
+
+func (r *DatadogReceiver) Parse(body []byte) (string, []*api.Issue, error) {
+	var webhook DatadogWebhook
+	if err := json.Unmarshal(body, &webhook); err != nil {
+		return "", nil, fmt.Errorf("error decoding datadog notification: %w", err)
+	}
+
+	labels := parseDatadogTags(webhook.Tags)
+	labels["monitor_id"] = webhook.AlertId
+	if webhook.AlertScope != "" {
+		labels["scope"] = webhook.AlertScope
+	}
+
+	status := api.StatusFiring
+	if webhook.AlertTransition == "Recovered" || webhook.AlertType == "success" {
+		status = api.StatusResolved
+	}
+	severity := webhook.AlertType
+	if severity == "success" {
+		severity = ""
+	}
+
+	issue := &api.Issue{
+		// a multi alert monitor notifies every scope separately
+		Fingerprint:  LabelsFingerprint(map[string]string{"monitor_id": webhook.AlertId, "scope": webhook.AlertScope}),
+		Name:         webhook.Title,
+		Status:       status,
+		Severity:     NormalizeSeverity(severity),
+		Labels:       labels,
+		Annotations:  map[string]string{"description": webhook.Body},
+		GeneratorURL: webhook.Link,
+	}
+	if date, err := strconv.ParseInt(webhook.Date, 10, 64); err == nil {
+		// milliseconds since epoch
+		if status == api.StatusResolved {
+			issue.EndsAt = date / 1000
+		} else {
+			issue.StartsAt = date / 1000
+		}
+	}
+	return "", []*api.Issue{issue}, nil
+}
+
+// parseDatadogTags parses comma separated key:value tags, tags without value are set to ""
+func parseDatadogTags(tags string) map[string]string {
+	labels := make(map[string]string)
+	for _, tag := range strings.Split(tags, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(tag, ":")
+		labels[key] = value
+	}
+	return labels
+}
@@ -0,0 +1,203 @@
+package alerts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/webb-ai/k8s-agent/pkg/api"
+	"k8s.io/client-go/util/jsonpath"
+	"sigs.k8s.io/yaml"
+)
+
+// GenericConfig is the file configuring generic receivers
+type GenericConfig struct {
+	Receivers []GenericReceiverConfig `json:"receivers"`
+}
+
+// GenericReceiverConfig maps the fields of a json payload to an issue with JSONPath templates, e.g. {.alert.title}.
+// Templates are evaluated on every item selected by Items, or on the whole payload if it's empty.
+type GenericReceiverConfig struct {
+	// Name is the issue source and the path of the receiver
+	Name         string            `json:"name"`
+	Items        string            `json:"items,omitempty"`
+	Fingerprint  string            `json:"fingerprint,omitempty"`
+	Title        string            `json:"title"`
+	Status       string            `json:"status,omitempty"`
+	Severity     string            `json:"severity,omitempty"`
+	StartsAt     string            `json:"startsAt,omitempty"`
+	EndsAt       string            `json:"endsAt,omitempty"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// GenericReceiver parses json payloads of any alert source as configured
+type GenericReceiver struct {
+	name         string
+	items        *jsonpath.JSONPath
+	fingerprint  *jsonpath.JSONPath
+	title        *jsonpath.JSONPath
+	status       *jsonpath.JSONPath
+	severity     *jsonpath.JSONPath
+	startsAt     *jsonpath.JSONPath
+	endsAt       *jsonpath.JSONPath
+	generatorURL *jsonpath.JSONPath
+	labels       map[string]*jsonpath.JSONPath
+	annotations  map[string]*jsonpath.JSONPath
+}
+
+// LoadGenericReceivers loads the generic receivers configured in a yaml file
+func LoadGenericReceivers(file string) ([]Receiver, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading generic receivers from %s: %w", file, err)
+	}
+	var config GenericConfig
+	if err := yaml.UnmarshalStrict(content, &config); err != nil {
+		return nil, fmt.Errorf("error parsing generic receivers from %s: %w", file, err)
+	}
+	receivers := make([]Receiver, 0, len(config.Receivers))
+	for _, receiverConfig := range config.Receivers {
+		receiver, err := NewGenericReceiver(receiverConfig)
+		if err != nil {
+			return nil, err
+		}
+		receivers = append(receivers, receiver)
+	}
+	return receivers, nil
+}
+
+func NewGenericReceiver(config GenericReceiverConfig) (*GenericReceiver, error) {
+	if config.Name == "" || config.Title == "" {
+		return nil, fmt.Errorf("generic receivers need a name and a title")
+	}
+	receiver := &GenericReceiver{
+		name:        config.Name,
+		labels:      make(map[string]*jsonpath.JSONPath, len(config.Labels)),
+		annotations: make(map[string]*jsonpath.JSONPath, len(config.Annotations)),
+	}
+	var err error
+	parse := func(field, template string) *jsonpath.JSONPath {
+		if template == "" || err != nil {
+			return nil
+		}
+		path := jsonpath.New(config.Name + "." + field).AllowMissingKeys(true)
+		if parseErr := path.Parse(template); parseErr != nil {
+			err = fmt.Errorf("error parsing %s of generic receiver %s: %w", field, config.Name, parseErr)
+		}
+		return path
+	}
+	receiver.items = parse("items", config.Items)
+	receiver.fingerprint = parse("fingerprint", config.Fingerprint)
+	receiver.title = parse("title", config.Title)
+	receiver.status = parse("status", config.Status)
+	receiver.severity = parse("severity", config.Severity)
+	receiver.startsAt = parse("startsAt", config.StartsAt)
+	receiver.endsAt = parse("endsAt", config.EndsAt)
+	receiver.generatorURL = parse("generatorURL", config.GeneratorURL)
+	for name, template := range config.Labels {
+		receiver.labels[name] = parse("labels."+name, template)
+	}
+	for name, template := range config.Annotations {
+		receiver.annotations[name] = parse("annotations."+name, template)
+	}
+	return receiver, err
+}
+
+func (r *GenericReceiver) Source() string {
+	return r.name
+}
+
+func (r *GenericReceiver) Parse(body []byte) (string, []*api.Issue, error) {
+	var payload interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", nil, fmt.Errorf("error decoding %s notification: %w", r.name, err)
+	}
+
+	items := []interface{}{payload}
+	if r.items != nil {
+		results, err := r.items.FindResults(payload)
+		if err != nil {
+			return "", nil, fmt.Errorf("error selecting items of %s notification: %w", r.name, err)
+		}
+		items = nil
+		for _, result := range results {
+			for _, value := range result {
+				items = append(items, value.Interface())
+			}
+		}
+	}
+
+	issues := make([]*api.Issue, 0, len(items))
+	for _, item := range items {
+		issue := &api.Issue{
+			Name:         evaluate(r.title, item),
+			Status:       NormalizeStatus(evaluate(r.status, item)),
+			Severity:     NormalizeSeverity(evaluate(r.severity, item)),
+			Labels:       evaluateAll(r.labels, item),
+			Annotations:  evaluateAll(r.annotations, item),
+			StartsAt:     parseTime(evaluate(r.startsAt, item)),
+			EndsAt:       parseTime(evaluate(r.endsAt, item)),
+			GeneratorURL: evaluate(r.generatorURL, item),
+		}
+		issue.Fingerprint = evaluate(r.fingerprint, item)
+		if issue.Fingerprint == "" {
+			fingerprintLabels := map[string]string{"__name__": issue.Name}
+			for name, value := range issue.Labels {
+				fingerprintLabels[name] = value
+			}
+			issue.Fingerprint = LabelsFingerprint(fingerprintLabels)
+		}
+		issues = append(issues, issue)
+	}
+	return "", issues, nil
+}
+
+func evaluate(path *jsonpath.JSONPath, item interface{}) string {
+	if path == nil {
+		return ""
+	}
+	var buffer bytes.Buffer
+	if err := path.Execute(&buffer, item); err != nil {
+		return ""
+	}
+	return buffer.String()
+}
+
+func evaluateAll(paths map[string]*jsonpath.JSONPath, item interface{}) map[string]string {
+	if len(paths) == 0 {
+		return nil
+	}
+	values := make(map[string]string, len(paths))
+	for name, path := range paths {
+		if value := evaluate(path, item); value != "" {
+			values[name] = value
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
+
+// parseTime parses RFC 3339 timestamps, and seconds or milliseconds since epoch
+func parseTime(value string) int64 {
+	if value == "" {
+		return 0
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return unixOrZero(t)
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0
+	}
+	if number > 1e12 {
+		return int64(number / 1000)
+	}
+	return int64(number)
+}
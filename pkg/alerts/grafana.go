@@ -0,0 +1,66 @@
+package alerts
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/webb-ai/k8s-agent/pkg/api"
+)
+
+// GrafanaSource is the issue source of grafana unified alerting notifications
+const GrafanaSource = "grafana"
+
+// GrafanaWebhook is the payload of the grafana unified alerting webhook contact point,
+// an alertmanager payload with grafana specific fields
+type GrafanaWebhook struct {
+	AlertmanagerWebhook
+	OrgId   int64          `json:"orgId"`
+	Title   string         `json:"title"`
+	State   string         `json:"state"`
+	Message string         `json:"message"`
+	Alerts  []GrafanaAlert `json:"alerts"`
+}
+
+type GrafanaAlert struct {
+	Alert
+	SilenceURL   string             `json:"silenceURL"`
+	DashboardURL string             `json:"dashboardURL"`
+	PanelURL     string             `json:"panelURL"`
+	Values       map[string]float64 `json:"values"`
+	ValueString  string             `json:"valueString"`
+}
+
+// GrafanaReceiver parses grafana webhook notifications
+type GrafanaReceiver struct{}
+
+func (r *GrafanaReceiver) Source() string {
+	return GrafanaSource
+}
+
+func (r *GrafanaReceiver) Parse(body []byte) (string, []*api.Issue, error) {
+	var webhook GrafanaWebhook
+	if err := json.Unmarshal(body, &webhook); err != nil {
+		return "", nil, fmt.Errorf("error decoding grafana notification: %w", err)
+	}
+
+	webhook.AlertmanagerWebhook.Alerts = make([]Alert, 0, len(webhook.Alerts))
+	for _, alert := range webhook.Alerts {
+		webhook.AlertmanagerWebhook.Alerts = append(webhook.AlertmanagerWebhook.Alerts, alert.Alert)
+	}
+	issues := webhook.AlertmanagerWebhook.Issues()
+	for i, alert := range webhook.Alerts {
+		// keep the links grafana adds to its alerts, without the values that change with every evaluation
+		annotations := make(map[string]string, len(alert.Annotations)+2)
+		for key, value := range alert.Annotations {
+			annotations[key] = value
+		}
+		if alert.DashboardURL != "" {
+			annotations["dashboard_url"] = alert.DashboardURL
+		}
+		if alert.PanelURL != "" {
+			annotations["panel_url"] = alert.PanelURL
+		}
+		issues[i].Annotations = annotations
+	}
+	return webhook.GroupKey, issues, nil
+}
@@ -0,0 +1,98 @@
+package alerts
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/webb-ai/k8s-agent/pkg/api"
+)
+
+// PagerDutySource is the issue source of pagerduty incident notifications
+const PagerDutySource = "pagerduty"
+
+// PagerDutyWebhook is the payload of pagerduty v3 webhook subscriptions
+type PagerDutyWebhook struct {
+	Event PagerDutyEvent `json:"event"`
+}
+
+type PagerDutyEvent struct {
+	Id           string            `json:"id"`
+	EventType    string            `json:"event_type"`
+	ResourceType string            `json:"resource_type"`
+	OccurredAt   time.Time         `json:"occurred_at"`
+	Data         PagerDutyIncident `json:"data"`
+}
+
+type PagerDutyIncident struct {
+	Id          string              `json:"id"`
+	Type        string              `json:"type"`
+	HtmlUrl     string              `json:"html_url"`
+	Number      int                 `json:"number"`
+	Status      string              `json:"status"`
+	IncidentKey string              `json:"incident_key"`
+	CreatedAt   time.Time           `json:"created_at"`
+	Title       string              `json:"title"`
+	Urgency     string              `json:"urgency"`
+	Service     *PagerDutyReference `json:"service"`
+	Priority    *PagerDutyReference `json:"priority"`
+}
+
+type PagerDutyReference struct {
+	Id      string `json:"id"`
+	Summary string `json:"summary"`
+}
+
+// PagerDutyReceiver parses pagerduty webhook notifications, only incident events are turned into issues
+type PagerDutyReceiver struct{}
+
+func (r *PagerDutyReceiver) Source() string {
+	return PagerDutySource
+}
+
+func (r *PagerDutyReceiver) Parse(body []byte) (string, []*api.Issue, error) {
+	var webhook PagerDutyWebhook
+	if err := json.Unmarshal(body, &webhook); err != nil {
+		return "", nil, fmt.Errorf("error decoding pagerduty notification: %w", err)
+	}
+	event := webhook.Event
+	if event.ResourceType != "incident" {
+		return "", nil, nil
+	}
+	incident := event.Data
+
+	status := NormalizeStatus(incident.Status)
+	if event.EventType == "incident.resolved" {
+		status = api.StatusResolved
+	}
+	severity := incident.Urgency
+	labels := map[string]string{"incident_number": fmt.Sprint(incident.Number)}
+	if incident.Priority != nil && incident.Priority.Summary != "" {
+		severity = incident.Priority.Summary
+		labels["priority"] = incident.Priority.Summary
+	}
+	if incident.Service != nil {
+		labels["service"] = incident.Service.Summary
+	}
+	if incident.Urgency != "" {
+		labels["urgency"] = incident.Urgency
+	}
+	if incident.IncidentKey != "" {
+		labels["incident_key"] = incident.IncidentKey
+	}
+
+	issue := &api.Issue{
+		Fingerprint:  incident.Id,
+		Name:         incident.Title,
+		Status:       status,
+		Severity:     NormalizeSeverity(severity),
+		Labels:       labels,
+		StartsAt:     unixOrZero(incident.CreatedAt),
+		GeneratorURL: incident.HtmlUrl,
+	}
+	if status == api.StatusResolved {
+		issue.EndsAt = unixOrZero(event.OccurredAt)
+	}
+	// acknowledgements and reassignments do not change the issue, the tracker drops them
+	return "", []*api.Issue{issue}, nil
+}
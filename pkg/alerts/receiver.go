@@ -0,0 +1,93 @@
+package alerts
+
+import (
+	"strings"
+
+	"github.com/webb-ai/k8s-agent/pkg/api"
+)
+
+// Receiver parses the notifications of an alert source into issues
+type Receiver interface {
+	// Source is the issue source of the parsed issues, receivers are served at /<source>
+	Source() string
+	// Parse decodes a notification. groupKey identifies the issues notified together, it may be empty.
+	Parse(body []byte) (groupKey string, issues []*api.Issue, err error)
+}
+
+// DefaultReceivers are the receivers of the supported alert sources
+func DefaultReceivers() []Receiver {
+	return []Receiver{
+		&AlertmanagerReceiver{},
+		&GrafanaReceiver{},
+		&DatadogReceiver{},
+		&PagerDutyReceiver{},
+	}
+}
+
+// Severities of the common issue model
+const (
+	SeverityCritical = "critical"
+	SeverityError    = "error"
+	SeverityWarning  = "warning"
+	SeverityInfo     = "info"
+)
+
+var severities = map[string]string{
+	"critical":      SeverityCritical,
+	"crit":          SeverityCritical,
+	"fatal":         SeverityCritical,
+	"emergency":     SeverityCritical,
+	"alert":         SeverityCritical,
+	"page":          SeverityCritical,
+	"p1":            SeverityCritical,
+	"sev1":          SeverityCritical,
+	"error":         SeverityError,
+	"err":           SeverityError,
+	"high":          SeverityError,
+	"major":         SeverityError,
+	"p2":            SeverityError,
+	"sev2":          SeverityError,
+	"warning":       SeverityWarning,
+	"warn":          SeverityWarning,
+	"medium":        SeverityWarning,
+	"minor":         SeverityWarning,
+	"p3":            SeverityWarning,
+	"sev3":          SeverityWarning,
+	"info":          SeverityInfo,
+	"informational": SeverityInfo,
+	"notice":        SeverityInfo,
+	"low":           SeverityInfo,
+	"none":          SeverityInfo,
+	"success":       SeverityInfo,
+	"p4":            SeverityInfo,
+	"p5":            SeverityInfo,
+	"sev4":          SeverityInfo,
+	"sev5":          SeverityInfo,
+}
+
+// NormalizeSeverity maps the severities and priorities of alert sources to critical, error, warning or info.
+// Unknown severities are returned as is.
+func NormalizeSeverity(severity string) string {
+	if normalized, ok := severities[strings.ToLower(strings.TrimSpace(severity))]; ok {
+		return normalized
+	}
+	return severity
+}
+
+var resolvedStatuses = map[string]struct{}{
+	"resolved":  {},
+	"recovered": {},
+	"recovery":  {},
+	"ok":        {},
+	"normal":    {},
+	"closed":    {},
+	"inactive":  {},
+}
+
+// NormalizeStatus maps the states of alert sources to firing or resolved
+func NormalizeStatus(status string) api.IssueStatus {
+	if _, ok := resolvedStatuses[strings.ToLower(strings.TrimSpace(status))]; ok {
+		return api.StatusResolved
+	}
+	return api.StatusFiring
+}
@@ -0,0 +1,128 @@
+package alerts
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webb-ai/k8s-agent/pkg/api"
+)
+
+func TestNormalize(t *testing.T) {
+	assert.Equal(t, SeverityCritical, NormalizeSeverity("P1"))
+	assert.Equal(t, SeverityError, NormalizeSeverity("high"))
+	assert.Equal(t, SeverityWarning, NormalizeSeverity(" Warn "))
+	assert.Equal(t, "custom", NormalizeSeverity("custom"))
+	assert.Equal(t, api.StatusResolved, NormalizeStatus("OK"))
+	assert.Equal(t, api.StatusFiring, NormalizeStatus("alerting"))
+}
+
+func TestGrafanaReceiver(t *testing.T) {
+	_, issues, err := (&GrafanaReceiver{}).Parse([]byte(`{
+	  "receiver": "webbai", "status": "firing", "orgId": 1, "groupKey": "{}/{}:{alertname=\"DiskFull\"}",
+	  "title": "[FIRING:1] DiskFull", "state": "alerting",
+	  "alerts": [{
+	    "status": "firing",
+	    "labels": {"alertname": "DiskFull", "severity": "warn", "node": "node-1"},
+	    "annotations": {"summary": "disk 95% full"},
+	    "startsAt": "2023-10-01T10:00:00Z",
+	    "endsAt": "0001-01-01T00:00:00Z",
+	    "fingerprint": "8f3c",
+	    "dashboardURL": "http://grafana/d/abc",
+	    "values": {"B": 95.2},
+	    "valueString": "[ var='B' value=95.2 ]"
+	  }]
+	}`))
+	assert.NoError(t, err)
+	assert.Len(t, issues, 1)
+	assert.Equal(t, "8f3c", issues[0].Fingerprint)
+	assert.Equal(t, SeverityWarning, issues[0].Severity)
+	assert.Equal(t, map[string]string{"summary": "disk 95% full", "dashboard_url": "http://grafana/d/abc"}, issues[0].Annotations)
+}
+
+func TestDatadogReceiver(t *testing.T) {
+	payload := `{"id": "1", "alert_id": "123", "title": "CPU high", "body": "cpu above 90 percent",
+	  "alert_transition": "%s", "alert_type": "%s", "alert_scope": "host:web-1",
+	  "tags": "env:prod,kube_namespace:shop,team", "date": "1696154400000", "link": "https://app.datadoghq.com/monitors/123"}`
+	receiver := &DatadogReceiver{}
+
+	_, triggered, err := receiver.Parse([]byte(fmt.Sprintf(payload, "Triggered", "error")))
+	assert.NoError(t, err)
+	assert.Equal(t, api.StatusFiring, triggered[0].Status)
+	assert.Equal(t, SeverityError, triggered[0].Severity)
+	assert.Equal(t, int64(1696154400), triggered[0].StartsAt)
+	assert.Equal(t, map[string]string{"env": "prod", "kube_namespace": "shop", "team": "", "monitor_id": "123", "scope": "host:web-1"}, triggered[0].Labels)
+
+	_, recovered, err := receiver.Parse([]byte(fmt.Sprintf(payload, "Recovered", "success")))
+	assert.NoError(t, err)
+	assert.Equal(t, api.StatusResolved, recovered[0].Status)
+	assert.Equal(t, triggered[0].Fingerprint, recovered[0].Fingerprint)
+}
+
+func TestPagerDutyReceiver(t *testing.T) {
+	_, issues, err := (&PagerDutyReceiver{}).Parse([]byte(`{"event": {
+	  "id": "01", "event_type": "incident.resolved", "resource_type": "incident", "occurred_at": "2023-10-01T11:00:00Z",
+	  "data": {"id": "PGR0VU2", "type": "incident", "html_url": "https://acme.pagerduty.com/incidents/PGR0VU2", "number": 2,
+	    "status": "resolved", "created_at": "2023-10-01T10:00:00Z", "title": "checkout is down", "urgency": "high",
+	    "service": {"id": "PF9KMXH", "summary": "checkout"}, "priority": {"id": "P1", "summary": "P1"}}
+	}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, &api.Issue{
+		Fingerprint:  "PGR0VU2",
+		Name:         "checkout is down",
+		Status:       api.StatusResolved,
+		Severity:     SeverityCritical,
+		Labels:       map[string]string{"incident_number": "2", "priority": "P1", "service": "checkout", "urgency": "high"},
+		StartsAt:     time.Date(2023, 10, 1, 10, 0, 0, 0, time.UTC).Unix(),
+		EndsAt:       time.Date(2023, 10, 1, 11, 0, 0, 0, time.UTC).Unix(),
+		GeneratorURL: "https://acme.pagerduty.com/incidents/PGR0VU2",
+	}, issues[0])
+}
+
+func TestGenericReceiver(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "receivers.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte(`
+receivers:
+  - name: opsgenie
+    items: "{.alerts[*]}"
+    fingerprint: "{.id}"
+    title: "{.message}"
+    status: "{.state}"
+    severity: "{.priority}"
+    startsAt: "{.createdAt}"
+    labels:
+      team: "{.owner.team}"
+      namespace: "{.details.namespace}"
+`), 0o600))
+	receivers, err := LoadGenericReceivers(file)
+	assert.NoError(t, err)
+	assert.Len(t, receivers, 1)
+	assert.Equal(t, "opsgenie", receivers[0].Source())
+
+	_, issues, err := receivers[0].Parse([]byte(`{"alerts": [
+	  {"id": "a1", "message": "queue backlog", "state": "open", "priority": "P2", "createdAt": 1696154400000,
+	   "owner": {"team": "payments"}, "details": {"namespace": "shop"}},
+	  {"id": "a2", "message": "cert expiry", "state": "closed", "priority": "P4", "createdAt": "2023-10-01T10:00:00Z"}
+	]}`))
+	assert.NoError(t, err)
+	assert.Equal(t, []*api.Issue{
+		{
+			Fingerprint: "a1",
+			Name:        "queue backlog",
+			Status:      api.StatusFiring,
+			Severity:    SeverityError,
+			Labels:      map[string]string{"team": "payments", "namespace": "shop"},
+			StartsAt:    1696154400,
+		},
+		{
+			Fingerprint: "a2",
+			Name:        "cert expiry",
+			Status:      api.StatusResolved,
+			Severity:    SeverityInfo,
+			StartsAt:    time.Date(2023, 10, 1, 10, 0, 0, 0, time.UTC).Unix(),
+		},
+	}, issues)
+}
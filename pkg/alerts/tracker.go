@@ -0,0 +1,171 @@
+package alerts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/webb-ai/k8s-agent/pkg/api"
+)
+
+// IssueEvent is a change of state of an issue
+type IssueEvent struct {
+	Type  api.IssueEventType
+	Issue *api.Issue
+}
+
+type trackedGroup struct {
+	state    string
+	lastSeen time.Time
+}
+
+type trackedIssue struct {
+	state    string
+	status   api.IssueStatus
+	lastSeen time.Time
+}
+
+// Tracker tracks the state of issues by fingerprint, and turns repeated notifications into
+// opened, updated and resolved events. Notifications of a group identical to the previous one are suppressed.
+type Tracker struct {
+	expiry time.Duration
+	state  func(*api.Issue) string
+
+	mutex  sync.Mutex
+	issues map[string]*trackedIssue
+	groups map[string]trackedGroup
+	now    func() time.Time
+}
+
+// NewTracker creates a tracker forgetting issues not notified for longer than expiry,
+// which should exceed the repeat interval of the alert sources
+func NewTracker(expiry time.Duration) *Tracker {
+	return NewStateTracker(expiry, IssueState)
+}
+
+// NewStateTracker creates a tracker telling notifications of an issue apart by state, e.g. to leave out annotations
+// changing with every notification
+func NewStateTracker(expiry time.Duration, state func(*api.Issue) string) *Tracker {
+	return &Tracker{
+		expiry: expiry,
+		state:  state,
+		issues: make(map[string]*trackedIssue),
+		groups: make(map[string]trackedGroup),
+		now:    time.Now,
+	}
+}
+
+// Track sends the events of the issues of a notification from source. groupKey identifies the group of issues
+// notified together, it may be empty if the source does not group issues. The state of an issue is only recorded
+// once its event is sent, so that events failing to send are sent again on the next notification.
+func (t *Tracker) Track(source, groupKey string, issues []*api.Issue, send func(IssueEvent) error) error {
+	t.mutex.Lock()
+	now := t.now()
+	t.expire(now)
+
+	var groupState string
+	if groupKey != "" {
+		groupState = t.issuesState(issues)
+		groupKey = source + "/" + groupKey
+		if previous, found := t.groups[groupKey]; found && previous.state == groupState {
+			t.groups[groupKey] = trackedGroup{state: groupState, lastSeen: now}
+			// still refresh the issues so they do not expire while the group is re-sent
+			for _, issue := range issues {
+				if tracked, ok := t.issues[source+"/"+issue.Fingerprint]; ok {
+					tracked.lastSeen = now
+				}
+			}
+			t.mutex.Unlock()
+			return nil
+		}
+	}
+
+	events := make([]*IssueEvent, len(issues))
+	for i, issue := range issues {
+		events[i] = t.event(t.issues[source+"/"+issue.Fingerprint], issue)
+	}
+	t.mutex.Unlock()
+
+	// events are sent without holding the lock, the client may be slow
+	var errs []error
+	sent := make([]bool, len(issues))
+	for i, event := range events {
+		if event == nil {
+			sent[i] = true
+		} else if err := send(*event); err != nil {
+			errs = append(errs, err)
+		} else {
+			sent[i] = true
+		}
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for i, issue := range issues {
+		if sent[i] {
+			t.issues[source+"/"+issue.Fingerprint] = &trackedIssue{state: t.state(issue), status: issue.Status, lastSeen: now}
+		}
+	}
+	if groupKey != "" && len(errs) == 0 {
+		t.groups[groupKey] = trackedGroup{state: groupState, lastSeen: now}
+	}
+	return errors.Join(errs...)
+}
+
+// event returns the event to send for a notification of an issue, or nil if nothing changed since it was tracked
+func (t *Tracker) event(tracked *trackedIssue, issue *api.Issue) *IssueEvent {
+	switch {
+	case issue.Status == api.StatusResolved && (tracked == nil || tracked.status != api.StatusResolved):
+		return &IssueEvent{Type: api.IssueResolved, Issue: issue}
+	case issue.Status == api.StatusResolved:
+		// already resolved
+		return nil
+	case tracked == nil || tracked.status == api.StatusResolved:
+		return &IssueEvent{Type: api.IssueOpened, Issue: issue}
+	case tracked.state != t.state(issue):
+		return &IssueEvent{Type: api.IssueUpdated, Issue: issue}
+	}
+	return nil
+}
+
+func (t *Tracker) expire(now time.Time) {
+	for fingerprint, tracked := range t.issues {
+		if now.Sub(tracked.lastSeen) > t.expiry {
+			delete(t.issues, fingerprint)
+		}
+	}
+	for groupKey, tracked := range t.groups {
+		if now.Sub(tracked.lastSeen) > t.expiry {
+			delete(t.groups, groupKey)
+		}
+	}
+}
+
+// IssueState is what makes a notification of an issue different from the previous one.
+// Times are left out, some sources set them to the time of the notification.
+func IssueState(issue *api.Issue) string {
+	state, _ := json.Marshal(struct {
+		Status      api.IssueStatus
+		Severity    string
+		Labels      map[string]string
+		Annotations map[string]string
+	}{issue.Status, issue.Severity, issue.Labels, issue.Annotations})
+	return string(state)
+}
+
+func (t *Tracker) issuesState(issues []*api.Issue) string {
+	states := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		states = append(states, issue.Fingerprint+t.state(issue))
+	}
+	sort.Strings(states)
+	hash := sha256.New()
+	for _, state := range states {
+		hash.Write([]byte(state))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,112 @@
+package alerts
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webb-ai/k8s-agent/pkg/api"
+)
+
+const alertmanagerPayload = `{
+  "version": "4",
+  "groupKey": "{}:{alertname=\"HighLatency\"}",
+  "truncatedAlerts": 0,
+  "status": "firing",
+  "receiver": "webbai",
+  "groupLabels": {"alertname": "HighLatency"},
+  "commonLabels": {"alertname": "HighLatency", "severity": "critical"},
+  "commonAnnotations": {},
+  "externalURL": "http://alertmanager:9093",
+  "alerts": [
+    {
+      "status": "firing",
+      "labels": {"alertname": "HighLatency", "severity": "critical", "namespace": "shop", "pod": "checkout-1"},
+      "annotations": {"summary": "p99 latency above 1s"},
+      "startsAt": "2023-10-01T10:00:00Z",
+      "endsAt": "0001-01-01T00:00:00Z",
+      "generatorURL": "http://prometheus:9090/graph",
+      "fingerprint": "c4d7e1b0a2f3e5d6"
+    }
+  ]
+}`
+
+func decodeAlertmanager(t *testing.T, payload string) *AlertmanagerWebhook {
+	t.Helper()
+	var webhook AlertmanagerWebhook
+	assert.NoError(t, json.Unmarshal([]byte(payload), &webhook))
+	return &webhook
+}
+
+// track returns the types of the events sent for a notification
+func track(t *testing.T, tracker *Tracker, groupKey string, issues []*api.Issue) []api.IssueEventType {
+	var types []api.IssueEventType
+	assert.NoError(t, tracker.Track(AlertmanagerSource, groupKey, issues, func(event IssueEvent) error {
+		types = append(types, event.Type)
+		return nil
+	}))
+	return types
+}
+
+func TestAlertmanagerIssues(t *testing.T) {
+	issues := decodeAlertmanager(t, alertmanagerPayload).Issues()
+	assert.Equal(t, []*api.Issue{{
+		Fingerprint:  "c4d7e1b0a2f3e5d6",
+		Name:         "HighLatency",
+		Status:       api.StatusFiring,
+		Severity:     "critical",
+		Labels:       map[string]string{"alertname": "HighLatency", "severity": "critical", "namespace": "shop", "pod": "checkout-1"},
+		Annotations:  map[string]string{"summary": "p99 latency above 1s"},
+		StartsAt:     time.Date(2023, 10, 1, 10, 0, 0, 0, time.UTC).Unix(),
+		GeneratorURL: "http://prometheus:9090/graph",
+		GroupKey:     `{}:{alertname="HighLatency"}`,
+	}}, issues)
+}
+
+func TestTrackerLifecycle(t *testing.T) {
+	tracker := NewTracker(time.Hour)
+	now := time.Date(2023, 10, 1, 10, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return now }
+
+	webhook := decodeAlertmanager(t, alertmanagerPayload)
+	assert.Equal(t, []api.IssueEventType{api.IssueOpened}, track(t, tracker, webhook.GroupKey, webhook.Issues()))
+
+	// repeated notification of an unchanged group, with the end of the alert moved forward
+	webhook.Alerts[0].EndsAt = now.Add(5 * time.Minute)
+	assert.Empty(t, track(t, tracker, webhook.GroupKey, webhook.Issues()))
+
+	webhook.Alerts[0].Annotations = map[string]string{"summary": "p99 latency above 2s"}
+	assert.Equal(t, []api.IssueEventType{api.IssueUpdated}, track(t, tracker, webhook.GroupKey, webhook.Issues()))
+
+	webhook.Alerts[0].Status = "resolved"
+	assert.Equal(t, []api.IssueEventType{api.IssueResolved}, track(t, tracker, webhook.GroupKey, webhook.Issues()))
+	// without a group key, only issue state dedupes notifications
+	assert.Empty(t, track(t, tracker, "", webhook.Issues()))
+
+	webhook.Alerts[0].Status = "firing"
+	assert.Equal(t, []api.IssueEventType{api.IssueOpened}, track(t, tracker, webhook.GroupKey, webhook.Issues()))
+
+	// issues are forgotten after the expiry, and reopened
+	now = now.Add(2 * time.Hour)
+	assert.Equal(t, []api.IssueEventType{api.IssueOpened}, track(t, tracker, webhook.GroupKey, webhook.Issues()))
+}
+
+func TestTrackerRetriesFailedSends(t *testing.T) {
+	tracker := NewTracker(time.Hour)
+	webhook := decodeAlertmanager(t, alertmanagerPayload)
+
+	err := tracker.Track(AlertmanagerSource, webhook.GroupKey, webhook.Issues(), func(IssueEvent) error {
+		return fmt.Errorf("unavailable")
+	})
+	assert.Error(t, err)
+	// the repeated notification of the group sends the event again
+	assert.Equal(t, []api.IssueEventType{api.IssueOpened}, track(t, tracker, webhook.GroupKey, webhook.Issues()))
+	assert.Empty(t, track(t, tracker, webhook.GroupKey, webhook.Issues()))
+}
+
+func TestLabelsFingerprint(t *testing.T) {
+	assert.Equal(t, LabelsFingerprint(map[string]string{"a": "1", "b": "2"}), LabelsFingerprint(map[string]string{"b": "2", "a": "1"}))
+	assert.NotEqual(t, LabelsFingerprint(map[string]string{"a": "12"}), LabelsFingerprint(map[string]string{"a1": "2"}))
+}
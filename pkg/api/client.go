@@ -7,6 +7,7 @@ type Client interface {
 	SendK8sResources(*ResourceList) error
 	SendTrafficMetrics(*prompb.WriteRequest) error
 	SendIssue(*IssueRequest) error
+	SendDeployMarker(*DeployMarker) error
 	SendAgentInfo() error
 }
 
@@ -29,6 +30,10 @@ func (nc *NoOpClient) SendIssue(request *IssueRequest) error {
 	return nil
 }
 
+func (nc *NoOpClient) SendDeployMarker(*DeployMarker) error {
+	return nil
+}
+
 func (nc *NoOpClient) SendAgentInfo() error {
 	return nil
 }
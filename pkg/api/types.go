@@ -3,7 +3,11 @@ package api
 import (
 	"time"
 
+	"github.com/webb-ai/k8s-agent/pkg/helm"
+	"github.com/webb-ai/k8s-agent/pkg/provenance"
+	"github.com/webb-ai/k8s-agent/pkg/redact"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog/v2"
 
 	"github.com/webb-ai/k8s-agent/pkg/util"
 
@@ -17,29 +21,131 @@ const (
 	ObjectUpdate EventType = "object_update"
 	ObjectDelete EventType = "object_delete"
 	KafkaUpdate  EventType = "kafka_update"
+	HelmRelease  EventType = "helm_release"
+	ChangeIntent EventType = "change_intent"
+	// TopologyChange is an edge of the service dependency graph appearing or disappearing
+	TopologyChange EventType = "topology_change"
 )
 
-var RedactEnvVar = false
+// RedactionPolicy is applied to every change event, resource list and issue before it's sent
+var RedactionPolicy = redact.DefaultPolicy()
+
+// ProvenanceExtractor extracts the provenance of the objects of change events
+var ProvenanceExtractor = provenance.NewExtractor(nil)
 
 type ChangeEvent struct {
-	OldObject *unstructured.Unstructured `json:"old_object"`
-	NewObject *unstructured.Unstructured `json:"new_object"`
-	EventType EventType                  `json:"event_type"`
-	Time      int64                      `json:"time"`
+	OldObject    *unstructured.Unstructured `json:"old_object"`
+	NewObject    *unstructured.Unstructured `json:"new_object"`
+	EventType    EventType                  `json:"event_type"`
+	Time         int64                      `json:"time"`
+	SecretChange *SecretChange              `json:"secret_change,omitempty"`
+	HelmRelease  *HelmReleaseChange         `json:"helm_release,omitempty"`
+	// Attribution lists the field managers owning the fields changed by an update
+	Attribution []util.FieldAttribution `json:"attribution,omitempty"`
+	// Audit identifies who made the change, from the kube api server audit log
+	Audit *AuditInfo `json:"audit,omitempty"`
+	// Intent is the admission request of a change_intent event
+	Intent *Intent `json:"intent,omitempty"`
+	// DeployMarker is the CI/CD deployment that rolled out the images of a workload update
+	DeployMarker *DeployMarker `json:"deploy_marker,omitempty"`
+	// Provenance is where the object comes from, as recorded by deployment tools in the object
+	Provenance *provenance.Provenance `json:"provenance,omitempty"`
+	// Topology is the edge changed by a topology_change event
+	Topology *TopologyEdgeChange `json:"topology,omitempty"`
+
+	// changedFields are the fields changed by an update, diffed along with the attribution
+	changedFields []string
+}
+
+// DeployMarker is posted by a CI/CD pipeline when it deploys a version of a service
+type DeployMarker struct {
+	Service   string `json:"service"`
+	Namespace string `json:"namespace"`
+	// Workload is the name of the Deployment or StatefulSet rolled out, the service name if empty
+	Workload string `json:"workload,omitempty"`
+	// Version is the image tag deployed
+	Version     string `json:"version"`
+	Commit      string `json:"commit,omitempty"`
+	PullRequest string `json:"pull_request,omitempty"`
+	Author      string `json:"author,omitempty"`
+	PipelineUrl string `json:"pipeline_url,omitempty"`
+	Time        int64  `json:"time"`
+}
+
+// AuditInfo is the user and client of the request that made a change
+type AuditInfo struct {
+	AuditId   string   `json:"audit_id"`
+	Verb      string   `json:"verb"`
+	Username  string   `json:"username"`
+	Groups    []string `json:"groups,omitempty"`
+	UserAgent string   `json:"user_agent,omitempty"`
+	SourceIPs []string `json:"source_ips,omitempty"`
+	// Impersonator is the user that impersonated Username, if any
+	Impersonator string `json:"impersonator,omitempty"`
+}
+
+// Intent is a change as requested to the api server, before it's validated and persisted.
+// It's recorded whether the change is then rejected, fails or is a dry run.
+type Intent struct {
+	RequestUid    string   `json:"request_uid"`
+	Operation     string   `json:"operation"`
+	DryRun        bool     `json:"dry_run"`
+	Username      string   `json:"username"`
+	Groups        []string `json:"groups,omitempty"`
+	Resource      string   `json:"resource"`
+	SubResource   string   `json:"sub_resource,omitempty"`
+	Namespace     string   `json:"namespace,omitempty"`
+	Name          string   `json:"name,omitempty"`
+	ChangedFields []string `json:"changed_fields,omitempty"`
+}
+
+// SecretChange describes a change of secret data by salted hashes, since the data itself is never sent
+type SecretChange struct {
+	Keys    map[string]redact.KeyFingerprint `json:"keys"`
+	Added   []string                         `json:"added,omitempty"`
+	Removed []string                         `json:"removed,omitempty"`
+	Rotated []string                         `json:"rotated,omitempty"`
+}
+
+// HelmReleaseChange describes a helm release revision decoded from its release secret
+type HelmReleaseChange struct {
+	// Operation is the change of the release secret: object_add, object_update or object_delete
+	Operation       EventType            `json:"operation"`
+	Release         *helm.ReleaseSummary `json:"release"`
+	PreviousRelease *helm.ReleaseSummary `json:"previous_release,omitempty"`
+	// ValuesDiff compares the redacted computed values with the previous revision
+	ValuesDiff []helm.ValueChange `json:"values_diff,omitempty"`
 }
 
+// ReleaseLookup returns a revision of a helm release, it's used to diff a new revision against the previous one
+type ReleaseLookup func(namespace, name string, revision int) (*helm.Release, error)
+
 func NewK8sChangeEvent(oldObj, newObj *unstructured.Unstructured) *ChangeEvent {
-	util.PruneSecret(oldObj)
-	util.PruneSecret(newObj)
-	if RedactEnvVar {
-		util.RedactEnvVar(oldObj)
-		util.RedactEnvVar(newObj)
+	// objects are usually straight from the informer cache, never redact those in place
+	oldObj, newObj = oldObj.DeepCopy(), newObj.DeepCopy()
+	// fingerprint secrets before the redaction policy drops their data
+	secretChange := newSecretChange(oldObj, newObj)
+	// attribute changes before redaction too, so that both objects are diffed in the same state
+	changedFields, attribution := util.DiffObjects(oldObj, newObj)
+	// extract the provenance before redaction drops annotations
+	object := newObj
+	if object == nil {
+		object = oldObj
 	}
+	objectProvenance := ProvenanceExtractor.Extract(object)
+
+	RedactionPolicy.Apply(oldObj)
+	RedactionPolicy.Apply(newObj)
 	event := &ChangeEvent{
-		OldObject: oldObj,
-		NewObject: newObj,
-		EventType: ObjectUpdate,
-		Time:      time.Now().Unix(),
+		OldObject:    oldObj,
+		NewObject:    newObj,
+		EventType:    ObjectUpdate,
+		Time:         time.Now().Unix(),
+		SecretChange: secretChange,
+		Attribution:  attribution,
+		Provenance:   objectProvenance,
+
+		changedFields: changedFields,
 	}
 
 	if oldObj == nil {
@@ -60,6 +166,80 @@ func NewK8sChangeEvent(oldObj, newObj *unstructured.Unstructured) *ChangeEvent {
 	return event
 }
 
+func newSecretChange(oldObj, newObj *unstructured.Unstructured) *SecretChange {
+	if !util.IsSecret(oldObj) && !util.IsSecret(newObj) {
+		return nil
+	}
+	oldKeys := RedactionPolicy.FingerprintSecret(oldObj)
+	newKeys := RedactionPolicy.FingerprintSecret(newObj)
+	if oldKeys == nil && newKeys == nil {
+		return nil
+	}
+	change := &SecretChange{Keys: newKeys}
+	if newObj == nil {
+		change.Keys = oldKeys
+	}
+	change.Added, change.Removed, change.Rotated = redact.DiffFingerprints(oldKeys, newKeys)
+	return change
+}
+
+// NewHelmReleaseEvent creates a helm_release event from a change of a helm release secret. The release is decoded
+// and compared to the old secret for updates, or to the previous revision found by lookup for new revisions.
+func NewHelmReleaseEvent(oldSecret, newSecret *unstructured.Unstructured, lookup ReleaseLookup) (*ChangeEvent, error) {
+	operation := ObjectUpdate
+	current, previous := newSecret, oldSecret
+	switch {
+	case oldSecret == nil:
+		operation = ObjectAdd
+	case newSecret == nil:
+		operation = ObjectDelete
+		current, previous = oldSecret, nil
+	}
+
+	release, err := helm.ReleaseFromSecret(current)
+	if err != nil {
+		return nil, err
+	}
+	var previousRelease *helm.Release
+	if previous != nil {
+		previousRelease, err = helm.ReleaseFromSecret(previous)
+	} else if operation == ObjectAdd && release.Version > 1 && lookup != nil {
+		previousRelease, err = lookup(release.Namespace, release.Name, release.Version-1)
+	}
+	if err != nil {
+		klog.Warningf("unable to decode previous revision of helm release %s/%s: %v", release.Namespace, release.Name, err)
+		previousRelease = nil
+	}
+
+	change := &HelmReleaseChange{
+		Operation: operation,
+		Release:   release.Summary(),
+	}
+	if previousRelease != nil {
+		change.PreviousRelease = previousRelease.Summary()
+		// diff redacted values so the diff never reveals more than the values themselves
+		change.ValuesDiff = helm.DiffValues(
+			RedactionPolicy.ApplyToHelmValues(release.Namespace, release.Name, previousRelease.ComputedValues()),
+			RedactionPolicy.ApplyToHelmValues(release.Namespace, release.Name, release.ComputedValues()),
+		)
+	}
+
+	event := NewK8sChangeEvent(oldSecret, newSecret)
+	event.EventType = HelmRelease
+	event.HelmRelease = change
+	return event, nil
+}
+
+// NewChangeIntentEvent creates a change_intent event from the objects of an admission request
+func NewChangeIntentEvent(intent *Intent, oldObj, newObj *unstructured.Unstructured) *ChangeEvent {
+	event := NewK8sChangeEvent(oldObj, newObj)
+	intent.ChangedFields = event.changedFields
+	event.EventType = ChangeIntent
+	event.Time = time.Now().Unix()
+	event.Intent = intent
+	return event
+}
+
 func NewKafkaChangeEvent(oldObj, newObj interface{}, apiKey string) *ChangeEvent {
 	return &ChangeEvent{
 		OldObject: &unstructured.Unstructured{Object: map[string]interface{}{apiKey: oldObj}},
@@ -75,18 +255,194 @@ type ResourceList struct {
 }
 
 func NewResourceList(objects []runtime.Object) *ResourceList {
-	if RedactEnvVar {
-		for _, object := range objects {
-			util.RedactEnvVar(object.(*unstructured.Unstructured))
+	redacted := make([]runtime.Object, 0, len(objects))
+	for _, object := range objects {
+		if unstr, ok := object.(*unstructured.Unstructured); ok {
+			// objects are usually straight from the informer cache, never redact those in place
+			unstr = unstr.DeepCopy()
+			RedactionPolicy.Apply(unstr)
+			object = unstr
 		}
+		redacted = append(redacted, object)
 	}
 	return &ResourceList{
-		Objects: objects,
+		Objects: redacted,
 		Time:    time.Now().Unix(),
 	}
 }
 
+type IssueEventType string
+
+const (
+	IssueOpened   IssueEventType = "issue_opened"
+	IssueUpdated  IssueEventType = "issue_updated"
+	IssueResolved IssueEventType = "issue_resolved"
+)
+
+type IssueStatus string
+
+const (
+	StatusFiring   IssueStatus = "firing"
+	StatusResolved IssueStatus = "resolved"
+)
+
+// Issue is the common model of alerts from every issue source
+type Issue struct {
+	// Fingerprint identifies the alert across notifications
+	Fingerprint  string            `json:"fingerprint"`
+	Name         string            `json:"name"`
+	Status       IssueStatus       `json:"status"`
+	Severity     string            `json:"severity,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	StartsAt     int64             `json:"starts_at,omitempty"`
+	EndsAt       int64             `json:"ends_at,omitempty"`
+	GeneratorURL string            `json:"generator_url,omitempty"`
+	GroupKey     string            `json:"group_key,omitempty"`
+}
+
+// IssueRequest is either a raw issue payload in Data, or a typed issue event
 type IssueRequest struct {
-	IssueSource string `json:"issue_source"`
-	Data        string `json:"data"`
+	IssueSource string         `json:"issue_source"`
+	Data        string         `json:"data"`
+	EventType   IssueEventType `json:"event_type,omitempty"`
+	Issue       *Issue         `json:"issue,omitempty"`
+	Context     *IssueContext  `json:"context,omitempty"`
+	// Regression is the evidence of a deploy_regression issue
+	Regression *DeployRegression `json:"regression,omitempty"`
+}
+
+// IssueContext is the cluster state related to an issue when it was received
+type IssueContext struct {
+	Objects []*RelatedObject             `json:"objects,omitempty"`
+	Events  []*unstructured.Unstructured `json:"events,omitempty"`
+}
+
+// RelatedObject is a redacted snapshot of an object an issue is about, with its recent changes
+type RelatedObject struct {
+	Object  *unstructured.Unstructured `json:"object"`
+	Changes []ChangeRecord             `json:"changes,omitempty"`
+}
+
+// ChangeRecord summarizes a change event in the change history of an object
+type ChangeRecord struct {
+	EventType     EventType               `json:"event_type"`
+	Time          int64                   `json:"time"`
+	ChangedFields []string                `json:"changed_fields,omitempty"`
+	Attribution   []util.FieldAttribution `json:"attribution,omitempty"`
+}
+
+// IssueEnricher attaches the cluster state related to an issue
+type IssueEnricher interface {
+	EnrichIssue(issue *Issue) *IssueContext
+}
+
+// NewChangeRecord summarizes a change event
+func NewChangeRecord(event *ChangeEvent) ChangeRecord {
+	return ChangeRecord{
+		EventType:     event.EventType,
+		Time:          event.Time,
+		ChangedFields: event.changedFields,
+		Attribution:   event.Attribution,
+	}
+}
+
+// NewSnapshot returns a redacted copy of an object from an informer cache, without its managed fields
+func NewSnapshot(object *unstructured.Unstructured) *unstructured.Unstructured {
+	snapshot := object.DeepCopy()
+	snapshot.SetManagedFields(nil)
+	RedactionPolicy.Apply(snapshot)
+	return snapshot
+}
+
+func NewIssueRequest(issueSource, data string) *IssueRequest {
+	return &IssueRequest{
+		IssueSource: issueSource,
+		Data:        RedactionPolicy.ApplyToIssue(data),
+	}
+}
+
+// NewIssueEvent creates a typed issue event, redacting a copy of the issue labels and annotations
+func NewIssueEvent(issueSource string, eventType IssueEventType, issue *Issue) *IssueRequest {
+	redacted := *issue
+	redacted.Labels, redacted.Annotations = RedactionPolicy.ApplyToIssueAttributes(issue.Labels, issue.Annotations)
+	return &IssueRequest{
+		IssueSource: issueSource,
+		EventType:   eventType,
+		Issue:       &redacted,
+	}
+}
+
+type TopologyOperation string
+
+const (
+	EdgeAdded   TopologyOperation = "edge_added"
+	EdgeRemoved TopologyOperation = "edge_removed"
+)
+
+// TopologyNode is a workload, a Service, or an external address for IPs outside of the cluster
+type TopologyNode struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+// TopologyEdge is a source node sending requests to a destination node, with the rates of the last traffic
+// collection. Latencies are in seconds, and only set when the traffic collector measures them.
+type TopologyEdge struct {
+	Source      TopologyNode `json:"source"`
+	Destination TopologyNode `json:"destination"`
+	RequestRate float64      `json:"request_rate"`
+	ErrorRate   float64      `json:"error_rate"`
+	LatencyP50  float64      `json:"latency_p50,omitempty"`
+	LatencyP95  float64      `json:"latency_p95,omitempty"`
+	LatencyP99  float64      `json:"latency_p99,omitempty"`
+	FirstSeen   int64        `json:"first_seen"`
+	LastSeen    int64        `json:"last_seen"`
+}
+
+type TopologyEdgeChange struct {
+	Operation TopologyOperation `json:"operation"`
+	Edge      TopologyEdge      `json:"edge"`
+}
+
+// Topology is the service dependency graph
+type Topology struct {
+	Nodes []TopologyNode `json:"nodes"`
+	Edges []TopologyEdge `json:"edges"`
+	Time  int64          `json:"time"`
+}
+
+// TopologySource returns the current service dependency graph
+type TopologySource interface {
+	Topology() *Topology
+}
+
+func NewTopologyChangeEvent(operation TopologyOperation, edge TopologyEdge) *ChangeEvent {
+	return &ChangeEvent{
+		EventType: TopologyChange,
+		Time:      time.Now().Unix(),
+		Topology:  &TopologyEdgeChange{Operation: operation, Edge: edge},
+	}
+}
+
+// GoldenSignals are the requests received by a workload between From and To, in requests per second, ratio of
+// failed requests and seconds. LatencyP95 is only set when the traffic collector measures latencies.
+type GoldenSignals struct {
+	From        int64   `json:"from"`
+	To          int64   `json:"to"`
+	RequestRate float64 `json:"request_rate"`
+	ErrorRatio  float64 `json:"error_ratio"`
+	LatencyP95  float64 `json:"latency_p95,omitempty"`
+}
+
+// DeployRegression compares the golden signals of a Deployment before and after the change rolling out a new pod
+// template. Regressions describe the signals that got worse beyond the thresholds.
+type DeployRegression struct {
+	Namespace   string        `json:"namespace"`
+	Deployment  string        `json:"deployment"`
+	Change      ChangeRecord  `json:"change"`
+	Before      GoldenSignals `json:"before"`
+	After       GoldenSignals `json:"after"`
+	Regressions []string      `json:"regressions"`
 }
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webb-ai/k8s-agent/pkg/redact"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func withRedactionPolicy(t *testing.T, policy *redact.Policy) {
+	previous := RedactionPolicy
+	RedactionPolicy = policy
+	t.Cleanup(func() {
+		RedactionPolicy = previous
+	})
+}
+
+func toUnstructured(t *testing.T, object runtime.Object) *unstructured.Unstructured {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(object)
+	assert.NoError(t, err)
+	return &unstructured.Unstructured{Object: content}
+}
+
+const kubectlFields = `{"f:spec":{"f:replicas":{},"f:template":{"f:spec":{"f:containers":{"k:{\"name\":\"app\"}":{` +
+	`"f:env":{"k:{\"name\":\"DATABASE_URL\"}":{"f:value":{}}}}}}}}}`
+
+func newDeployment(t *testing.T, resourceVersion string, replicas int32) *unstructured.Unstructured {
+	deployment := toUnstructured(t, &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "checkout",
+			Namespace:       "shop",
+			ResourceVersion: resourceVersion,
+			ManagedFields: []metav1.ManagedFieldsEntry{{
+				Manager:    "kubectl",
+				Operation:  metav1.ManagedFieldsOperationApply,
+				FieldsType: "FieldsV1",
+				FieldsV1:   &metav1.FieldsV1{Raw: []byte(kubectlFields)},
+			}},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name: "app",
+				Env:  []corev1.EnvVar{{Name: "DATABASE_URL", Value: "postgres://prod"}},
+			}}}},
+		},
+	})
+	deployment.SetAPIVersion("apps/v1")
+	deployment.SetKind("Deployment")
+	return deployment
+}
+
+func envValue(object *unstructured.Unstructured) string {
+	containers, _, _ := unstructured.NestedSlice(object.Object, "spec", "template", "spec", "containers")
+	env, _, _ := unstructured.NestedSlice(containers[0].(map[string]interface{}), "env")
+	value, _, _ := unstructured.NestedString(env[0].(map[string]interface{}), "value")
+	return value
+}
+
+func TestChangeEventsHashCachedObjectsOnce(t *testing.T) {
+	policy, err := redact.NewPolicy("salt", nil, redact.HashedEnvVarRules()...)
+	assert.NoError(t, err)
+	withRedactionPolicy(t, policy)
+
+	// the informer cache hands the same objects to consecutive updates
+	first, second, third := newDeployment(t, "1", 1), newDeployment(t, "2", 2), newDeployment(t, "3", 3)
+	NewK8sChangeEvent(nil, first)
+	NewK8sChangeEvent(first, second)
+	event := NewK8sChangeEvent(second, third)
+
+	hashed := policy.Hash("postgres://prod")
+	assert.Equal(t, hashed, envValue(event.OldObject))
+	assert.Equal(t, hashed, envValue(event.NewObject))
+	assert.Equal(t, "postgres://prod", envValue(second))
+	assert.Equal(t, "postgres://prod", envValue(third))
+}
+
+func TestChangeEventsAttributeCachedObjects(t *testing.T) {
+	policy, err := redact.NewPolicy("salt", nil, redact.HashedEnvVarRules()...)
+	assert.NoError(t, err)
+	withRedactionPolicy(t, policy)
+
+	first, second, third := newDeployment(t, "1", 1), newDeployment(t, "2", 2), newDeployment(t, "3", 3)
+	NewK8sChangeEvent(nil, first)
+	NewK8sChangeEvent(first, second)
+	event := NewK8sChangeEvent(second, third)
+
+	// redacted fields of the cached old object aren't mistaken for changes
+	assert.Len(t, event.Attribution, 1)
+	assert.Equal(t, "kubectl", event.Attribution[0].Manager)
+	assert.Equal(t, []string{".spec.replicas"}, event.Attribution[0].Fields)
+	assert.Equal(t, []string{".spec.replicas"}, NewChangeRecord(event).ChangedFields)
+}
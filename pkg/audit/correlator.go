@@ -0,0 +1,256 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/webb-ai/k8s-agent/pkg/api"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/klog/v2"
+)
+
+// mutatingVerbs are the verbs of requests changing an existing object. Creates are not joined, the informers
+// also add every object of their initial list, and objectRef carries no uid for a create.
+var mutatingVerbs = map[string]struct{}{
+	"update": {},
+	"patch":  {},
+	"delete": {},
+}
+
+// joinedEventTypes are the event types of changes to existing objects observed by the informers
+var joinedEventTypes = map[api.EventType]struct{}{
+	api.ObjectUpdate: {},
+	api.ObjectDelete: {},
+	api.HelmRelease:  {},
+}
+
+type pendingEvent struct {
+	event    *api.ChangeEvent
+	deadline time.Time
+}
+
+type record struct {
+	info     *api.AuditInfo
+	deadline time.Time
+}
+
+// Correlator joins audit events of the kube api server to change events by object uid and resource version.
+// It wraps the client change events are sent with, and holds an update or delete back for up to the join window
+// while waiting for its audit event, since the api server sends audit events in batches.
+type Correlator struct {
+	api.Client
+	window  time.Duration
+	watched map[schema.GroupResource]struct{}
+	metrics *Metrics
+
+	mutex   sync.Mutex
+	pending map[string]*pendingEvent
+	records map[string]*record
+}
+
+func NewCorrelator(client api.Client, window time.Duration, watched []schema.GroupVersionResource) *Correlator {
+	groupResources := make(map[schema.GroupResource]struct{}, len(watched))
+	for _, gvr := range watched {
+		groupResources[gvr.GroupResource()] = struct{}{}
+	}
+	return &Correlator{
+		Client:  client,
+		window:  window,
+		watched: groupResources,
+		metrics: correlatorMetrics,
+		pending: make(map[string]*pendingEvent),
+		records: make(map[string]*record),
+	}
+}
+
+// SendChangeEvent sends the event right away if its audit event already arrived, otherwise holds it back
+func (c *Correlator) SendChangeEvent(event *api.ChangeEvent) error {
+	key := changeEventKey(event)
+	if key == "" {
+		return c.Client.SendChangeEvent(event)
+	}
+
+	c.mutex.Lock()
+	if found, ok := c.records[key]; ok {
+		delete(c.records, key)
+		c.mutex.Unlock()
+		event.Audit = found.info
+		c.metrics.JoinCounter.With(map[string]string{ResultKey: "joined"}).Inc()
+		return c.Client.SendChangeEvent(event)
+	}
+	c.pending[key] = &pendingEvent{event: event, deadline: time.Now().Add(c.window)}
+	c.mutex.Unlock()
+	return nil
+}
+
+// HandleEventList joins the audit events of a batch to pending change events, or keeps them for change events
+// still to come. It returns the number of audit events kept.
+func (c *Correlator) HandleEventList(list *auditv1.EventList) int {
+	accepted := 0
+	for i := range list.Items {
+		event := &list.Items[i]
+		key := c.auditEventKey(event)
+		if key == "" {
+			c.metrics.AuditEventCounter.With(map[string]string{ResultKey: "ignored"}).Inc()
+			continue
+		}
+		accepted++
+		c.metrics.AuditEventCounter.With(map[string]string{ResultKey: "accepted"}).Inc()
+		info := newAuditInfo(event)
+
+		c.mutex.Lock()
+		found, ok := c.pending[key]
+		if !ok {
+			c.records[key] = &record{info: info, deadline: time.Now().Add(c.window)}
+			c.mutex.Unlock()
+			continue
+		}
+		delete(c.pending, key)
+		c.mutex.Unlock()
+
+		found.event.Audit = info
+		c.metrics.JoinCounter.With(map[string]string{ResultKey: "joined"}).Inc()
+		if err := c.Client.SendChangeEvent(found.event); err != nil {
+			klog.Error(err)
+		}
+	}
+	return accepted
+}
+
+// Start sends change events whose audit event did not arrive within the join window, until ctx is done
+func (c *Correlator) Start(ctx context.Context) error {
+	klog.Infof("joining audit events to change events within %v", c.window)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.expire(time.Now())
+		case <-ctx.Done():
+			// do not lose change events on shutdown
+			c.expire(time.Now().Add(c.window))
+			return nil
+		}
+	}
+}
+
+func (c *Correlator) expire(now time.Time) {
+	var expired []*api.ChangeEvent
+	c.mutex.Lock()
+	for key, pending := range c.pending {
+		if now.After(pending.deadline) {
+			expired = append(expired, pending.event)
+			delete(c.pending, key)
+		}
+	}
+	for key, record := range c.records {
+		if now.After(record.deadline) {
+			delete(c.records, key)
+			c.metrics.JoinCounter.With(map[string]string{ResultKey: "unmatched_audit_event"}).Inc()
+		}
+	}
+	c.mutex.Unlock()
+
+	for _, event := range expired {
+		c.metrics.JoinCounter.With(map[string]string{ResultKey: "unmatched_change_event"}).Inc()
+		if err := c.Client.SendChangeEvent(event); err != nil {
+			klog.Error(err)
+		}
+	}
+}
+
+// auditEventKey returns the join key of a successful mutating request on a watched resource, or "" to ignore it
+func (c *Correlator) auditEventKey(event *auditv1.Event) string {
+	if event.Stage != auditv1.StageResponseComplete || event.ObjectRef == nil {
+		return ""
+	}
+	if _, ok := mutatingVerbs[event.Verb]; !ok {
+		return ""
+	}
+	if _, ok := c.watched[schema.GroupResource{Group: event.ObjectRef.APIGroup, Resource: event.ObjectRef.Resource}]; !ok {
+		return ""
+	}
+	if event.ResponseStatus != nil && event.ResponseStatus.Code >= 300 {
+		return ""
+	}
+	// dry run requests change nothing
+	if strings.Contains(event.RequestURI, "dryRun=") {
+		return ""
+	}
+
+	// the response object has the resource version of the change, it's only logged at the RequestResponse level
+	uid, resourceVersion := string(event.ObjectRef.UID), event.ObjectRef.ResourceVersion
+	if event.ResponseObject != nil {
+		var response struct {
+			Metadata struct {
+				UID             string `json:"uid"`
+				ResourceVersion string `json:"resourceVersion"`
+			} `json:"metadata"`
+		}
+		if err := json.Unmarshal(event.ResponseObject.Raw, &response); err == nil && response.Metadata.UID != "" {
+			uid, resourceVersion = response.Metadata.UID, response.Metadata.ResourceVersion
+		}
+	}
+	if event.Verb == "delete" {
+		return deleteKey(uid)
+	}
+	return objectKey(uid, resourceVersion)
+}
+
+// changeEventKey returns the join key of a change event, deletes are joined by uid since the deleted object
+// carries the resource version before the deletion. Only changes of existing watched objects are joined.
+func changeEventKey(event *api.ChangeEvent) string {
+	if _, ok := joinedEventTypes[event.EventType]; !ok || event.OldObject == nil {
+		return ""
+	}
+	if event.NewObject == nil {
+		return deleteKey(uidOf(event.OldObject))
+	}
+	if uidOf(event.NewObject) == "" {
+		return ""
+	}
+	return objectKey(uidOf(event.NewObject), event.NewObject.GetResourceVersion())
+}
+
+func uidOf(object *unstructured.Unstructured) string {
+	if object == nil {
+		return ""
+	}
+	return string(object.GetUID())
+}
+
+func objectKey(uid, resourceVersion string) string {
+	if uid == "" || resourceVersion == "" {
+		return ""
+	}
+	return uid + "/" + resourceVersion
+}
+
+func deleteKey(uid string) string {
+	if uid == "" {
+		return ""
+	}
+	return uid + "/delete"
+}
+
+func newAuditInfo(event *auditv1.Event) *api.AuditInfo {
+	info := &api.AuditInfo{
+		AuditId:   string(event.AuditID),
+		Verb:      event.Verb,
+		Username:  event.User.Username,
+		Groups:    event.User.Groups,
+		UserAgent: event.UserAgent,
+		SourceIPs: event.SourceIPs,
+	}
+	if event.ImpersonatedUser != nil {
+		info.Impersonator = event.User.Username
+		info.Username = event.ImpersonatedUser.Username
+		info.Groups = event.ImpersonatedUser.Groups
+	}
+	return info
+}
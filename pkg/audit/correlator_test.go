@@ -0,0 +1,114 @@
+package audit
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webb-ai/k8s-agent/pkg/api"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+type recordingClient struct {
+	api.NoOpClient
+	mutex  sync.Mutex
+	events []*api.ChangeEvent
+}
+
+func (r *recordingClient) SendChangeEvent(event *api.ChangeEvent) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = append(r.events, event)
+	return nil
+}
+
+var deploymentsGVR = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+
+func newDeployment(uid, resourceVersion string) *unstructured.Unstructured {
+	object := &unstructured.Unstructured{}
+	object.SetAPIVersion("apps/v1")
+	object.SetKind("Deployment")
+	object.SetNamespace("default")
+	object.SetName("nginx")
+	object.SetUID(types.UID(uid))
+	object.SetResourceVersion(resourceVersion)
+	return object
+}
+
+func newAuditEvent(verb, resource, resourceVersion string) auditv1.Event {
+	return auditv1.Event{
+		AuditID:   "audit-1",
+		Stage:     auditv1.StageResponseComplete,
+		Verb:      verb,
+		User:      authenticationv1.UserInfo{Username: "jane@example.com", Groups: []string{"system:authenticated"}},
+		SourceIPs: []string{"10.0.0.1"},
+		UserAgent: "kubectl/v1.28.2",
+		ObjectRef: &auditv1.ObjectReference{
+			Resource:  resource,
+			Namespace: "default",
+			Name:      "nginx",
+			APIGroup:  "apps",
+		},
+		ResponseStatus: &metav1.Status{Code: 200},
+		ResponseObject: &runtime.Unknown{
+			Raw: []byte(`{"metadata":{"uid":"uid-1","resourceVersion":"` + resourceVersion + `"}}`),
+		},
+	}
+}
+
+func TestCorrelatorJoinsAuditEvents(t *testing.T) {
+	client := &recordingClient{}
+	correlator := NewCorrelator(client, time.Minute, []schema.GroupVersionResource{deploymentsGVR})
+
+	// change event first, then its audit event
+	update := &api.ChangeEvent{EventType: api.ObjectUpdate, OldObject: newDeployment("uid-1", "1"), NewObject: newDeployment("uid-1", "2")}
+	assert.NoError(t, correlator.SendChangeEvent(update))
+	assert.Empty(t, client.events)
+
+	accepted := correlator.HandleEventList(&auditv1.EventList{Items: []auditv1.Event{
+		newAuditEvent("get", "deployments", "2"),
+		newAuditEvent("patch", "pods", "2"),
+		newAuditEvent("patch", "deployments", "2"),
+	}})
+	assert.Equal(t, 1, accepted)
+	assert.Len(t, client.events, 1)
+	assert.Equal(t, &api.AuditInfo{
+		AuditId:   "audit-1",
+		Verb:      "patch",
+		Username:  "jane@example.com",
+		Groups:    []string{"system:authenticated"},
+		UserAgent: "kubectl/v1.28.2",
+		SourceIPs: []string{"10.0.0.1"},
+	}, client.events[0].Audit)
+
+	// audit event first, then its change event
+	correlator.HandleEventList(&auditv1.EventList{Items: []auditv1.Event{newAuditEvent("delete", "deployments", "3")}})
+	assert.NoError(t, correlator.SendChangeEvent(&api.ChangeEvent{EventType: api.ObjectDelete, OldObject: newDeployment("uid-1", "2")}))
+	assert.Len(t, client.events, 2)
+	assert.Equal(t, "delete", client.events[1].Audit.Verb)
+}
+
+func TestCorrelatorExpiresChangeEvents(t *testing.T) {
+	client := &recordingClient{}
+	correlator := NewCorrelator(client, time.Minute, []schema.GroupVersionResource{deploymentsGVR})
+
+	// adds, e.g. of the initial list, are never held back
+	assert.NoError(t, correlator.SendChangeEvent(&api.ChangeEvent{EventType: api.ObjectAdd, NewObject: newDeployment("uid-1", "1")}))
+	assert.Len(t, client.events, 1)
+	client.events = nil
+
+	assert.NoError(t, correlator.SendChangeEvent(&api.ChangeEvent{EventType: api.ObjectUpdate, OldObject: newDeployment("uid-1", "1"), NewObject: newDeployment("uid-1", "2")}))
+	correlator.expire(time.Now())
+	assert.Empty(t, client.events)
+
+	correlator.expire(time.Now().Add(2 * time.Minute))
+	assert.Len(t, client.events, 1)
+	assert.Nil(t, client.events[0].Audit)
+}
@@ -0,0 +1,38 @@
+package audit
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const ResultKey = "result"
+
+// correlatorMetrics are shared by all correlators, metrics can only be registered once
+var correlatorMetrics = NewMetrics()
+
+type Metrics struct {
+	AuditEventCounter *prometheus.CounterVec
+	JoinCounter       *prometheus.CounterVec
+}
+
+func NewMetrics() *Metrics {
+	auditEventCounter := promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "audit_event_total",
+			Help: "Counts the total number of audit events received. Labels: result(accepted|ignored)",
+		},
+		[]string{ResultKey},
+	)
+	joinCounter := promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "audit_join_total",
+			Help: "Counts audit events joined to change events. Labels: result(joined|unmatched_change_event|unmatched_audit_event)",
+		},
+		[]string{ResultKey},
+	)
+
+	return &Metrics{
+		AuditEventCounter: auditEventCounter,
+		JoinCounter:       joinCounter,
+	}
+}
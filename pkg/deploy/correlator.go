@@ -0,0 +1,179 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/webb-ai/k8s-agent/pkg/api"
+	"github.com/webb-ai/k8s-agent/pkg/util"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/klog/v2"
+)
+
+// workloadKinds are the kinds of apps/v1 workloads markers are attached to
+var workloadKinds = map[string]struct{}{
+	"Deployment":  {},
+	"StatefulSet": {},
+}
+
+// ErrInvalidMarker is returned for markers missing the fields they are matched with
+var ErrInvalidMarker = errors.New("deploy markers need a namespace, a service and a version")
+
+type pendingMarker struct {
+	marker   *api.DeployMarker
+	deadline time.Time
+}
+
+// Correlator attaches deploy markers posted by CI/CD pipelines to the workload change rolling them out.
+// It wraps the client change events are sent with. A marker is kept for the window after it's posted, and
+// attached to the first change of its workload introducing an image tagged with its version or commit.
+type Correlator struct {
+	api.Client
+	window  time.Duration
+	metrics *Metrics
+
+	mutex   sync.Mutex
+	markers map[string][]*pendingMarker
+}
+
+func NewCorrelator(client api.Client, window time.Duration) *Correlator {
+	return &Correlator{
+		Client:  client,
+		window:  window,
+		metrics: deployMetrics,
+		markers: make(map[string][]*pendingMarker),
+	}
+}
+
+// HandleMarker validates a marker, forwards it and keeps it for the changes still to come. Markers that couldn't be
+// forwarded aren't kept, pipelines retry them.
+func (c *Correlator) HandleMarker(marker *api.DeployMarker) error {
+	if marker.Namespace == "" || marker.Service == "" || marker.Version == "" {
+		return ErrInvalidMarker
+	}
+	if marker.Workload == "" {
+		marker.Workload = marker.Service
+	}
+	if marker.Time == 0 {
+		marker.Time = time.Now().Unix()
+	}
+
+	if err := c.Client.SendDeployMarker(marker); err != nil {
+		return err
+	}
+	key := workloadKey(marker.Namespace, marker.Workload)
+	c.mutex.Lock()
+	c.markers[key] = append(c.markers[key], &pendingMarker{marker: marker, deadline: time.Now().Add(c.window)})
+	c.mutex.Unlock()
+	return nil
+}
+
+// SendChangeEvent attaches the marker of the workload to the event if it rolls out the marker's version
+func (c *Correlator) SendChangeEvent(event *api.ChangeEvent) error {
+	if marker := c.match(event); marker != nil {
+		klog.Infof("deploy of %s %s by %s attached to %s/%s", marker.Service, marker.Version, marker.Author,
+			event.NewObject.GetNamespace(), event.NewObject.GetName())
+		event.DeployMarker = marker
+	}
+	return c.Client.SendChangeEvent(event)
+}
+
+func (c *Correlator) match(event *api.ChangeEvent) *api.DeployMarker {
+	if event.EventType != api.ObjectAdd && event.EventType != api.ObjectUpdate {
+		return nil
+	}
+	object := event.NewObject
+	if object == nil || !strings.HasPrefix(object.GetAPIVersion(), "apps/") {
+		return nil
+	}
+	if _, ok := workloadKinds[object.GetKind()]; !ok {
+		return nil
+	}
+
+	key := workloadKey(object.GetNamespace(), object.GetName())
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	pending := c.markers[key]
+	if len(pending) == 0 {
+		return nil
+	}
+
+	// only the change introducing the tag, not the status updates of the rollout that follow
+	newTags := imageTags(object)
+	oldTags := imageTags(event.OldObject)
+	for i := len(pending) - 1; i >= 0; i-- {
+		marker := pending[i].marker
+		if !introduces(newTags, oldTags, marker.Version) && !introduces(newTags, oldTags, marker.Commit) {
+			continue
+		}
+		c.markers[key] = append(pending[:i:i], pending[i+1:]...)
+		if len(c.markers[key]) == 0 {
+			delete(c.markers, key)
+		}
+		c.metrics.MarkerCounter.With(map[string]string{ResultKey: "matched"}).Inc()
+		return marker
+	}
+	return nil
+}
+
+// Start drops markers not matched within the window, until ctx is done
+func (c *Correlator) Start(ctx context.Context) error {
+	klog.Infof("attaching deploy markers to workload changes within %v", c.window)
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.expire(time.Now())
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (c *Correlator) expire(now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key, pending := range c.markers {
+		kept := pending[:0]
+		for _, marker := range pending {
+			if now.After(marker.deadline) {
+				c.metrics.MarkerCounter.With(map[string]string{ResultKey: "unmatched"}).Inc()
+				continue
+			}
+			kept = append(kept, marker)
+		}
+		if len(kept) == 0 {
+			delete(c.markers, key)
+		} else {
+			c.markers[key] = kept
+		}
+	}
+}
+
+func workloadKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+func introduces(newTags, oldTags map[string]struct{}, tag string) bool {
+	if tag == "" {
+		return false
+	}
+	_, inNew := newTags[tag]
+	_, inOld := oldTags[tag]
+	return inNew && !inOld
+}
+
+// imageTags returns the tags of the container and init container images of a workload's pod template
+func imageTags(object *unstructured.Unstructured) map[string]struct{} {
+	tags := make(map[string]struct{})
+	for _, image := range util.ContainerImages(object) {
+		if tag := util.ImageTag(image); tag != "" {
+			tags[tag] = struct{}{}
+		}
+	}
+	return tags
+}
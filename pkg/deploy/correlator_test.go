@@ -0,0 +1,112 @@
+package deploy
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webb-ai/k8s-agent/pkg/api"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+type recordingClient struct {
+	api.NoOpClient
+	err     error
+	events  []*api.ChangeEvent
+	markers []*api.DeployMarker
+}
+
+func (r *recordingClient) SendChangeEvent(event *api.ChangeEvent) error {
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *recordingClient) SendDeployMarker(marker *api.DeployMarker) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.markers = append(r.markers, marker)
+	return nil
+}
+
+func newDeployment(image string) *unstructured.Unstructured {
+	object := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{"template": map[string]interface{}{"spec": map[string]interface{}{
+			"containers": []interface{}{
+				map[string]interface{}{"name": "app", "image": image},
+				map[string]interface{}{"name": "proxy", "image": "envoyproxy/envoy:v1.27.0"},
+			},
+		}}},
+	}}
+	object.SetAPIVersion("apps/v1")
+	object.SetKind("Deployment")
+	object.SetNamespace("shop")
+	object.SetName("checkout")
+	return object
+}
+
+func TestCorrelatorAttachesMarkers(t *testing.T) {
+	client := &recordingClient{}
+	correlator := NewCorrelator(client, time.Hour)
+
+	assert.Error(t, correlator.HandleMarker(&api.DeployMarker{Service: "checkout"}))
+	marker := &api.DeployMarker{Service: "checkout", Namespace: "shop", Version: "v2", Commit: "9fceb02", Author: "jane"}
+	assert.NoError(t, correlator.HandleMarker(marker))
+	assert.Equal(t, []*api.DeployMarker{marker}, client.markers)
+	assert.Equal(t, "checkout", marker.Workload)
+
+	old := newDeployment("acme/checkout:v1")
+	rollout := newDeployment("acme/checkout:v2")
+	// neither other workloads, nor changes keeping the tag, nor other tags get the marker
+	other := newDeployment("acme/checkout:v2")
+	other.SetName("cart")
+	assert.NoError(t, correlator.SendChangeEvent(&api.ChangeEvent{EventType: api.ObjectUpdate, OldObject: old, NewObject: other}))
+	assert.NoError(t, correlator.SendChangeEvent(&api.ChangeEvent{EventType: api.ObjectUpdate, OldObject: old, NewObject: old}))
+	assert.NoError(t, correlator.SendChangeEvent(&api.ChangeEvent{EventType: api.ObjectUpdate, OldObject: old, NewObject: newDeployment("acme/checkout:v3")}))
+	assert.NoError(t, correlator.SendChangeEvent(&api.ChangeEvent{EventType: api.ObjectUpdate, OldObject: old, NewObject: rollout}))
+	// the status updates of the rollout that follow
+	assert.NoError(t, correlator.SendChangeEvent(&api.ChangeEvent{EventType: api.ObjectUpdate, OldObject: rollout, NewObject: rollout}))
+
+	assert.Len(t, client.events, 5)
+	for i, event := range client.events {
+		if i == 3 {
+			assert.Equal(t, marker, event.DeployMarker)
+		} else {
+			assert.Nil(t, event.DeployMarker, i)
+		}
+	}
+}
+
+func TestCorrelatorMatchesCommitTags(t *testing.T) {
+	client := &recordingClient{}
+	correlator := NewCorrelator(client, time.Hour)
+	marker := &api.DeployMarker{Service: "checkout", Namespace: "shop", Version: "2.0.0", Commit: "9fceb02"}
+	assert.NoError(t, correlator.HandleMarker(marker))
+
+	// markers are dropped after the window
+	correlator.expire(time.Now().Add(2 * time.Hour))
+	assert.NoError(t, correlator.SendChangeEvent(&api.ChangeEvent{EventType: api.ObjectAdd, NewObject: newDeployment("acme/checkout:9fceb02")}))
+	assert.Nil(t, client.events[0].DeployMarker)
+
+	assert.NoError(t, correlator.HandleMarker(marker))
+	assert.NoError(t, correlator.SendChangeEvent(&api.ChangeEvent{EventType: api.ObjectAdd, NewObject: newDeployment("acme/checkout:9fceb02")}))
+	assert.Equal(t, marker, client.events[1].DeployMarker)
+}
+
+func TestCorrelatorKeepsSentMarkersOnly(t *testing.T) {
+	client := &recordingClient{err: errors.New("unavailable")}
+	correlator := NewCorrelator(client, time.Hour)
+
+	marker := &api.DeployMarker{Service: "checkout", Namespace: "shop", Version: "v2"}
+	assert.Error(t, correlator.HandleMarker(marker))
+	// the pipeline retries the marker
+	client.err = nil
+	assert.NoError(t, correlator.HandleMarker(marker))
+	assert.Len(t, correlator.markers["shop/checkout"], 1)
+
+	old, rollout := newDeployment("acme/checkout:v1"), newDeployment("acme/checkout:v2")
+	assert.NoError(t, correlator.SendChangeEvent(&api.ChangeEvent{EventType: api.ObjectUpdate, OldObject: old, NewObject: rollout}))
+	assert.Equal(t, marker, client.events[0].DeployMarker)
+	assert.Empty(t, correlator.markers["shop/checkout"])
+}
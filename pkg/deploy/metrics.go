@@ -0,0 +1,38 @@
+package deploy
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const ResultKey = "result"
+
+// deployMetrics are shared by all correlators and verifiers, metrics can only be registered once
+var deployMetrics = NewMetrics()
+
+type Metrics struct {
+	MarkerCounter       *prometheus.CounterVec
+	VerificationCounter *prometheus.CounterVec
+}
+
+func NewMetrics() *Metrics {
+	markerCounter := promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "deploy_marker_total",
+			Help: "Counts deploy markers by whether they were attached to a workload change. Labels: result(matched|unmatched)",
+		},
+		[]string{ResultKey},
+	)
+	verificationCounter := promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "deploy_verification_total",
+			Help: "Counts rollouts verified against their golden signals. Labels: result(passed|regressed|insufficient_traffic|superseded)",
+		},
+		[]string{ResultKey},
+	)
+
+	return &Metrics{
+		MarkerCounter:       markerCounter,
+		VerificationCounter: verificationCounter,
+	}
+}
@@ -0,0 +1,226 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/webb-ai/k8s-agent/pkg/alerts"
+	"github.com/webb-ai/k8s-agent/pkg/api"
+	"github.com/webb-ai/k8s-agent/pkg/traffic"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/klog/v2"
+)
+
+// RegressionSource is the issue source of deploy regressions
+const RegressionSource = "deploy_regression"
+
+// Thresholds tell how much the golden signals of a workload may get worse after a rollout. The error ratio may
+// increase by ErrorRatioIncrease, the p95 latency may be multiplied by LatencyIncrease, and the request rate may
+// decrease by the ratio RequestRateDecrease. Zero disables a check. Rollouts of workloads receiving less than
+// MinRequestRate requests per second before are not verified.
+type Thresholds struct {
+	ErrorRatioIncrease  float64
+	LatencyIncrease     float64
+	RequestRateDecrease float64
+	MinRequestRate      float64
+}
+
+type rollout struct {
+	namespace string
+	name      string
+	change    api.ChangeRecord
+}
+
+// Verifier compares the golden signals of Deployments in the window before and after the changes of their pod
+// template, and sends a deploy_regression issue when they got worse beyond the thresholds. The issue is resolved once
+// a later rollout of the Deployment is verified. It wraps the client change events are sent with, and reads the
+// signals from the traffic metrics.
+type Verifier struct {
+	api.Client
+	signals    *traffic.SignalHistory
+	window     time.Duration
+	thresholds Thresholds
+	metrics    *Metrics
+
+	mutex    sync.Mutex
+	rollouts map[string]*rollout
+	// regressions are the open issues by Deployment, only used by verify
+	regressions map[string]*api.Issue
+}
+
+func NewVerifier(client api.Client, signals *traffic.SignalHistory, window time.Duration, thresholds Thresholds) *Verifier {
+	return &Verifier{
+		Client:     client,
+		signals:    signals,
+		window:     window,
+		thresholds: thresholds,
+		metrics:    deployMetrics,
+		rollouts:   make(map[string]*rollout),
+
+		regressions: make(map[string]*api.Issue),
+	}
+}
+
+// SendChangeEvent schedules the verification of the Deployment rolled out by the event, if any
+func (v *Verifier) SendChangeEvent(event *api.ChangeEvent) error {
+	if rolledOut(event) {
+		object := event.NewObject
+		key := workloadKey(object.GetNamespace(), object.GetName())
+		v.mutex.Lock()
+		if _, found := v.rollouts[key]; found {
+			// the signals after the previous rollout are mixed with those of this one
+			v.metrics.VerificationCounter.With(map[string]string{ResultKey: "superseded"}).Inc()
+		}
+		v.rollouts[key] = &rollout{namespace: object.GetNamespace(), name: object.GetName(), change: api.NewChangeRecord(event)}
+		v.mutex.Unlock()
+	}
+	return v.Client.SendChangeEvent(event)
+}
+
+// rolledOut tells whether an event changes the pod template of a Deployment. Only spec changes bump the generation,
+// status updates don't, and scaling keeps the template.
+func rolledOut(event *api.ChangeEvent) bool {
+	if event.EventType != api.ObjectUpdate || event.OldObject == nil || event.NewObject == nil {
+		return false
+	}
+	object := event.NewObject
+	if !strings.HasPrefix(object.GetAPIVersion(), "apps/") || object.GetKind() != "Deployment" {
+		return false
+	}
+	if object.GetGeneration() == event.OldObject.GetGeneration() {
+		return false
+	}
+	oldTemplate, _, _ := unstructured.NestedFieldNoCopy(event.OldObject.Object, "spec", "template")
+	newTemplate, _, _ := unstructured.NestedFieldNoCopy(object.Object, "spec", "template")
+	return !equality.Semantic.DeepEqual(oldTemplate, newTemplate)
+}
+
+// Start verifies rollouts once their window has passed, until ctx is done
+func (v *Verifier) Start(ctx context.Context) error {
+	klog.Infof("verifying deployment rollouts over %v", v.window)
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			v.verify(time.Now())
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (v *Verifier) verify(now time.Time) {
+	var due []*rollout
+	v.mutex.Lock()
+	for key, rollout := range v.rollouts {
+		if !now.Before(time.Unix(rollout.change.Time, 0).Add(v.window)) {
+			due = append(due, rollout)
+			delete(v.rollouts, key)
+		}
+	}
+	v.mutex.Unlock()
+
+	for _, rollout := range due {
+		regression, result := v.compare(rollout)
+		v.metrics.VerificationCounter.With(map[string]string{ResultKey: result}).Inc()
+		if result == "insufficient_traffic" {
+			continue
+		}
+		// the regression of a previous rollout is over, or carried by the issue of this one
+		key := workloadKey(rollout.namespace, rollout.name)
+		if issue, found := v.regressions[key]; found {
+			if err := v.Client.SendIssue(resolveRegressionIssue(issue, now)); err != nil {
+				klog.Error(err)
+			} else {
+				delete(v.regressions, key)
+			}
+		}
+		if regression == nil {
+			continue
+		}
+		klog.Infof("deployment %s/%s regressed after rollout: %s", rollout.namespace, rollout.name,
+			strings.Join(regression.Regressions, ", "))
+		request := newRegressionIssue(regression)
+		if err := v.Client.SendIssue(request); err != nil {
+			klog.Error(err)
+			continue
+		}
+		v.regressions[key] = request.Issue
+	}
+}
+
+// compare returns the regression of the golden signals after a rollout, nil if there is none, and the result of the
+// verification
+func (v *Verifier) compare(rollout *rollout) (*api.DeployRegression, string) {
+	start := time.Unix(rollout.change.Time, 0)
+	before, found := v.signals.Signals(rollout.namespace, rollout.name, start.Add(-v.window), start)
+	if !found || before.RequestRate < v.thresholds.MinRequestRate {
+		return nil, "insufficient_traffic"
+	}
+	after, found := v.signals.Signals(rollout.namespace, rollout.name, start, start.Add(v.window))
+	if !found {
+		return nil, "insufficient_traffic"
+	}
+
+	var regressions []string
+	if v.thresholds.ErrorRatioIncrease > 0 && after.ErrorRatio-before.ErrorRatio > v.thresholds.ErrorRatioIncrease {
+		regressions = append(regressions, fmt.Sprintf("error ratio increased from %.4g to %.4g", before.ErrorRatio, after.ErrorRatio))
+	}
+	if v.thresholds.LatencyIncrease > 0 && before.LatencyP95 > 0 && after.LatencyP95 > before.LatencyP95*v.thresholds.LatencyIncrease {
+		regressions = append(regressions, fmt.Sprintf("p95 latency increased from %.4gs to %.4gs", before.LatencyP95, after.LatencyP95))
+	}
+	if v.thresholds.RequestRateDecrease > 0 && after.RequestRate < before.RequestRate*(1-v.thresholds.RequestRateDecrease) {
+		regressions = append(regressions, fmt.Sprintf("request rate decreased from %.4g/s to %.4g/s", before.RequestRate, after.RequestRate))
+	}
+	if len(regressions) == 0 {
+		return nil, "passed"
+	}
+	return &api.DeployRegression{
+		Namespace:   rollout.namespace,
+		Deployment:  rollout.name,
+		Change:      rollout.change,
+		Before:      before,
+		After:       after,
+		Regressions: regressions,
+	}, "regressed"
+}
+
+func newRegressionIssue(regression *api.DeployRegression) *api.IssueRequest {
+	issueLabels := map[string]string{
+		"alertname":  "DeployRegression",
+		"namespace":  regression.Namespace,
+		"deployment": regression.Deployment,
+	}
+	// each rollout is a distinct issue
+	fingerprintLabels := map[string]string{"rollout": strconv.FormatInt(regression.Change.Time, 10)}
+	for name, value := range issueLabels {
+		fingerprintLabels[name] = value
+	}
+	request := api.NewIssueEvent(RegressionSource, api.IssueOpened, &api.Issue{
+		Fingerprint: alerts.LabelsFingerprint(fingerprintLabels),
+		Name:        "DeployRegression",
+		Status:      api.StatusFiring,
+		Severity:    "warning",
+		Labels:      issueLabels,
+		Annotations: map[string]string{
+			"summary": fmt.Sprintf("Deployment %s/%s regressed after its rollout: %s", regression.Namespace,
+				regression.Deployment, strings.Join(regression.Regressions, ", ")),
+		},
+		StartsAt: regression.After.To,
+	})
+	request.Regression = regression
+	return request
+}
+
+func resolveRegressionIssue(issue *api.Issue, now time.Time) *api.IssueRequest {
+	resolved := *issue
+	resolved.Status = api.StatusResolved
+	resolved.EndsAt = now.Unix()
+	return api.NewIssueEvent(RegressionSource, api.IssueResolved, &resolved)
+}
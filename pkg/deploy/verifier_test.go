@@ -0,0 +1,279 @@
+package deploy
+
+import (
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/webb-ai/k8s-agent/pkg/api"
+	"github.com/webb-ai/k8s-agent/pkg/redact"
+	"github.com/webb-ai/k8s-agent/pkg/traffic"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+type issueClient struct {
+	api.NoOpClient
+	issues []*api.IssueRequest
+}
+
+func (c *issueClient) SendIssue(request *api.IssueRequest) error {
+	c.issues = append(c.issues, request)
+	return nil
+}
+
+// requests are the requests per second received by a workload, fast ones are served within 100ms
+type requests struct {
+	ok, failed, fast float64
+}
+
+// requestCounters are the cumulative counters of the requests received by a workload
+type requestCounters struct {
+	ok, failed, fast float64
+}
+
+// scrape adds a minute of requests to the counters and returns them sampled at timestamp
+func (c *requestCounters) scrape(workload string, rates requests, timestamp time.Time) *prompb.WriteRequest {
+	c.ok += rates.ok * 60
+	c.failed += rates.failed * 60
+	c.fast += rates.fast * 60
+	series := func(name string, value float64, label prompb.Label) prompb.TimeSeries {
+		return prompb.TimeSeries{
+			Labels: []prompb.Label{
+				{Name: "__name__", Value: name},
+				{Name: "dst_namespace", Value: "shop"},
+				{Name: "dst_workload", Value: workload},
+				label,
+			},
+			Samples: []prompb.Sample{{Value: value, Timestamp: timestamp.UnixMilli()}},
+		}
+	}
+	return &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{
+		series("requests_total", c.ok, prompb.Label{Name: "status_code", Value: "200"}),
+		series("requests_total", c.failed, prompb.Label{Name: "status_code", Value: "503"}),
+		series("request_duration_seconds_bucket", c.fast, prompb.Label{Name: "le", Value: "0.1"}),
+		series("request_duration_seconds_bucket", c.ok+c.failed, prompb.Label{Name: "le", Value: "1"}),
+		series("request_duration_seconds_bucket", c.ok+c.failed, prompb.Label{Name: "le", Value: "+Inf"}),
+	}}
+}
+
+func newTestVerifier() (*traffic.SignalHistory, *issueClient, *Verifier) {
+	signals := traffic.NewSignalHistory(traffic.RequestMetrics{
+		Requests:    "requests_total",
+		Latency:     "request_duration_seconds",
+		ErrorLabel:  "status_code",
+		ErrorValues: regexp.MustCompile("^(?:5..)$"),
+	}, time.Hour)
+	client := &issueClient{}
+	verifier := NewVerifier(client, signals, 10*time.Minute, Thresholds{
+		ErrorRatioIncrease:  0.05,
+		LatencyIncrease:     1.5,
+		RequestRateDecrease: 0.5,
+		MinRequestRate:      1,
+	})
+	return signals, client, verifier
+}
+
+// observe scrapes the requests of a workload every minute, rates returns them by minute since the rollout
+func observe(signals *traffic.SignalHistory, workload string, rolloutTime time.Time, from, to int, rates func(minute int) requests) {
+	counters := &requestCounters{}
+	for minute := from; minute < to; minute++ {
+		signals.Observe(counters.scrape(workload, rates(minute), rolloutTime.Add(time.Duration(minute)*time.Minute)))
+	}
+}
+
+// rolloutEvent changes the image of a Deployment, bumping its generation
+func rolloutEvent(name, oldImage, newImage string, rolloutTime time.Time) *api.ChangeEvent {
+	old, rollout := newDeployment(oldImage), newDeployment(newImage)
+	old.SetName(name)
+	old.SetGeneration(1)
+	rollout.SetName(name)
+	rollout.SetGeneration(2)
+	return &api.ChangeEvent{EventType: api.ObjectUpdate, OldObject: old, NewObject: rollout, Time: rolloutTime.Unix()}
+}
+
+func TestVerifierDetectsRegressions(t *testing.T) {
+	signals, client, verifier := newTestVerifier()
+	rolloutTime := time.Unix(100000, 0)
+	// checkout fails a fifth of its requests after its rollout, cart doesn't change
+	observe(signals, "checkout", rolloutTime, -10, 10, func(minute int) requests {
+		if minute < 0 {
+			return requests{ok: 10, fast: 10}
+		}
+		return requests{ok: 8, failed: 2, fast: 10}
+	})
+	observe(signals, "cart", rolloutTime, -10, 10, func(int) requests {
+		return requests{ok: 10, fast: 10}
+	})
+
+	event := rolloutEvent("checkout", "acme/checkout:v1", "acme/checkout:v2", rolloutTime)
+	// status updates aren't rollouts
+	status := event.NewObject.DeepCopy()
+	status.Object["status"] = map[string]interface{}{"updatedReplicas": int64(1)}
+	assert.NoError(t, verifier.SendChangeEvent(&api.ChangeEvent{EventType: api.ObjectUpdate, OldObject: event.NewObject, NewObject: status}))
+	assert.Empty(t, verifier.rollouts)
+	assert.NoError(t, verifier.SendChangeEvent(event))
+	assert.NoError(t, verifier.SendChangeEvent(rolloutEvent("cart", "acme/cart:v1", "acme/cart:v2", rolloutTime)))
+
+	verifier.verify(rolloutTime.Add(5 * time.Minute))
+	assert.Empty(t, client.issues)
+	assert.Len(t, verifier.rollouts, 2)
+
+	verifier.verify(rolloutTime.Add(10 * time.Minute))
+	assert.Empty(t, verifier.rollouts)
+	assert.Len(t, client.issues, 1)
+	request := client.issues[0]
+	assert.Equal(t, RegressionSource, request.IssueSource)
+	assert.Equal(t, api.IssueOpened, request.EventType)
+	assert.Equal(t, map[string]string{"alertname": "DeployRegression", "namespace": "shop", "deployment": "checkout"}, request.Issue.Labels)
+	assert.Equal(t, rolloutTime.Unix(), request.Regression.Change.Time)
+	assert.InDelta(t, 0, request.Regression.Before.ErrorRatio, 1e-9)
+	assert.InDelta(t, 0.2, request.Regression.After.ErrorRatio, 1e-9)
+	assert.InDelta(t, 10, request.Regression.After.RequestRate, 1e-9)
+	assert.Equal(t, []string{"error ratio increased from 0 to 0.2"}, request.Regression.Regressions)
+}
+
+func TestVerifierCompare(t *testing.T) {
+	for name, test := range map[string]struct {
+		before, after requests
+		result        string
+		regressions   []string
+	}{
+		"passed": {
+			before: requests{ok: 10, fast: 10},
+			after:  requests{ok: 9, fast: 9},
+			result: "passed",
+		},
+		"latency": {
+			// most requests take up to a second instead of 100ms
+			before:      requests{ok: 10, fast: 10},
+			after:       requests{ok: 10, fast: 2},
+			result:      "regressed",
+			regressions: []string{"p95 latency increased from 0.095s to 0.9437s"},
+		},
+		"request rate": {
+			before:      requests{ok: 10, fast: 10},
+			after:       requests{ok: 4, fast: 4},
+			result:      "regressed",
+			regressions: []string{"request rate decreased from 10/s to 4/s"},
+		},
+		"insufficient traffic": {
+			before: requests{ok: 0.5, fast: 0.5},
+			after:  requests{ok: 0.5, failed: 0.5, fast: 1},
+			result: "insufficient_traffic",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			signals, _, verifier := newTestVerifier()
+			rolloutTime := time.Unix(100000, 0)
+			observe(signals, "checkout", rolloutTime, -10, 10, func(minute int) requests {
+				if minute < 0 {
+					return test.before
+				}
+				return test.after
+			})
+
+			regression, result := verifier.compare(&rollout{namespace: "shop", name: "checkout", change: api.ChangeRecord{Time: rolloutTime.Unix()}})
+			assert.Equal(t, test.result, result)
+			if test.regressions == nil {
+				assert.Nil(t, regression)
+			} else {
+				assert.Equal(t, test.regressions, regression.Regressions)
+			}
+		})
+	}
+}
+
+func TestSignalsOfScrapesSplitAcrossRequests(t *testing.T) {
+	signals, _, verifier := newTestVerifier()
+	rolloutTime := time.Unix(100000, 0)
+	// two collectors observe half of the requests each, their scrapes are sent in separate write requests
+	first, second := &requestCounters{}, &requestCounters{}
+	for minute := -10; minute < 10; minute++ {
+		timestamp := rolloutTime.Add(time.Duration(minute) * time.Minute)
+		for instance, counters := range []*requestCounters{first, second} {
+			request := counters.scrape("checkout", requests{ok: 5, fast: 5}, timestamp)
+			for i := range request.Timeseries {
+				request.Timeseries[i].Labels = append(request.Timeseries[i].Labels, prompb.Label{Name: "instance", Value: strconv.Itoa(instance)})
+			}
+			signals.Observe(request)
+		}
+	}
+
+	before, found := signals.Signals("shop", "checkout", rolloutTime.Add(-10*time.Minute), rolloutTime)
+	assert.True(t, found)
+	assert.InDelta(t, 10, before.RequestRate, 1e-9)
+	_, result := verifier.compare(&rollout{namespace: "shop", name: "checkout", change: api.ChangeRecord{Time: rolloutTime.Unix()}})
+	assert.Equal(t, "passed", result)
+}
+
+func TestVerifierIgnoresHashedScaleAndStatusUpdates(t *testing.T) {
+	policy, err := redact.NewPolicy("salt", nil, redact.HashedEnvVarRules()...)
+	assert.NoError(t, err)
+	previous := api.RedactionPolicy
+	api.RedactionPolicy = policy
+	defer func() {
+		api.RedactionPolicy = previous
+	}()
+
+	deployment := func(generation int64, replicas int32, image string, updatedReplicas int32) *unstructured.Unstructured {
+		object := newDeployment(image)
+		_ = unstructured.SetNestedField(object.Object, int64(replicas), "spec", "replicas")
+		containers, _, _ := unstructured.NestedSlice(object.Object, "spec", "template", "spec", "containers")
+		env, _ := runtime.DefaultUnstructuredConverter.ToUnstructured(&corev1.EnvVar{Name: "DATABASE_PASSWORD", Value: "s3cret"})
+		containers[0].(map[string]interface{})["env"] = []interface{}{env}
+		_ = unstructured.SetNestedSlice(object.Object, containers, "spec", "template", "spec", "containers")
+		object.SetGeneration(generation)
+		object.Object["status"] = map[string]interface{}{"updatedReplicas": int64(updatedReplicas)}
+		return object
+	}
+	_, _, verifier := newTestVerifier()
+
+	// the cached objects of each update are those of the previous one
+	scaled := deployment(1, 1, "acme/checkout:v1", 1)
+	for _, object := range []*unstructured.Unstructured{
+		deployment(2, 3, "acme/checkout:v1", 1),
+		deployment(2, 3, "acme/checkout:v1", 3),
+	} {
+		assert.NoError(t, verifier.SendChangeEvent(api.NewK8sChangeEvent(scaled, object)))
+		scaled = object
+	}
+	assert.Empty(t, verifier.rollouts)
+
+	assert.NoError(t, verifier.SendChangeEvent(api.NewK8sChangeEvent(scaled, deployment(3, 3, "acme/checkout:v2", 3))))
+	assert.Len(t, verifier.rollouts, 1)
+}
+
+func TestVerifierResolvesRegressions(t *testing.T) {
+	signals, client, verifier := newTestVerifier()
+	rolloutTime := time.Unix(100000, 0)
+	// the first rollout fails a fifth of the requests until the second one fixes them
+	observe(signals, "checkout", rolloutTime, -10, 30, func(minute int) requests {
+		if minute >= 0 && minute < 20 {
+			return requests{ok: 8, failed: 2, fast: 10}
+		}
+		return requests{ok: 10, fast: 10}
+	})
+
+	assert.NoError(t, verifier.SendChangeEvent(rolloutEvent("checkout", "acme/checkout:v1", "acme/checkout:v2", rolloutTime)))
+	verifier.verify(rolloutTime.Add(10 * time.Minute))
+	assert.Len(t, client.issues, 1)
+	assert.Equal(t, api.IssueOpened, client.issues[0].EventType)
+
+	// a rollout superseded by the next one isn't verified
+	assert.NoError(t, verifier.SendChangeEvent(rolloutEvent("checkout", "acme/checkout:v2", "acme/checkout:v3", rolloutTime.Add(18*time.Minute))))
+	assert.NoError(t, verifier.SendChangeEvent(rolloutEvent("checkout", "acme/checkout:v3", "acme/checkout:v4", rolloutTime.Add(20*time.Minute))))
+	assert.Equal(t, rolloutTime.Add(20*time.Minute).Unix(), verifier.rollouts["shop/checkout"].change.Time)
+
+	verifier.verify(rolloutTime.Add(30 * time.Minute))
+	assert.Len(t, client.issues, 2)
+	resolved := client.issues[1]
+	assert.Equal(t, api.IssueResolved, resolved.EventType)
+	assert.Equal(t, api.StatusResolved, resolved.Issue.Status)
+	assert.Equal(t, client.issues[0].Issue.Fingerprint, resolved.Issue.Fingerprint)
+	assert.Empty(t, verifier.regressions)
+}
@@ -0,0 +1,210 @@
+package encryption
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+)
+
+const (
+	AlgorithmRsaOaep = "RSA-OAEP-256+A256GCM"
+	AlgorithmX25519  = "X25519-SHA256+A256GCM"
+
+	HeaderAlgorithm   = "X-Webbai-Encryption"
+	HeaderKeyId       = "X-Webbai-Key-Id"
+	HeaderWrappedKey  = "X-Webbai-Wrapped-Key"
+	HeaderContentType = "X-Webbai-Content-Type"
+
+	dataKeySize = 32
+)
+
+var oaepLabel = []byte("webbai-envelope")
+
+// Envelope is an encrypted payload together with the wrapped data key needed to decrypt it
+type Envelope struct {
+	Algorithm  string
+	KeyId      string
+	WrappedKey []byte
+	// Ciphertext is the AES-GCM nonce followed by the sealed payload
+	Ciphertext []byte
+}
+
+// Headers returns the http headers describing the envelope
+func (e *Envelope) Headers(contentType string) map[string]string {
+	return map[string]string{
+		HeaderAlgorithm:   e.Algorithm,
+		HeaderKeyId:       e.KeyId,
+		HeaderWrappedKey:  base64.StdEncoding.EncodeToString(e.WrappedKey),
+		HeaderContentType: contentType,
+		"Content-Type":    "application/octet-stream",
+	}
+}
+
+// EnvelopeFromHeaders rebuilds an envelope from the headers and body of a request
+func EnvelopeFromHeaders(header http.Header, body []byte) (*Envelope, error) {
+	wrappedKey, err := base64.StdEncoding.DecodeString(header.Get(HeaderWrappedKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid wrapped key header: %w", err)
+	}
+	return &Envelope{
+		Algorithm:  header.Get(HeaderAlgorithm),
+		KeyId:      header.Get(HeaderKeyId),
+		WrappedKey: wrappedKey,
+		Ciphertext: body,
+	}, nil
+}
+
+// Encryptor seals payloads with a fresh data key that is wrapped by the customer public key
+type Encryptor struct {
+	keys *keyLoader
+}
+
+// NewEncryptor creates an encryptor using the public key mounted in keyDir
+func NewEncryptor(keyDir string) (*Encryptor, error) {
+	keys := newKeyLoader(keyDir)
+	if _, err := keys.Load(); err != nil {
+		return nil, err
+	}
+	return &Encryptor{keys: keys}, nil
+}
+
+// Seal encrypts the payload with a new data key, so every batch sent uses its own key
+func (e *Encryptor) Seal(payload []byte) (*Envelope, error) {
+	key, err := e.keys.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, fmt.Errorf("error generating data key: %w", err)
+	}
+
+	ciphertext, err := sealWithKey(dataKey, payload, []byte(key.id))
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := &Envelope{KeyId: key.id, Ciphertext: ciphertext}
+	if key.rsa != nil {
+		envelope.Algorithm = AlgorithmRsaOaep
+		envelope.WrappedKey, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, key.rsa, dataKey, oaepLabel)
+	} else {
+		envelope.Algorithm = AlgorithmX25519
+		envelope.WrappedKey, err = wrapX25519(key.x25519, dataKey)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error wrapping data key: %w", err)
+	}
+	return envelope, nil
+}
+
+// Open decrypts an envelope with the customer private key, either *rsa.PrivateKey or X25519 *ecdh.PrivateKey
+func Open(privateKey crypto.PrivateKey, envelope *Envelope) ([]byte, error) {
+	var dataKey []byte
+	var err error
+	switch envelope.Algorithm {
+	case AlgorithmRsaOaep:
+		rsaKey, ok := privateKey.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("algorithm %s requires an rsa private key", envelope.Algorithm)
+		}
+		dataKey, err = rsa.DecryptOAEP(sha256.New(), nil, rsaKey, envelope.WrappedKey, oaepLabel)
+	case AlgorithmX25519:
+		ecdhKey, ok := privateKey.(*ecdh.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("algorithm %s requires an X25519 private key", envelope.Algorithm)
+		}
+		dataKey, err = unwrapX25519(ecdhKey, envelope.WrappedKey)
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", envelope.Algorithm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error unwrapping data key: %w", err)
+	}
+	return openWithKey(dataKey, envelope.Ciphertext, []byte(envelope.KeyId))
+}
+
+// wrapX25519 wraps the data key with a key derived from an ephemeral X25519 exchange.
+// The output is the ephemeral public key followed by the AES-GCM sealed data key.
+func wrapX25519(recipient *ecdh.PublicKey, dataKey []byte) ([]byte, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, err
+	}
+	ephemeralPublic := ephemeral.PublicKey().Bytes()
+	kek := deriveKek(shared, ephemeralPublic, recipient.Bytes())
+	sealed, err := sealWithKey(kek, dataKey, nil)
+	if err != nil {
+		return nil, err
+	}
+	return append(ephemeralPublic, sealed...), nil
+}
+
+func unwrapX25519(privateKey *ecdh.PrivateKey, wrapped []byte) ([]byte, error) {
+	keySize := len(privateKey.PublicKey().Bytes())
+	if len(wrapped) < keySize {
+		return nil, fmt.Errorf("wrapped key too short")
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(wrapped[:keySize])
+	if err != nil {
+		return nil, err
+	}
+	shared, err := privateKey.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	kek := deriveKek(shared, wrapped[:keySize], privateKey.PublicKey().Bytes())
+	return openWithKey(kek, wrapped[keySize:], nil)
+}
+
+func deriveKek(shared, ephemeralPublic, recipientPublic []byte) []byte {
+	h := sha256.New()
+	h.Write(shared)
+	h.Write(ephemeralPublic)
+	h.Write(recipientPublic)
+	return h.Sum(nil)
+}
+
+func sealWithKey(key, plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGcm(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func openWithKey(key, ciphertext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGcm(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce := ciphertext[:gcm.NonceSize()]
+	return gcm.Open(nil, nonce, ciphertext[gcm.NonceSize():], additionalData)
+}
+
+func newGcm(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,100 @@
+package encryption
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writePublicKey(t *testing.T, dir string, publicKey crypto.PublicKey) {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	assert.NoError(t, err)
+	content := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	assert.NoError(t, os.WriteFile(path.Join(dir, PublicKeyFile), content, 0600))
+}
+
+func TestSealAndOpen(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	x25519Key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name       string
+		privateKey crypto.PrivateKey
+		publicKey  crypto.PublicKey
+		algorithm  string
+	}{
+		{"rsa oaep", rsaKey, &rsaKey.PublicKey, AlgorithmRsaOaep},
+		{"x25519", x25519Key, x25519Key.PublicKey(), AlgorithmX25519},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writePublicKey(t, dir, testCase.publicKey)
+
+			encryptor, err := NewEncryptor(dir)
+			assert.NoError(t, err)
+
+			payload := []byte(`{"event_type":"object_add"}`)
+			envelope, err := encryptor.Seal(payload)
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.algorithm, envelope.Algorithm)
+			assert.NotContains(t, string(envelope.Ciphertext), "object_add")
+
+			header := http.Header{}
+			for key, value := range envelope.Headers("application/json") {
+				header.Set(key, value)
+			}
+			received, err := EnvelopeFromHeaders(header, envelope.Ciphertext)
+			assert.NoError(t, err)
+
+			plaintext, err := Open(testCase.privateKey, received)
+			assert.NoError(t, err)
+			assert.Equal(t, payload, plaintext)
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	oldKey, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	writePublicKey(t, dir, oldKey.PublicKey())
+
+	encryptor, err := NewEncryptor(dir)
+	assert.NoError(t, err)
+	first, err := encryptor.Seal([]byte("first"))
+	assert.NoError(t, err)
+
+	newKey, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	writePublicKey(t, dir, newKey.PublicKey())
+	assert.NoError(t, os.WriteFile(path.Join(dir, KeyIdFile), []byte("key-2\n"), 0600))
+
+	second, err := encryptor.Seal([]byte("second"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.KeyId, second.KeyId)
+	assert.Equal(t, "key-2", second.KeyId)
+
+	_, err = Open(oldKey, second)
+	assert.Error(t, err)
+	plaintext, err := Open(newKey, second)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("second"), plaintext)
+
+	// rotating the key id alone is picked up too
+	assert.NoError(t, os.WriteFile(path.Join(dir, KeyIdFile), []byte("key-3\n"), 0600))
+	third, err := encryptor.Seal([]byte("third"))
+	assert.NoError(t, err)
+	assert.Equal(t, "key-3", third.KeyId)
+}
@@ -0,0 +1,107 @@
+package encryption
+
+import (
+	"crypto/ecdh"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+const (
+	// PublicKeyFile is the file name of the PEM encoded customer public key in the mounted secret
+	PublicKeyFile = "public-key.pem"
+	// KeyIdFile optionally overrides the key id derived from the public key
+	KeyIdFile = "key-id"
+)
+
+// publicKey is a customer public key used to wrap data keys
+type publicKey struct {
+	id     string
+	rsa    *rsa.PublicKey
+	x25519 *ecdh.PublicKey
+}
+
+// keyLoader loads the customer public key from a directory, typically a mounted k8s secret,
+// and reloads it whenever the secret is rotated
+type keyLoader struct {
+	dir  string
+	lock sync.Mutex
+	key  *publicKey
+	// content is what the key was loaded from, the key file and the key id file
+	content string
+}
+
+func newKeyLoader(dir string) *keyLoader {
+	return &keyLoader{dir: dir}
+}
+
+// Load returns the current public key, reloading it if the key file or the key id file has changed. Both files are
+// small, they're compared as a whole since rotating only the key id leaves the key file as it was.
+func (l *keyLoader) Load() (*publicKey, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	keyPath := path.Join(l.dir, PublicKeyFile)
+	content, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading public key %s: %w", keyPath, err)
+	}
+	// the key id file is optional
+	keyId, _ := os.ReadFile(path.Join(l.dir, KeyIdFile))
+
+	if l.key != nil && l.content == string(content)+"\x00"+string(keyId) {
+		return l.key, nil
+	}
+
+	key, err := parsePublicKey(keyPath, content, keyId)
+	if err != nil {
+		return nil, err
+	}
+	l.key = key
+	l.content = string(content) + "\x00" + string(keyId)
+	return key, nil
+}
+
+func parsePublicKey(keyPath string, content, keyId []byte) (*publicKey, error) {
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", keyPath)
+	}
+
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing public key %s: %w", keyPath, err)
+	}
+
+	key := &publicKey{}
+	switch k := parsed.(type) {
+	case *rsa.PublicKey:
+		key.rsa = k
+	case *ecdh.PublicKey:
+		if k.Curve() != ecdh.X25519() {
+			return nil, fmt.Errorf("unsupported ecdh curve in %s, only X25519 is supported", keyPath)
+		}
+		key.x25519 = k
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T in %s", parsed, keyPath)
+	}
+
+	key.id = KeyId(block.Bytes)
+	if id := strings.TrimSpace(string(keyId)); id != "" {
+		key.id = id
+	}
+	return key, nil
+}
+
+// KeyId derives a stable key id from the DER encoded public key
+func KeyId(der []byte) string {
+	sum := sha256.Sum256(der)
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
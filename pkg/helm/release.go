@@ -0,0 +1,120 @@
+package helm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/webb-ai/k8s-agent/pkg/util"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// ReleaseSecretKey is the data key of the encoded release in helm release secrets
+const ReleaseSecretKey = "release"
+
+var gzipMagic = []byte{0x1f, 0x8b, 0x08}
+
+// Release is the subset of helm's release object the agent cares about
+type Release struct {
+	Name      string                 `json:"name"`
+	Namespace string                 `json:"namespace"`
+	Version   int                    `json:"version"`
+	Info      *Info                  `json:"info,omitempty"`
+	Chart     *Chart                 `json:"chart,omitempty"`
+	Config    map[string]interface{} `json:"config,omitempty"`
+	Manifest  string                 `json:"manifest,omitempty"`
+}
+
+type Info struct {
+	Status        string `json:"status,omitempty"`
+	Description   string `json:"description,omitempty"`
+	FirstDeployed string `json:"first_deployed,omitempty"`
+	LastDeployed  string `json:"last_deployed,omitempty"`
+}
+
+type Chart struct {
+	Metadata *Metadata              `json:"metadata,omitempty"`
+	Values   map[string]interface{} `json:"values,omitempty"`
+}
+
+type Metadata struct {
+	Name       string `json:"name,omitempty"`
+	Version    string `json:"version,omitempty"`
+	AppVersion string `json:"appVersion,omitempty"`
+}
+
+// ReleaseSummary describes a release without its values and manifest
+type ReleaseSummary struct {
+	Name         string `json:"name"`
+	Namespace    string `json:"namespace"`
+	Revision     int    `json:"revision"`
+	Status       string `json:"status,omitempty"`
+	Description  string `json:"description,omitempty"`
+	LastDeployed string `json:"last_deployed,omitempty"`
+	Chart        string `json:"chart,omitempty"`
+	ChartVersion string `json:"chart_version,omitempty"`
+	AppVersion   string `json:"app_version,omitempty"`
+}
+
+func (r *Release) Summary() *ReleaseSummary {
+	summary := &ReleaseSummary{
+		Name:      r.Name,
+		Namespace: r.Namespace,
+		Revision:  r.Version,
+	}
+	if r.Info != nil {
+		summary.Status = r.Info.Status
+		summary.Description = r.Info.Description
+		summary.LastDeployed = r.Info.LastDeployed
+	}
+	if r.Chart != nil && r.Chart.Metadata != nil {
+		summary.Chart = r.Chart.Metadata.Name
+		summary.ChartVersion = r.Chart.Metadata.Version
+		summary.AppVersion = r.Chart.Metadata.AppVersion
+	}
+	return summary
+}
+
+// DecodeRelease decodes a release as stored by helm: base64 encoded, optionally gzipped json
+func DecodeRelease(encoded string) (*Release, error) {
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding helm release: %w", err)
+	}
+
+	if bytes.HasPrefix(decoded, gzipMagic) {
+		reader, err := gzip.NewReader(bytes.NewReader(decoded))
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing helm release: %w", err)
+		}
+		//nolint:staticcheck // SA5001 Ignore error here
+		defer reader.Close()
+		decoded, err = io.ReadAll(reader)
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing helm release: %w", err)
+		}
+	}
+
+	var release Release
+	if err := json.Unmarshal(decoded, &release); err != nil {
+		return nil, fmt.Errorf("error parsing helm release: %w", err)
+	}
+	return &release, nil
+}
+
+// ReleaseFromSecret decodes the release stored in a helm release secret
+func ReleaseFromSecret(object *unstructured.Unstructured) (*Release, error) {
+	if !util.IsHelmSecret(object) {
+		return nil, fmt.Errorf("%s/%s is not a helm release secret", object.GetNamespace(), object.GetName())
+	}
+	data, _, _ := unstructured.NestedString(object.Object, "data", ReleaseSecretKey)
+	// secret data is base64 encoded on top of helm's own encoding
+	encoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding secret %s/%s: %w", object.GetNamespace(), object.GetName(), err)
+	}
+	return DecodeRelease(string(encoded))
+}
@@ -0,0 +1,81 @@
+package helm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// encodeRelease encodes a release the way helm stores it in a secret
+func encodeRelease(t *testing.T, release *Release) string {
+	t.Helper()
+	content, err := json.Marshal(release)
+	assert.NoError(t, err)
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	_, err = writer.Write(content)
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+
+	helmEncoded := base64.StdEncoding.EncodeToString(compressed.Bytes())
+	return base64.StdEncoding.EncodeToString([]byte(helmEncoded))
+}
+
+func TestReleaseFromSecret(t *testing.T) {
+	release := &Release{
+		Name:      "redis",
+		Namespace: "cache",
+		Version:   3,
+		Info:      &Info{Status: "deployed", Description: "Upgrade complete"},
+		Chart:     &Chart{Metadata: &Metadata{Name: "redis", Version: "17.0.1", AppVersion: "7.0.4"}},
+		Config:    map[string]interface{}{"replicas": float64(3)},
+	}
+	secret := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   map[string]interface{}{"name": "sh.helm.release.v1.redis.v3", "namespace": "cache"},
+		"type":       "helm.sh/release.v1",
+		"data":       map[string]interface{}{ReleaseSecretKey: encodeRelease(t, release)},
+	}}
+
+	decoded, err := ReleaseFromSecret(secret)
+	assert.NoError(t, err)
+	assert.Equal(t, release, decoded)
+	assert.Equal(t, &ReleaseSummary{
+		Name:         "redis",
+		Namespace:    "cache",
+		Revision:     3,
+		Status:       "deployed",
+		Description:  "Upgrade complete",
+		Chart:        "redis",
+		ChartVersion: "17.0.1",
+		AppVersion:   "7.0.4",
+	}, decoded.Summary())
+
+	secret.Object["type"] = "Opaque"
+	_, err = ReleaseFromSecret(secret)
+	assert.Error(t, err)
+}
+
+func TestDiffValues(t *testing.T) {
+	previous := &Release{
+		Chart:  &Chart{Values: map[string]interface{}{"image": map[string]interface{}{"tag": "1.0", "pullPolicy": "Always"}, "replicas": 1}},
+		Config: map[string]interface{}{"replicas": 2},
+	}
+	current := &Release{
+		Chart:  &Chart{Values: map[string]interface{}{"image": map[string]interface{}{"tag": "1.0", "pullPolicy": "Always"}, "replicas": 1}},
+		Config: map[string]interface{}{"replicas": 2, "image": map[string]interface{}{"tag": "1.1"}, "ingress": true},
+	}
+
+	assert.Equal(t, []ValueChange{
+		{Path: "image.tag", Old: "1.0", New: "1.1"},
+		{Path: "ingress", New: true},
+	}, DiffValues(previous.ComputedValues(), current.ComputedValues()))
+	assert.Empty(t, DiffValues(current.ComputedValues(), current.ComputedValues()))
+}
@@ -0,0 +1,78 @@
+package helm
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ValueChange is a value that differs between two revisions of a release
+type ValueChange struct {
+	Path string      `json:"path"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// SecretName returns the name of the secret helm stores a release revision in
+func SecretName(name string, revision int) string {
+	return fmt.Sprintf("sh.helm.release.v1.%s.v%d", name, revision)
+}
+
+// ComputedValues returns the chart default values overridden by the user supplied values, like helm does on install
+func (r *Release) ComputedValues() map[string]interface{} {
+	var defaults map[string]interface{}
+	if r.Chart != nil {
+		defaults = r.Chart.Values
+	}
+	return mergeValues(defaults, r.Config)
+}
+
+func mergeValues(base, overrides map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(base)+len(overrides))
+	for key, value := range base {
+		result[key] = value
+	}
+	for key, value := range overrides {
+		baseMap, baseIsMap := result[key].(map[string]interface{})
+		overrideMap, overrideIsMap := value.(map[string]interface{})
+		if baseIsMap && overrideIsMap {
+			result[key] = mergeValues(baseMap, overrideMap)
+		} else {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// DiffValues returns the changed leaf values between two value trees, sorted by path. Lists are compared as a whole.
+func DiffValues(oldValues, newValues map[string]interface{}) []ValueChange {
+	var changes []ValueChange
+	diffValues("", oldValues, newValues, &changes)
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+func diffValues(prefix string, oldValues, newValues map[string]interface{}, changes *[]ValueChange) {
+	keys := make(map[string]struct{}, len(oldValues)+len(newValues))
+	for key := range oldValues {
+		keys[key] = struct{}{}
+	}
+	for key := range newValues {
+		keys[key] = struct{}{}
+	}
+
+	for key := range keys {
+		path := strings.TrimPrefix(prefix+"."+key, ".")
+		oldValue, inOld := oldValues[key]
+		newValue, inNew := newValues[key]
+		oldMap, oldIsMap := oldValue.(map[string]interface{})
+		newMap, newIsMap := newValue.(map[string]interface{})
+		switch {
+		case inOld && inNew && oldIsMap && newIsMap:
+			diffValues(path, oldMap, newMap, changes)
+		case !reflect.DeepEqual(oldValue, newValue) || inOld != inNew:
+			*changes = append(*changes, ValueChange{Path: path, Old: oldValue, New: newValue})
+		}
+	}
+}
@@ -46,6 +46,11 @@ func GetAccessToken(retryClient *retryablehttp.Client, tokenUrl, clientId, clien
 }
 
 func SendRequestWithToken(retryClient *retryablehttp.Client, url, token string, body []byte) (*http.Response, error) {
+	return SendRequestWithHeaders(retryClient, url, token, body, nil)
+}
+
+// SendRequestWithHeaders posts a json body with the bearer token, headers override the defaults
+func SendRequestWithHeaders(retryClient *retryablehttp.Client, url, token string, body []byte, headers map[string]string) (*http.Response, error) {
 	request, err := retryablehttp.NewRequest("POST", url, body)
 	if err != nil {
 		return nil, err
@@ -53,5 +58,8 @@ func SendRequestWithToken(retryClient *retryablehttp.Client, url, token string,
 
 	request.Header.Set("Authorization", "Bearer "+token)
 	request.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
 	return retryClient.Do(request)
 }
@@ -17,6 +17,8 @@ import (
 
 	"github.com/hashicorp/go-retryablehttp"
 	"github.com/webb-ai/k8s-agent/pkg/api"
+	"github.com/webb-ai/k8s-agent/pkg/encryption"
+	"github.com/webb-ai/k8s-agent/pkg/signing"
 	"go.uber.org/atomic"
 )
 
@@ -27,6 +29,8 @@ type AgentInfo struct {
 	LastKafkaCollectionTime    int64  `json:"last_kafka_collection_time"`
 	LastResourceCollectionTime int64  `json:"last_resource_collection_time"`
 	LastTrafficCollectionTime  int64  `json:"last_traffic_collection_time"`
+	SigningKeyId               string `json:"signing_key_id,omitempty"`
+	SigningPublicKey           string `json:"signing_public_key,omitempty"`
 }
 
 type WebbaiHttpClient struct {
@@ -38,11 +42,17 @@ type WebbaiHttpClient struct {
 	MetricsUrl   string
 	AgentInfoUrl string
 	IssueUrl     string
+	DeployUrl    string
 	token        atomic.String
 	agentInfo    *AgentInfo
+	encryptor    *encryption.Encryptor
+	signer       *signing.Signer
 }
 
-func NewWebbaiClient(agentVersion, kafkaServer string) api.Client {
+// NewWebbaiClient creates a client streaming to webb.ai. If encryptor is not nil,
+// change events, resource lists and issues are encrypted with the customer key before leaving the cluster.
+// If signer is not nil, every request body is signed and the public key is registered via SendAgentInfo.
+func NewWebbaiClient(agentVersion, kafkaServer string, encryptor *encryption.Encryptor, signer *signing.Signer) api.Client {
 	clientId := os.Getenv("CLIENT_ID")
 	clientSecret := os.Getenv("API_KEY")
 
@@ -54,6 +64,10 @@ func NewWebbaiClient(agentVersion, kafkaServer string) api.Client {
 		AgentVersion: agentVersion,
 		KafkaServer:  kafkaServer,
 	}
+	if signer != nil {
+		agentInfo.SigningKeyId = signer.KeyId()
+		agentInfo.SigningPublicKey = signer.PublicKey()
+	}
 
 	client := &WebbaiHttpClient{
 		ClientId:     clientId,
@@ -64,7 +78,10 @@ func NewWebbaiClient(agentVersion, kafkaServer string) api.Client {
 		MetricsUrl:   "https://api.webb.ai/metrics",
 		AgentInfoUrl: "https://api.webb.ai/agent_info",
 		IssueUrl:     "https://api.webb.ai/issue",
+		DeployUrl:    "https://api.webb.ai/deploy_markers",
 		agentInfo:    agentInfo,
+		encryptor:    encryptor,
+		signer:       signer,
 	}
 	err := client.obtainNewToken()
 	if err != nil {
@@ -82,14 +99,14 @@ func (c *WebbaiHttpClient) SendChangeEvent(event *api.ChangeEvent) error {
 	} else {
 		c.agentInfo.LastChangeCollectionTime = event.Time
 	}
-	err := c.sendRequest(c.ChangeUrl, event)
+	err := c.sendEncryptedRequest(c.ChangeUrl, event)
 	return err
 }
 
 func (c *WebbaiHttpClient) SendK8sResources(list *api.ResourceList) error {
 	klog.Infof("sending k8s resource list to %s", c.ResourceUrl)
 	c.agentInfo.LastResourceCollectionTime = list.Time
-	err := c.sendRequest(c.ResourceUrl, list)
+	err := c.sendEncryptedRequest(c.ResourceUrl, list)
 	return err
 }
 
@@ -112,6 +129,9 @@ func (c *WebbaiHttpClient) SendTrafficMetrics(request *prompb.WriteRequest) erro
 	req.Header.Set("Content-Type", "application/x-protobuf")
 	req.Header.Set("Content-Encoding", "snappy")
 	req.Header.Set("Authorization", "Bearer "+c.token.Load())
+	for key, value := range c.signatureHeaders(compressed) {
+		req.Header.Set(key, value)
+	}
 
 	client := makeHttpClient()
 
@@ -138,9 +158,16 @@ func (c *WebbaiHttpClient) SendTrafficMetrics(request *prompb.WriteRequest) erro
 	return nil
 }
 
+// SendIssue sends an issue encrypted like change events, its context holds snapshots and events of the cluster
 func (c *WebbaiHttpClient) SendIssue(issueRequest *api.IssueRequest) error {
 	klog.Infof("sending issue to %s", c.IssueUrl)
-	err := c.sendRequest(c.IssueUrl, issueRequest)
+	err := c.sendEncryptedRequest(c.IssueUrl, issueRequest)
+	return err
+}
+
+func (c *WebbaiHttpClient) SendDeployMarker(marker *api.DeployMarker) error {
+	klog.Infof("sending deploy marker to %s", c.DeployUrl)
+	err := c.sendRequest(c.DeployUrl, marker)
 	return err
 }
 
@@ -159,8 +186,50 @@ func (c *WebbaiHttpClient) sendRequest(url string, data interface{}) error {
 		klog.Error(err)
 		return err
 	}
+	return c.sendBody(url, bytes, nil)
+}
+
+// sendEncryptedRequest is like sendRequest, but seals the body with the customer key if encryption is enabled
+func (c *WebbaiHttpClient) sendEncryptedRequest(url string, data interface{}) error {
+	if c.encryptor == nil {
+		return c.sendRequest(url, data)
+	}
+	bytes, err := json.Marshal(data)
+	if err != nil {
+		klog.Error(err)
+		return err
+	}
+	envelope, err := c.encryptor.Seal(bytes)
+	if err != nil {
+		// never fall back to plaintext when encryption is required
+		klog.Error(err)
+		return err
+	}
+	return c.sendBody(url, envelope.Ciphertext, envelope.Headers("application/json"))
+}
+
+// signatureHeaders signs the body as it is sent on the wire, i.e. after compression or encryption
+func (c *WebbaiHttpClient) signatureHeaders(body []byte) map[string]string {
+	if c.signer == nil {
+		return nil
+	}
+	return c.signer.Sign(body).Headers()
+}
+
+func (c *WebbaiHttpClient) sendBody(url string, body []byte, headers map[string]string) error {
+	signatureHeaders := c.signatureHeaders(body)
+	if len(signatureHeaders) > 0 {
+		merged := make(map[string]string, len(headers)+len(signatureHeaders))
+		for key, value := range headers {
+			merged[key] = value
+		}
+		for key, value := range signatureHeaders {
+			merged[key] = value
+		}
+		headers = merged
+	}
 	client := makeHttpClient()
-	response, err := SendRequestWithToken(client, url, c.token.Load(), bytes)
+	response, err := SendRequestWithHeaders(client, url, c.token.Load(), body, headers)
 	if err != nil {
 		klog.Error(err)
 		return err
@@ -173,7 +242,7 @@ func (c *WebbaiHttpClient) sendRequest(url string, data interface{}) error {
 			klog.Error(err)
 			return err
 		}
-		response, err = SendRequestWithToken(client, url, c.token.Load(), bytes)
+		response, err = SendRequestWithHeaders(client, url, c.token.Load(), body, headers)
 		if response != nil {
 			//nolint:staticcheck // SA5001 Ignore error here
 			defer response.Body.Close()
@@ -0,0 +1,56 @@
+package http
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	nethttp "net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webb-ai/k8s-agent/pkg/api"
+	"github.com/webb-ai/k8s-agent/pkg/encryption"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestSendIssueEncrypted(t *testing.T) {
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(privateKey.PublicKey())
+	assert.NoError(t, err)
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(path.Join(dir, encryption.PublicKeyFile), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600))
+	encryptor, err := encryption.NewEncryptor(dir)
+	assert.NoError(t, err)
+
+	var header nethttp.Header
+	var body []byte
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		header = r.Header
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+	client := &WebbaiHttpClient{IssueUrl: server.URL, agentInfo: &AgentInfo{}, encryptor: encryptor}
+
+	configMap := &unstructured.Unstructured{Object: map[string]interface{}{"kind": "ConfigMap", "data": map[string]interface{}{"password": "hunter2"}}}
+	issue := &api.IssueRequest{
+		IssueSource: "alertmanager",
+		Context:     &api.IssueContext{Objects: []*api.RelatedObject{{Object: configMap}}},
+	}
+	assert.NoError(t, client.SendIssue(issue))
+	assert.NotContains(t, string(body), "hunter2")
+
+	envelope, err := encryption.EnvelopeFromHeaders(header, body)
+	assert.NoError(t, err)
+	plaintext, err := encryption.Open(privateKey, envelope)
+	assert.NoError(t, err)
+	var received api.IssueRequest
+	assert.NoError(t, json.Unmarshal(plaintext, &received))
+	assert.Equal(t, issue, &received)
+}
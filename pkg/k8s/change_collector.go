@@ -10,7 +10,9 @@ import (
 
 	"k8s.io/apimachinery/pkg/labels"
 
+	"github.com/webb-ai/k8s-agent/pkg/helm"
 	"github.com/webb-ai/k8s-agent/pkg/util"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 
 	"github.com/rs/zerolog"
 	"github.com/webb-ai/k8s-agent/pkg/api"
@@ -27,8 +29,12 @@ type ChangeCollector struct {
 	logger                   zerolog.Logger
 	client                   api.Client
 	metrics                  *Metrics
+	history                  *ChangeHistory
 }
 
+// changeHistoryRetention is how long the change history of an object is kept after its last change
+const changeHistoryRetention = 24 * time.Hour
+
 func NewChangeCollector(
 	eventCollectionInterval time.Duration,
 	backupCollectionInterval time.Duration,
@@ -36,6 +42,7 @@ func NewChangeCollector(
 	discoveryClient discovery.ServerResourcesInterface,
 	logger zerolog.Logger,
 	client api.Client,
+	changeHistorySize int,
 ) *ChangeCollector {
 	return &ChangeCollector{
 		eventCollectionInterval:  eventCollectionInterval,
@@ -44,7 +51,8 @@ func NewChangeCollector(
 		discoveryClient:          discoveryClient,
 		logger:                   logger,
 		client:                   client,
-		metrics:                  NewMetrics(),
+		metrics:                  changeMetrics,
+		history:                  NewChangeHistory(changeHistorySize, changeHistoryRetention),
 	}
 }
 
@@ -57,6 +65,12 @@ func (c *ChangeCollector) noOpUpdate(oldObj, newObj interface{}) {
 }
 
 func (c *ChangeCollector) OnAdd(obj interface{}) {
+	c.onAdd(obj, false)
+}
+
+// onAdd records objects in the change history unless they come from the initial list of the informer,
+// those weren't changed
+func (c *ChangeCollector) onAdd(obj interface{}, isInInitialList bool) {
 	// TODO: retry on retryable errors
 	runtimeObject, err := util.InterfaceToUnstructured(obj)
 	if err != nil {
@@ -64,8 +78,16 @@ func (c *ChangeCollector) OnAdd(obj interface{}) {
 		return
 	}
 
+	if util.IsHelmSecret(runtimeObject) {
+		c.onHelmRelease(nil, runtimeObject, !isInInitialList)
+		return
+	}
+
 	event := api.NewK8sChangeEvent(nil, runtimeObject)
 	c.logger.Info().Any("payload", event).Msg("object_add")
+	if !isInInitialList {
+		c.history.Record(event)
+	}
 
 	_ = c.client.SendChangeEvent(event)
 
@@ -84,9 +106,15 @@ func (c *ChangeCollector) OnDelete(obj interface{}) {
 		return
 	}
 
+	if util.IsHelmSecret(runtimeObject) {
+		c.onHelmRelease(runtimeObject, nil, true)
+		return
+	}
+
 	event := api.NewK8sChangeEvent(runtimeObject, nil)
 
 	c.logger.Info().Any("payload", event).Msg("object_delete")
+	c.history.Record(event)
 	_ = c.client.SendChangeEvent(event)
 	c.metrics.ChangeEventCounter.With(
 		map[string]string{
@@ -117,8 +145,13 @@ func (c *ChangeCollector) OnUpdate(oldObj, newObj interface{}) {
 	if oldObject.GetResourceVersion() != newObject.GetResourceVersion() || util.HasStatusChanged(oldObject, newObject) {
 		klog.Infof("detected resource version change or status change of %s/%s(%s)",
 			newObject.GetNamespace(), newObject.GetName(), newObject.GroupVersionKind())
+		if util.IsHelmSecret(newObject) {
+			c.onHelmRelease(oldObject, newObject, true)
+			return
+		}
 		event := api.NewK8sChangeEvent(oldObject, newObject)
 		c.logger.Info().Any("payload", event).Msg("object_update")
+		c.history.Record(event)
 
 		_ = c.client.SendChangeEvent(event)
 
@@ -132,6 +165,36 @@ func (c *ChangeCollector) OnUpdate(oldObj, newObj interface{}) {
 
 }
 
+// onHelmRelease sends a helm_release event in place of the change event of a helm release secret
+func (c *ChangeCollector) onHelmRelease(oldObject, newObject *unstructured.Unstructured, record bool) {
+	event, err := api.NewHelmReleaseEvent(oldObject, newObject, c.lookupHelmRelease)
+	if err != nil {
+		klog.Error(err)
+		return
+	}
+	c.logger.Info().Any("payload", event).Msg(string(api.HelmRelease))
+	if record {
+		c.history.Record(event)
+	}
+
+	_ = c.client.SendChangeEvent(event)
+
+	c.metrics.ChangeEventCounter.With(
+		map[string]string{
+			EventTypeKey:  string(api.HelmRelease),
+			ObjectKindKey: "Secret",
+		},
+	).Inc()
+}
+
+func (c *ChangeCollector) lookupHelmRelease(namespace, name string, revision int) (*helm.Release, error) {
+	object, err := c.informerFactory.ForResource(secretGVR).Lister().ByNamespace(namespace).Get(helm.SecretName(name, revision))
+	if err != nil {
+		return nil, err
+	}
+	return helm.ReleaseFromSecret(object.(*unstructured.Unstructured))
+}
+
 func (c *ChangeCollector) addHandlerForGvr(gvr schema.GroupVersionResource, handler cache.ResourceEventHandler) {
 	klog.Infof("starting to watch for resource %v", gvr)
 	informer := c.informerFactory.ForResource(gvr)
@@ -144,8 +207,8 @@ func (c *ChangeCollector) addHandlerForGvr(gvr schema.GroupVersionResource, hand
 func (c *ChangeCollector) Start(ctx context.Context) error {
 	klog.Infof("starting k8s resource collector process")
 
-	handler := cache.ResourceEventHandlerFuncs{
-		AddFunc:    c.OnAdd,
+	handler := cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc:    c.onAdd,
 		UpdateFunc: c.OnUpdate,
 		DeleteFunc: c.OnDelete,
 	}
@@ -0,0 +1,168 @@
+package k8s
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/webb-ai/k8s-agent/pkg/api"
+	"github.com/webb-ai/k8s-agent/pkg/helm"
+	"github.com/webb-ai/k8s-agent/pkg/redact"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+type changeEventClient struct {
+	api.NoOpClient
+	lock   sync.Mutex
+	events []*api.ChangeEvent
+}
+
+func (c *changeEventClient) SendChangeEvent(event *api.ChangeEvent) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.events = append(c.events, event)
+	return nil
+}
+
+func (c *changeEventClient) received() []*api.ChangeEvent {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]*api.ChangeEvent(nil), c.events...)
+}
+
+func newSecret(resourceVersion, password string) *unstructured.Unstructured {
+	secret := newObject("v1", "Secret", "shop", "database", "secret-uid", nil)
+	secret.SetResourceVersion(resourceVersion)
+	secret.Object["data"] = map[string]interface{}{
+		"user":     base64.StdEncoding.EncodeToString([]byte("checkout")),
+		"password": base64.StdEncoding.EncodeToString([]byte(password)),
+	}
+	return secret
+}
+
+// newHelmReleaseSecret encodes a release the way helm stores it in a secret
+func newHelmReleaseSecret(t *testing.T, revision int, values map[string]interface{}) *unstructured.Unstructured {
+	content, err := json.Marshal(&helm.Release{Name: "redis", Namespace: "cache", Version: revision, Config: values})
+	assert.NoError(t, err)
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	_, err = writer.Write(content)
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+
+	secret := newObject("v1", "Secret", "cache", helm.SecretName("redis", revision), "release-uid-"+strconv.Itoa(revision), nil)
+	secret.Object["type"] = "helm.sh/release.v1"
+	secret.Object["data"] = map[string]interface{}{
+		helm.ReleaseSecretKey: base64.StdEncoding.EncodeToString([]byte(base64.StdEncoding.EncodeToString(compressed.Bytes()))),
+	}
+	return secret
+}
+
+// startChangeCollector runs a change collector over the secrets of a fake cluster with a salted redaction policy
+func startChangeCollector(t *testing.T, ctx context.Context, objects ...runtime.Object) (*dynamicfake.FakeDynamicClient, *ChangeCollector, *changeEventClient) {
+	policy, err := redact.NewPolicy("salt", nil, redact.DefaultRules()...)
+	assert.NoError(t, err)
+	previous := api.RedactionPolicy
+	api.RedactionPolicy = policy
+	t.Cleanup(func() {
+		api.RedactionPolicy = previous
+	})
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{secretGVR: "List"}, objects...)
+	informerFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, time.Minute)
+	client := &changeEventClient{}
+	collector := NewChangeCollector(time.Minute, time.Minute, informerFactory, nil, zerolog.Nop(), client, 10)
+	_, err = informerFactory.ForResource(secretGVR).Informer().AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc:    collector.onAdd,
+		UpdateFunc: collector.OnUpdate,
+		DeleteFunc: collector.OnDelete,
+	})
+	assert.NoError(t, err)
+	informerFactory.Start(ctx.Done())
+	informerFactory.WaitForCacheSync(ctx.Done())
+	return dynamicClient, collector, client
+}
+
+func TestChangeCollectorFingerprintsCachedSecrets(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dynamicClient, collector, client := startChangeCollector(t, ctx, newSecret("1", "first"))
+
+	// each update is diffed against the object the cache handed to the previous one
+	secrets := dynamicClient.Resource(secretGVR).Namespace("shop")
+	for i, password := range []string{"second", "third"} {
+		_, err := secrets.Update(ctx, newSecret(strconv.Itoa(i+2), password), metav1.UpdateOptions{})
+		assert.NoError(t, err)
+		assert.Eventually(t, func() bool {
+			return len(client.received()) == i+2
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+
+	for _, event := range client.received()[1:] {
+		assert.Equal(t, api.ObjectUpdate, event.EventType)
+		assert.Empty(t, event.SecretChange.Added)
+		assert.Equal(t, []string{"password"}, event.SecretChange.Rotated)
+		assert.NotContains(t, event.NewObject.Object, "data")
+	}
+	cached, err := collector.informerFactory.ForResource(secretGVR).Lister().ByNamespace("shop").Get("database")
+	assert.NoError(t, err)
+	assert.Contains(t, cached.(*unstructured.Unstructured).Object, "data")
+}
+
+func TestChangeCollectorDiffsCachedHelmReleases(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dynamicClient, _, client := startChangeCollector(t, ctx,
+		newHelmReleaseSecret(t, 1, map[string]interface{}{"replicas": float64(1)}))
+	assert.Eventually(t, func() bool {
+		return len(client.received()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the previous revision is looked up in the cache, after its own event was sent
+	_, err := dynamicClient.Resource(secretGVR).Namespace("cache").Create(ctx,
+		newHelmReleaseSecret(t, 2, map[string]interface{}{"replicas": float64(3)}), metav1.CreateOptions{})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return len(client.received()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	event := client.received()[1]
+	assert.Equal(t, api.HelmRelease, event.EventType)
+	assert.Equal(t, 1, event.HelmRelease.PreviousRelease.Revision)
+	assert.Len(t, event.HelmRelease.ValuesDiff, 1)
+}
+
+func TestChangeCollectorSkipsInitialListInHistory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dynamicClient, collector, client := startChangeCollector(t, ctx, newSecret("1", "first"))
+	assert.Eventually(t, func() bool {
+		return len(client.received()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Empty(t, collector.history.Get("Secret", "shop", "database"))
+
+	_, err := dynamicClient.Resource(secretGVR).Namespace("shop").Update(ctx, newSecret("2", "second"), metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return len(client.received()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	records := collector.history.Get("Secret", "shop", "database")
+	assert.Len(t, records, 1)
+	assert.Equal(t, api.ObjectUpdate, records[0].EventType)
+}
@@ -0,0 +1,103 @@
+package k8s
+
+import (
+	"sync"
+	"time"
+
+	"github.com/webb-ai/k8s-agent/pkg/api"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+type objectKey struct {
+	kind      string
+	namespace string
+	name      string
+}
+
+type objectHistory struct {
+	records    []api.ChangeRecord
+	lastChange time.Time
+}
+
+// ChangeHistory keeps the last change records of every object changed within the retention
+type ChangeHistory struct {
+	size      int
+	retention time.Duration
+
+	mutex     sync.Mutex
+	objects   map[objectKey]*objectHistory
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+func NewChangeHistory(size int, retention time.Duration) *ChangeHistory {
+	return &ChangeHistory{
+		size:      size,
+		retention: retention,
+		objects:   make(map[objectKey]*objectHistory),
+		now:       time.Now,
+	}
+}
+
+// Record adds a change event to the history of its object
+func (h *ChangeHistory) Record(event *api.ChangeEvent) {
+	if h == nil || h.size <= 0 {
+		return
+	}
+	object := event.NewObject
+	if object == nil {
+		object = event.OldObject
+	}
+	if object == nil {
+		return
+	}
+	record := api.NewChangeRecord(event)
+	key := keyOf(object)
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	now := h.now()
+	h.prune(now)
+
+	history, found := h.objects[key]
+	if !found {
+		history = &objectHistory{}
+		h.objects[key] = history
+	}
+	history.records = append(history.records, record)
+	if len(history.records) > h.size {
+		history.records = history.records[len(history.records)-h.size:]
+	}
+	history.lastChange = now
+}
+
+// Get returns the change records of an object, most recent last
+func (h *ChangeHistory) Get(kind, namespace, name string) []api.ChangeRecord {
+	if h == nil {
+		return nil
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	history, found := h.objects[objectKey{kind: kind, namespace: namespace, name: name}]
+	if !found {
+		return nil
+	}
+	return append([]api.ChangeRecord(nil), history.records...)
+}
+
+// prune forgets objects without changes within the retention, at most once a minute
+func (h *ChangeHistory) prune(now time.Time) {
+	if now.Sub(h.lastPrune) < time.Minute {
+		return
+	}
+	h.lastPrune = now
+	for key, history := range h.objects {
+		if now.Sub(history.lastChange) > h.retention {
+			delete(h.objects, key)
+		}
+	}
+}
+
+func keyOf(object *unstructured.Unstructured) objectKey {
+	return objectKey{kind: object.GetKind(), namespace: object.GetNamespace(), name: object.GetName()}
+}
@@ -0,0 +1,175 @@
+package k8s
+
+import (
+	"sort"
+
+	"github.com/webb-ai/k8s-agent/pkg/api"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/klog/v2"
+)
+
+// maxOwnerDepth bounds the owner reference walk, e.g. pod -> replicaset -> deployment
+const maxOwnerDepth = 5
+
+// maxIssueEvents bounds the number of warning events attached to an issue
+const maxIssueEvents = 20
+
+// kindGVRs are the kinds issues can be related to, they must all be watched
+var kindGVRs = map[string]schema.GroupVersionResource{
+	"CronJob":     cronjobGVR,
+	"DaemonSet":   daemonsetGVR,
+	"Deployment":  deploymentGVR,
+	"Job":         jobGVR,
+	"Namespace":   namespaceGVR,
+	"Node":        nodeGVR,
+	"Pod":         podGVR,
+	"ReplicaSet":  replicasetGVR,
+	"Service":     serviceGVR,
+	"StatefulSet": statefulsetGVR,
+}
+
+// issueLabelKinds maps the labels of alerts, as set by kube-state-metrics and most alerting rules, to kinds
+var issueLabelKinds = []struct {
+	label string
+	kind  string
+}{
+	{"pod", "Pod"},
+	{"deployment", "Deployment"},
+	{"statefulset", "StatefulSet"},
+	{"daemonset", "DaemonSet"},
+	{"replicaset", "ReplicaSet"},
+	{"job_name", "Job"},
+	{"cronjob", "CronJob"},
+	{"service", "Service"},
+	{"node", "Node"},
+}
+
+// EnrichIssue resolves the labels of an issue to objects in the informer caches, walks their owners up to the
+// top level workload, and returns snapshots of these objects with their recent changes and warning events
+func (c *ChangeCollector) EnrichIssue(issue *api.Issue) *api.IssueContext {
+	namespace := issue.Labels["namespace"]
+	var objects []*unstructured.Unstructured
+	seen := make(map[string]struct{})
+	add := func(object *unstructured.Unstructured) bool {
+		if _, found := seen[string(object.GetUID())]; found {
+			return false
+		}
+		seen[string(object.GetUID())] = struct{}{}
+		objects = append(objects, object)
+		return true
+	}
+
+	for _, labelKind := range issueLabelKinds {
+		name := issue.Labels[labelKind.label]
+		if name == "" {
+			continue
+		}
+		object := getObject(c.informerFactory, labelKind.kind, namespace, name)
+		if object == nil || !add(object) {
+			continue
+		}
+		for depth := 0; depth < maxOwnerDepth; depth++ {
+			object = getOwner(c.informerFactory, object)
+			if object == nil || !add(object) {
+				break
+			}
+		}
+	}
+	if namespace != "" {
+		if object := getObject(c.informerFactory, "Namespace", "", namespace); object != nil {
+			add(object)
+		}
+	}
+	if len(objects) == 0 {
+		return nil
+	}
+
+	issueContext := &api.IssueContext{Events: c.warningEvents(seen)}
+	for _, object := range objects {
+		issueContext.Objects = append(issueContext.Objects, &api.RelatedObject{
+			Object:  api.NewSnapshot(object),
+			Changes: c.history.Get(object.GetKind(), object.GetNamespace(), object.GetName()),
+		})
+	}
+	return issueContext
+}
+
+// getObject returns an object of one of the kindGVRs from the informer caches
+func getObject(informerFactory dynamicinformer.DynamicSharedInformerFactory, kind, namespace, name string) *unstructured.Unstructured {
+	gvr, ok := kindGVRs[kind]
+	if !ok {
+		return nil
+	}
+	lister := informerFactory.ForResource(gvr).Lister()
+	var object interface{}
+	var err error
+	if kind == "Namespace" || kind == "Node" {
+		object, err = lister.Get(name)
+	} else {
+		if namespace == "" {
+			return nil
+		}
+		object, err = lister.ByNamespace(namespace).Get(name)
+	}
+	if err != nil {
+		klog.V(4).Infof("unable to find %s %s/%s: %v", kind, namespace, name, err)
+		return nil
+	}
+	unstr, _ := object.(*unstructured.Unstructured)
+	return unstr
+}
+
+// getOwner returns the controller of an object, if it's in the caches
+func getOwner(informerFactory dynamicinformer.DynamicSharedInformerFactory, object *unstructured.Unstructured) *unstructured.Unstructured {
+	for _, owner := range object.GetOwnerReferences() {
+		if owner.Controller != nil && *owner.Controller {
+			return getObject(informerFactory, owner.Kind, object.GetNamespace(), owner.Name)
+		}
+	}
+	return nil
+}
+
+// warningEvents returns the most recent warning events involving the objects with the given uids
+func (c *ChangeCollector) warningEvents(uids map[string]struct{}) []*unstructured.Unstructured {
+	list, err := c.informerFactory.ForResource(eventGVR).Lister().List(labels.Everything())
+	if err != nil {
+		klog.Error(err)
+		return nil
+	}
+	var events []*unstructured.Unstructured
+	for _, item := range list {
+		event, ok := item.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		eventType, _, _ := unstructured.NestedString(event.Object, "type")
+		uid, _, _ := unstructured.NestedString(event.Object, "involvedObject", "uid")
+		if _, found := uids[uid]; found && eventType == "Warning" {
+			events = append(events, event)
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return lastSeen(events[i]) > lastSeen(events[j])
+	})
+	if len(events) > maxIssueEvents {
+		events = events[:maxIssueEvents]
+	}
+	for i, event := range events {
+		events[i] = api.NewSnapshot(event)
+	}
+	return events
+}
+
+// lastSeen returns the last occurrence of an event as an RFC 3339 timestamp, which sorts chronologically
+func lastSeen(event *unstructured.Unstructured) string {
+	for _, field := range []string{"lastTimestamp", "eventTime"} {
+		if timestamp, _, _ := unstructured.NestedString(event.Object, field); timestamp != "" {
+			return timestamp
+		}
+	}
+	return event.GetCreationTimestamp().UTC().Format("2006-01-02T15:04:05Z")
+}
@@ -0,0 +1,91 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webb-ai/k8s-agent/pkg/api"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func newObject(apiVersion, kind, namespace, name, uid string, owner *unstructured.Unstructured) *unstructured.Unstructured {
+	object := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       kind,
+		"metadata": map[string]interface{}{
+			"name": name,
+			"uid":  uid,
+		},
+	}}
+	if namespace != "" {
+		object.SetNamespace(namespace)
+	}
+	if owner != nil {
+		_ = unstructured.SetNestedSlice(object.Object, []interface{}{map[string]interface{}{
+			"apiVersion": owner.GetAPIVersion(),
+			"kind":       owner.GetKind(),
+			"name":       owner.GetName(),
+			"uid":        string(owner.GetUID()),
+			"controller": true,
+		}}, "metadata", "ownerReferences")
+	}
+	return object
+}
+
+func TestEnrichIssue(t *testing.T) {
+	namespace := newObject("v1", "Namespace", "", "shop", "ns-uid", nil)
+	deployment := newObject("apps/v1", "Deployment", "shop", "checkout", "deployment-uid", nil)
+	replicaSet := newObject("apps/v1", "ReplicaSet", "shop", "checkout-7d9f", "replicaset-uid", deployment)
+	pod := newObject("v1", "Pod", "shop", "checkout-7d9f-abcde", "pod-uid", replicaSet)
+	warning := newObject("v1", "Event", "shop", "checkout-7d9f-abcde.1", "event-uid", nil)
+	warning.Object["type"] = "Warning"
+	warning.Object["reason"] = "BackOff"
+	warning.Object["involvedObject"] = map[string]interface{}{"kind": "Pod", "name": pod.GetName(), "uid": "pod-uid"}
+	normal := newObject("v1", "Event", "shop", "checkout-7d9f-abcde.2", "event-uid-2", nil)
+	normal.Object["type"] = "Normal"
+	normal.Object["involvedObject"] = map[string]interface{}{"kind": "Pod", "name": pod.GetName(), "uid": "pod-uid"}
+
+	gvrs := []schema.GroupVersionResource{namespaceGVR, deploymentGVR, replicasetGVR, podGVR, eventGVR}
+	listKinds := map[schema.GroupVersionResource]string{}
+	for _, gvr := range gvrs {
+		listKinds[gvr] = "List"
+	}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds,
+		namespace, deployment, replicaSet, pod, warning, normal)
+	informerFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, time.Minute)
+	for _, gvr := range gvrs {
+		informerFactory.ForResource(gvr)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	informerFactory.Start(ctx.Done())
+	informerFactory.WaitForCacheSync(ctx.Done())
+
+	collector := &ChangeCollector{informerFactory: informerFactory, history: NewChangeHistory(2, time.Hour)}
+	for _, replicas := range []int64{1, 2, 3} {
+		oldDeployment, newDeployment := deployment.DeepCopy(), deployment.DeepCopy()
+		_ = unstructured.SetNestedField(oldDeployment.Object, replicas-1, "spec", "replicas")
+		_ = unstructured.SetNestedField(newDeployment.Object, replicas, "spec", "replicas")
+		collector.history.Record(api.NewK8sChangeEvent(oldDeployment, newDeployment))
+	}
+
+	issueContext := collector.EnrichIssue(&api.Issue{Labels: map[string]string{"namespace": "shop", "pod": pod.GetName()}})
+	var names []string
+	for _, object := range issueContext.Objects {
+		names = append(names, object.Object.GetName())
+	}
+	assert.Equal(t, []string{pod.GetName(), replicaSet.GetName(), deployment.GetName(), "shop"}, names)
+	// only the last two changes are kept
+	assert.Len(t, issueContext.Objects[2].Changes, 2)
+	assert.Equal(t, []string{".spec.replicas"}, issueContext.Objects[2].Changes[1].ChangedFields)
+	assert.Len(t, issueContext.Events, 1)
+	assert.Equal(t, "BackOff", issueContext.Events[0].Object["reason"])
+
+	assert.Nil(t, collector.EnrichIssue(&api.Issue{Labels: map[string]string{"namespace": "other", "pod": "missing"}}))
+}
@@ -8,6 +8,9 @@ import (
 const EventTypeKey = "event_type"
 const ObjectKindKey = "object_kind"
 
+// changeMetrics are shared by all change collectors, metrics can only be registered once
+var changeMetrics = NewMetrics()
+
 type Metrics struct {
 	ChangeEventCounter *prometheus.CounterVec
 }
@@ -0,0 +1,319 @@
+package k8s
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/webb-ai/k8s-agent/pkg/api"
+	"github.com/webb-ai/k8s-agent/pkg/traffic"
+	"github.com/webb-ai/k8s-agent/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+var zoneLabels = []string{"topology.kubernetes.io/zone", "failure-domain.beta.kubernetes.io/zone"}
+
+// MetricsEnricher labels the traffic metrics sent to webb.ai with the kubernetes metadata of the pods whose IPs they
+// carry, then relabels them. Each pod IP label adds the pod, namespace, workload, workload_kind, service, node and
+// zone labels prefixed as configured, without overriding labels already set. Service IPs add the service and
+// namespace labels. Enriched metrics are observed, e.g. by the topology, before they're relabeled.
+type MetricsEnricher struct {
+	api.Client
+	informerFactory dynamicinformer.DynamicSharedInformerFactory
+	podIPLabels     map[string]string
+	relabeler       *traffic.Relabeler
+	observers       []traffic.Observer
+}
+
+func NewMetricsEnricher(
+	client api.Client,
+	informerFactory dynamicinformer.DynamicSharedInformerFactory,
+	podIPLabels map[string]string,
+	relabeler *traffic.Relabeler,
+	observers ...traffic.Observer,
+) *MetricsEnricher {
+	// pods and Services are looked up by IP in every write request, index them once
+	for gvr, indexIPs := range map[schema.GroupVersionResource]cache.IndexFunc{podGVR: indexPodIPs, serviceGVR: indexServiceIPs} {
+		if err := informerFactory.ForResource(gvr).Informer().AddIndexers(cache.Indexers{ipIndex: indexIPs}); err != nil {
+			klog.Errorf("unable to index %v by ip: %v", gvr, err)
+		}
+	}
+	return &MetricsEnricher{
+		Client:          client,
+		informerFactory: informerFactory,
+		podIPLabels:     podIPLabels,
+		relabeler:       relabeler,
+		observers:       observers,
+	}
+}
+
+// ParsePodIPLabels parses a comma separated list of label=prefix, e.g. instance=,src_ip=src_
+func ParsePodIPLabels(value string) (map[string]string, error) {
+	podIPLabels := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		label, prefix, found := strings.Cut(pair, "=")
+		if !found || strings.TrimSpace(label) == "" {
+			return nil, fmt.Errorf("invalid pod ip label %q, expecting label=prefix", pair)
+		}
+		podIPLabels[strings.TrimSpace(label)] = strings.TrimSpace(prefix)
+	}
+	return podIPLabels, nil
+}
+
+func (e *MetricsEnricher) SendTrafficMetrics(request *prompb.WriteRequest) error {
+	resolver := e.newPodResolver()
+	// the series of the caller are left as they are
+	enriched := &prompb.WriteRequest{Timeseries: make([]prompb.TimeSeries, len(request.Timeseries)), Metadata: request.Metadata}
+	for i, series := range request.Timeseries {
+		series.Labels = e.enrich(resolver, series.Labels)
+		enriched.Timeseries[i] = series
+	}
+	for _, observer := range e.observers {
+		observer.Observe(enriched)
+	}
+	processed := e.relabeler.Process(enriched)
+	if dropped := len(enriched.Timeseries) - len(processed.Timeseries); dropped > 0 {
+		klog.V(4).Infof("dropped %d of %d traffic series", dropped, len(enriched.Timeseries))
+	}
+	if len(processed.Timeseries) == 0 {
+		return nil
+	}
+	return e.Client.SendTrafficMetrics(processed)
+}
+
+func (e *MetricsEnricher) enrich(resolver *podResolver, seriesLabels []prompb.Label) []prompb.Label {
+	present := make(map[string]struct{}, len(seriesLabels))
+	for _, label := range seriesLabels {
+		present[label.Name] = struct{}{}
+	}
+	enriched := append([]prompb.Label(nil), seriesLabels...)
+	for _, label := range seriesLabels {
+		prefix, ok := e.podIPLabels[label.Name]
+		if !ok {
+			continue
+		}
+		for _, metadata := range resolver.resolve(label.Value) {
+			name := prefix + metadata.Name
+			if _, found := present[name]; found || metadata.Value == "" {
+				continue
+			}
+			present[name] = struct{}{}
+			enriched = append(enriched, prompb.Label{Name: name, Value: metadata.Value})
+		}
+	}
+	// labels are sorted again by the relabeler
+	return enriched
+}
+
+// ipIndex indexes running pods and Services by IP in the informer caches
+const ipIndex = "ip"
+
+// indexPodIPs indexes the IPs of running pods, pods on the host network share the IP of their node
+func indexPodIPs(obj interface{}) ([]string, error) {
+	object, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, nil
+	}
+	hostNetwork, _, _ := unstructured.NestedBool(object.Object, "spec", "hostNetwork")
+	phase, _, _ := unstructured.NestedString(object.Object, "status", "phase")
+	if hostNetwork || phase != string(corev1.PodRunning) {
+		return nil, nil
+	}
+	ips := sets.New[string]()
+	if podIP, _, _ := unstructured.NestedString(object.Object, "status", "podIP"); podIP != "" {
+		ips.Insert(podIP)
+	}
+	podIPs, _, _ := unstructured.NestedSlice(object.Object, "status", "podIPs")
+	for _, podIP := range podIPs {
+		if podIP, ok := podIP.(map[string]interface{}); ok {
+			if ip, _ := podIP["ip"].(string); ip != "" {
+				ips.Insert(ip)
+			}
+		}
+	}
+	return sets.List(ips), nil
+}
+
+func indexServiceIPs(obj interface{}) ([]string, error) {
+	object, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, nil
+	}
+	clusterIPs, _, _ := unstructured.NestedStringSlice(object.Object, "spec", "clusterIPs")
+	var ips []string
+	for _, clusterIP := range clusterIPs {
+		if clusterIP != corev1.ClusterIPNone {
+			ips = append(ips, clusterIP)
+		}
+	}
+	return ips, nil
+}
+
+// podResolver resolves pod and Service IPs to the metadata of objects in the informer caches, for one write request
+type podResolver struct {
+	informerFactory dynamicinformer.DynamicSharedInformerFactory
+	// selectors are the Services selecting pods by namespace, sorted by name
+	selectors map[string][]*corev1.Service
+	resolved  map[string][]prompb.Label
+}
+
+func (e *MetricsEnricher) newPodResolver() *podResolver {
+	return &podResolver{
+		informerFactory: e.informerFactory,
+		selectors:       make(map[string][]*corev1.Service),
+		resolved:        make(map[string][]prompb.Label),
+	}
+}
+
+// resolve returns the metadata of the pod or Service with an IP, which may be followed by a port
+func (r *podResolver) resolve(address string) []prompb.Label {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	if metadata, found := r.resolved[address]; found {
+		return metadata
+	}
+	var metadata []prompb.Label
+	if pod := r.pod(address); pod != nil {
+		workload, workloadKind := r.workload(pod)
+		metadata = []prompb.Label{
+			{Name: "pod", Value: pod.Name},
+			{Name: "namespace", Value: pod.Namespace},
+			{Name: "workload", Value: workload},
+			{Name: "workload_kind", Value: workloadKind},
+			{Name: "service", Value: r.service(pod)},
+			{Name: "node", Value: pod.Spec.NodeName},
+			{Name: "zone", Value: r.zone(pod.Spec.NodeName)},
+		}
+	} else if service := r.serviceByIP(address); service != nil {
+		metadata = []prompb.Label{
+			{Name: "service", Value: service.Name},
+			{Name: "namespace", Value: service.Namespace},
+		}
+	}
+	r.resolved[address] = metadata
+	return metadata
+}
+
+// byIP returns the first object of a resource with an IP, by name
+func (r *podResolver) byIP(gvr schema.GroupVersionResource, ip string) *unstructured.Unstructured {
+	objects, err := r.informerFactory.ForResource(gvr).Informer().GetIndexer().ByIndex(ipIndex, ip)
+	if err != nil {
+		klog.Error(err)
+		return nil
+	}
+	var first *unstructured.Unstructured
+	for _, object := range objects {
+		if object, ok := object.(*unstructured.Unstructured); ok && (first == nil || object.GetName() < first.GetName()) {
+			first = object
+		}
+	}
+	return first
+}
+
+func (r *podResolver) pod(ip string) *corev1.Pod {
+	object := r.byIP(podGVR, ip)
+	if object == nil {
+		return nil
+	}
+	pod, err := util.UnstructuredToPod(object)
+	if err != nil {
+		return nil
+	}
+	return pod
+}
+
+func (r *podResolver) serviceByIP(ip string) *corev1.Service {
+	object := r.byIP(serviceGVR, ip)
+	if object == nil {
+		return nil
+	}
+	var service corev1.Service
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object.Object, &service); err != nil {
+		return nil
+	}
+	return &service
+}
+
+// workload returns the top level controller of a pod, e.g. the Deployment of its ReplicaSet, or the pod itself
+func (r *podResolver) workload(pod *corev1.Pod) (string, string) {
+	name, kind := pod.Name, "Pod"
+	var object *unstructured.Unstructured
+	for _, owner := range pod.OwnerReferences {
+		if owner.Controller != nil && *owner.Controller {
+			// owners out of the caches are still the best known workload
+			name, kind = owner.Name, owner.Kind
+			object = getObject(r.informerFactory, owner.Kind, pod.Namespace, owner.Name)
+		}
+	}
+	for depth := 1; object != nil && depth < maxOwnerDepth; depth++ {
+		if object = getOwner(r.informerFactory, object); object != nil {
+			name, kind = object.GetName(), object.GetKind()
+		}
+	}
+	return name, kind
+}
+
+// namespaceSelectors returns the Services selecting pods in a namespace, listed once per write request
+func (r *podResolver) namespaceSelectors(namespace string) []*corev1.Service {
+	if services, found := r.selectors[namespace]; found {
+		return services
+	}
+	var services []*corev1.Service
+	objects, err := r.informerFactory.ForResource(serviceGVR).Lister().ByNamespace(namespace).List(labels.Everything())
+	if err != nil {
+		klog.Error(err)
+	}
+	for _, object := range objects {
+		var service corev1.Service
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object.(*unstructured.Unstructured).Object, &service); err != nil {
+			continue
+		}
+		if len(service.Spec.Selector) > 0 {
+			services = append(services, &service)
+		}
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Name < services[j].Name
+	})
+	r.selectors[namespace] = services
+	return services
+}
+
+// service returns the first Service selecting a pod by name, pods part of several Services are rare
+func (r *podResolver) service(pod *corev1.Pod) string {
+	for _, service := range r.namespaceSelectors(pod.Namespace) {
+		if labels.SelectorFromSet(service.Spec.Selector).Matches(labels.Set(pod.Labels)) {
+			return service.Name
+		}
+	}
+	return ""
+}
+func (r *podResolver) zone(nodeName string) string {
+	if nodeName == "" {
+		return ""
+	}
+	node := getObject(r.informerFactory, "Node", "", nodeName)
+	if node == nil {
+		return ""
+	}
+	for _, label := range zoneLabels {
+		if zone := node.GetLabels()[label]; zone != "" {
+			return zone
+		}
+	}
+	return ""
+}
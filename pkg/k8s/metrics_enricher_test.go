@@ -0,0 +1,103 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/webb-ai/k8s-agent/pkg/api"
+	"github.com/webb-ai/k8s-agent/pkg/traffic"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+type trafficMetricsClient struct {
+	api.NoOpClient
+	requests []*prompb.WriteRequest
+}
+
+func (c *trafficMetricsClient) SendTrafficMetrics(request *prompb.WriteRequest) error {
+	c.requests = append(c.requests, request)
+	return nil
+}
+
+func TestMetricsEnricher(t *testing.T) {
+	node := newObject("v1", "Node", "", "node-a", "node-uid", nil)
+	node.SetLabels(map[string]string{"topology.kubernetes.io/zone": "us-east-1a"})
+	deployment := newObject("apps/v1", "Deployment", "shop", "checkout", "deployment-uid", nil)
+	replicaSet := newObject("apps/v1", "ReplicaSet", "shop", "checkout-7d9f", "replicaset-uid", deployment)
+	pod := newObject("v1", "Pod", "shop", "checkout-7d9f-abcde", "pod-uid", replicaSet)
+	_ = unstructured.SetNestedField(pod.Object, "node-a", "spec", "nodeName")
+	pod.Object["status"] = map[string]interface{}{"phase": "Running", "podIP": "10.0.0.2"}
+	pod.SetLabels(map[string]string{"app": "checkout"})
+	service := newObject("v1", "Service", "shop", "checkout", "service-uid", nil)
+	service.Object["spec"] = map[string]interface{}{
+		"selector":   map[string]interface{}{"app": "checkout"},
+		"clusterIPs": []interface{}{"10.96.0.1"},
+	}
+
+	gvrs := []schema.GroupVersionResource{nodeGVR, deploymentGVR, replicasetGVR, podGVR, serviceGVR}
+	listKinds := map[schema.GroupVersionResource]string{}
+	for _, gvr := range gvrs {
+		listKinds[gvr] = "List"
+	}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds,
+		node, deployment, replicaSet, pod, service)
+	informerFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, time.Minute)
+	for _, gvr := range gvrs {
+		informerFactory.ForResource(gvr)
+	}
+	relabeler, err := traffic.NewRelabeler(traffic.RelabelConfig{DropLabels: []string{"src_ip"}})
+	assert.NoError(t, err)
+	client := &trafficMetricsClient{}
+	// the enricher indexes the informers before they start
+	enricher := NewMetricsEnricher(client, informerFactory, map[string]string{"instance": "", "src_ip": "src_"}, relabeler)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	informerFactory.Start(ctx.Done())
+	informerFactory.WaitForCacheSync(ctx.Done())
+
+	seriesLabels := make([]prompb.Label, 0, 16)
+	seriesLabels = append(seriesLabels,
+		prompb.Label{Name: "__name__", Value: "requests_total"},
+		prompb.Label{Name: "instance", Value: "10.0.0.1:9090"},
+		prompb.Label{Name: "src_ip", Value: "10.0.0.2"},
+	)
+	request := &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{{Labels: seriesLabels}, {Labels: []prompb.Label{
+		{Name: "__name__", Value: "requests_total"},
+		{Name: "instance", Value: "10.96.0.1:8080"},
+	}}}}
+	assert.NoError(t, enricher.SendTrafficMetrics(request))
+	// the labels of the caller are left as they are, even with room to append to them
+	assert.Len(t, request.Timeseries[0].Labels, 3)
+	assert.Equal(t, "src_ip", request.Timeseries[0].Labels[2].Name)
+	assert.Empty(t, seriesLabels[:4][3].Name)
+
+	// the instance isn't a known pod
+	assert.Equal(t, []prompb.Label{
+		{Name: "__name__", Value: "requests_total"},
+		{Name: "instance", Value: "10.0.0.1:9090"},
+		{Name: "src_namespace", Value: "shop"},
+		{Name: "src_node", Value: "node-a"},
+		{Name: "src_pod", Value: "checkout-7d9f-abcde"},
+		{Name: "src_service", Value: "checkout"},
+		{Name: "src_workload", Value: "checkout"},
+		{Name: "src_workload_kind", Value: "Deployment"},
+		{Name: "src_zone", Value: "us-east-1a"},
+	}, client.requests[0].Timeseries[0].Labels)
+	assert.Equal(t, []prompb.Label{
+		{Name: "__name__", Value: "requests_total"},
+		{Name: "instance", Value: "10.96.0.1:8080"},
+		{Name: "namespace", Value: "shop"},
+		{Name: "service", Value: "checkout"},
+	}, client.requests[0].Timeseries[1].Labels)
+
+	podIPLabels, err := ParsePodIPLabels("instance=, src_ip=src_")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"instance": "", "src_ip": "src_"}, podIPLabels)
+}
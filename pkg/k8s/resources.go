@@ -67,6 +67,24 @@ var WatchedGVRs = []schema.GroupVersionResource{
 	vpaGVR,
 }
 
+// IntentGVRs are the workload and configuration resources whose admission requests are recorded as change intents,
+// busy resources like pods, endpoints and nodes are left out
+var IntentGVRs = []schema.GroupVersionResource{
+	configMapGVR,
+	cronjobGVR,
+	daemonsetGVR,
+	deploymentGVR,
+	hpaGVR,
+	ingressGVR,
+	jobGVR,
+	kedaScaledObjectGVR,
+	networkpolicyGVR,
+	secretGVR,
+	serviceGVR,
+	statefulsetGVR,
+	vpaGVR,
+}
+
 var BackupGVRs = []schema.GroupVersionResource{
 	cronjobGVR,
 	daemonsetGVR,
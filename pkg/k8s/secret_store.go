@@ -0,0 +1,53 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// LoadOrCreateSecret returns the data of the named secret, creating it with generated data if it doesn't exist yet.
+// It's used to persist agent generated key material in the cluster so that it survives restarts.
+func LoadOrCreateSecret(
+	ctx context.Context,
+	secrets corev1client.SecretInterface,
+	name string,
+	generate func() (map[string][]byte, error),
+) (map[string][]byte, error) {
+	secret, err := secrets.Get(ctx, name, metav1.GetOptions{})
+	if err == nil {
+		return secret.Data, nil
+	}
+	if !errors.IsNotFound(err) {
+		return nil, fmt.Errorf("error getting secret %s: %w", name, err)
+	}
+
+	data, err := generate()
+	if err != nil {
+		return nil, err
+	}
+
+	klog.Infof("creating secret %s", name)
+	secret, err = secrets.Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{"app.kubernetes.io/managed-by": "webbai-agent"},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: data,
+	}, metav1.CreateOptions{})
+
+	if errors.IsAlreadyExists(err) {
+		// another replica created it first, use theirs
+		secret, err = secrets.Get(ctx, name, metav1.GetOptions{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating secret %s: %w", name, err)
+	}
+	return secret.Data, nil
+}
@@ -3,8 +3,10 @@ package k8s
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
+	"github.com/prometheus/prometheus/prompb"
 	"github.com/rs/zerolog"
 	"github.com/webb-ai/k8s-agent/pkg/api"
 	"github.com/webb-ai/k8s-agent/pkg/traffic"
@@ -12,7 +14,9 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog/v2"
 )
 
@@ -22,16 +26,25 @@ type TrafficCollector struct {
 	podSelector     labels.Selector
 	serverPort      int
 	metricsPort     int
+	scraper         *traffic.Scraper
 	logger          zerolog.Logger
 	client          api.Client
+	// podTargets are the targets of every traffic collector pod by uid
+	podTargets map[types.UID]*traffic.PodTargets
 }
 
+// podTargetsRetryMax bounds the retries of a target update, collectors failing them are sent their changes again
+// on the next interval
+const podTargetsRetryMax = 3
+
 func NewTrafficCollector(
 	informerFactory dynamicinformer.DynamicSharedInformerFactory,
 	interval time.Duration,
 	podSelector labels.Selector,
 	serverPort,
 	metricsPort int,
+	scrapeTimeout time.Duration,
+	scrapeConcurrency int,
 	logger zerolog.Logger,
 	client api.Client,
 ) *TrafficCollector {
@@ -41,8 +54,10 @@ func NewTrafficCollector(
 		podSelector:     podSelector,
 		serverPort:      serverPort,
 		metricsPort:     metricsPort,
+		scraper:         traffic.NewScraper(scrapeTimeout, scrapeConcurrency),
 		logger:          logger,
 		client:          client,
+		podTargets:      make(map[types.UID]*traffic.PodTargets),
 	}
 }
 
@@ -52,20 +67,25 @@ func (c *TrafficCollector) Start(ctx context.Context) error {
 		return nil
 	}
 
+	// the pod informer is started by the change collector
+	if !cache.WaitForCacheSync(ctx.Done(), c.informerFactory.ForResource(podGVR).Informer().HasSynced) {
+		return nil
+	}
+
 	klog.Infof("starting to collect traffic metrics every %v for pods with labels %v", c.interval, c.podSelector)
 	c.setTargetPods()
 	for {
 		select {
 		case <-time.After(c.interval):
 			c.setTargetPods()
-			c.collectMetrics()
+			c.collectMetrics(ctx)
 		case <-ctx.Done():
 			return nil
 		}
 	}
 }
 
-func (c *TrafficCollector) collectMetrics() {
+func (c *TrafficCollector) collectMetrics(ctx context.Context) {
 	pods, err := c.informerFactory.ForResource(podGVR).Lister().List(c.podSelector)
 
 	if err != nil {
@@ -74,10 +94,11 @@ func (c *TrafficCollector) collectMetrics() {
 	}
 
 	if len(pods) == 0 {
-		klog.Error("no traffic collector found, skipping ...")
-		return
+		// still scrape no target, to mark the series of the collectors gone stale
+		klog.Warning("no traffic collector found")
 	}
 
+	targets := make([]traffic.Target, 0, len(pods))
 	for _, podRuntimeObject := range pods {
 		pod, err := util.UnstructuredToPod(podRuntimeObject.(*unstructured.Unstructured))
 		if err != nil {
@@ -89,50 +110,77 @@ func (c *TrafficCollector) collectMetrics() {
 			klog.Warningf("pod %s has no podIP, skipping ...", pod.Name)
 			continue
 		}
-		metricsUrl := fmt.Sprintf("http://%s:%d/webbai_metrics", podIp, c.metricsPort)
-		klog.Infof("scraping %s for prometheus metrics", metricsUrl)
-		metricText, metricFamilies, err := traffic.ScrapeTarget(metricsUrl)
-		if err != nil {
-			klog.Error(err)
-		}
+		targets = append(targets, traffic.Target{
+			URL:    fmt.Sprintf("http://%s:%d/webbai_metrics", podIp, c.metricsPort),
+			Labels: []prompb.Label{{Name: "instance", Value: fmt.Sprintf("%s:%d", podIp, c.metricsPort)}},
+		})
+	}
 
-		c.logger.Info().Any("payload", metricText).Msg("metrics")
-		writeRequest := traffic.MetricFamiliesToProtoWriteRequest(metricFamilies)
-		err = c.client.SendTrafficMetrics(writeRequest)
-		if err != nil {
-			klog.Error(err)
+	results, writeRequest := c.scraper.Scrape(ctx, targets)
+	for _, result := range results {
+		if result.Err != nil {
+			klog.Errorf("error scraping %s in %v: %v", result.Target.URL, result.Duration, result.Err)
+			continue
 		}
+		klog.V(4).Infof("scraped %s in %v", result.Target.URL, result.Duration)
+	}
+	if len(writeRequest.Timeseries) == 0 {
+		return
+	}
+	// the series sent rather than the scraped bodies, which may be protobuf
+	c.logger.Info().Any("payload", writeRequest.Timeseries).Msg("metrics")
+	if err := c.client.SendTrafficMetrics(writeRequest); err != nil {
+		klog.Error(err)
 	}
 }
 
+// setTargetPods sends every traffic collector pod the running pods of its node, the only ones it can observe.
+// Collectors are updated concurrently, so that a failing one doesn't delay the others.
 func (c *TrafficCollector) setTargetPods() {
-	var allRunningPods []*corev1.Pod
-	var trafficCollectorPods []*corev1.Pod
 	pods, err := c.informerFactory.ForResource(podGVR).Lister().List(labels.Everything())
 	if err != nil {
 		klog.Error(err)
 		return
 	}
 
+	podsByNode := make(map[string][]*corev1.Pod)
+	var trafficCollectorPods []*corev1.Pod
 	for _, podRuntimeObject := range pods {
-		pod, _ := util.UnstructuredToPod(podRuntimeObject.(*unstructured.Unstructured))
-		if pod.Status.Phase == corev1.PodRunning && !c.podSelector.Matches(labels.Set(pod.Labels)) {
-			klog.Infof("targeting pod %s from namespace %s", pod.Name, pod.Namespace)
-			pod.ManagedFields = nil
-			allRunningPods = append(allRunningPods, pod)
+		pod, err := util.UnstructuredToPod(podRuntimeObject.(*unstructured.Unstructured))
+		if err != nil || pod.Status.Phase != corev1.PodRunning {
+			continue
 		}
-		if pod.Status.Phase == corev1.PodRunning && c.podSelector.Matches(labels.Set(pod.Labels)) {
+		if c.podSelector.Matches(labels.Set(pod.Labels)) {
 			trafficCollectorPods = append(trafficCollectorPods, pod)
+		} else {
+			pod.ManagedFields = nil
+			podsByNode[pod.Spec.NodeName] = append(podsByNode[pod.Spec.NodeName], pod)
 		}
 	}
 
-	for _, pod := range trafficCollectorPods {
-		podIp := pod.Status.PodIP
-		podTargetsUrl := fmt.Sprintf("http://%s:%d/pods/set-targeted", podIp, c.serverPort)
-		klog.Infof("setting pod targets to %s", podTargetsUrl)
-		err = traffic.SetPodTargets(allRunningPods, podTargetsUrl)
-		if err != nil {
-			klog.Error(err)
+	var wg sync.WaitGroup
+	collectors := make(map[types.UID]*traffic.PodTargets, len(trafficCollectorPods))
+	for _, collectorPod := range trafficCollectorPods {
+		if collectorPod.Status.PodIP == "" {
+			continue
+		}
+		// collectors restarting get a new uid, and start from a full update
+		podTargets, found := c.podTargets[collectorPod.UID]
+		if !found {
+			podTargetsUrl := fmt.Sprintf("http://%s:%d", collectorPod.Status.PodIP, c.serverPort)
+			klog.Infof("updating pod targets of node %s at %s", collectorPod.Spec.NodeName, podTargetsUrl)
+			podTargets = traffic.NewPodTargets(podTargetsUrl, podTargetsRetryMax)
 		}
+		collectors[collectorPod.UID] = podTargets
+
+		wg.Add(1)
+		go func(podTargets *traffic.PodTargets, nodePods []*corev1.Pod) {
+			defer wg.Done()
+			if err := podTargets.Update(nodePods); err != nil {
+				klog.Error(err)
+			}
+		}(podTargets, podsByNode[collectorPod.Spec.NodeName])
 	}
+	wg.Wait()
+	c.podTargets = collectors
 }
@@ -0,0 +1,409 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/rs/zerolog"
+	"github.com/webb-ai/k8s-agent/pkg/api"
+	"github.com/webb-ai/k8s-agent/pkg/traffic"
+	"github.com/webb-ai/k8s-agent/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+var serviceMonitorGVR = schema.GroupVersionResource{Group: "monitoring.coreos.com", Version: "v1", Resource: "servicemonitors"}
+var podMonitorGVR = schema.GroupVersionResource{Group: "monitoring.coreos.com", Version: "v1", Resource: "podmonitors"}
+
+const (
+	scrapeAnnotation = "prometheus.io/scrape"
+	portAnnotation   = "prometheus.io/port"
+	pathAnnotation   = "prometheus.io/path"
+	schemeAnnotation = "prometheus.io/scheme"
+
+	annotatedPodsJob = "kubernetes-pods"
+)
+
+// monitorSpec is the part of the spec of ServiceMonitors and PodMonitors used to discover targets
+type monitorSpec struct {
+	Selector          metav1.LabelSelector `json:"selector"`
+	NamespaceSelector struct {
+		Any        bool     `json:"any"`
+		MatchNames []string `json:"matchNames"`
+	} `json:"namespaceSelector"`
+	// Endpoints are the endpoints of a ServiceMonitor
+	Endpoints []monitorEndpoint `json:"endpoints"`
+	// PodMetricsEndpoints are the endpoints of a PodMonitor
+	PodMetricsEndpoints []monitorEndpoint `json:"podMetricsEndpoints"`
+}
+
+type monitorEndpoint struct {
+	Port       string              `json:"port"`
+	TargetPort *intstr.IntOrString `json:"targetPort"`
+	Path       string              `json:"path"`
+	Scheme     string              `json:"scheme"`
+}
+
+// WorkloadScraper scrapes the prometheus metrics of workloads, for clusters without a prometheus. Targets are pods
+// annotated with prometheus.io/scrape, and the targets of ServiceMonitors and PodMonitors if their CRDs exist.
+// Only series with names matching metricNames are sent.
+type WorkloadScraper struct {
+	informerFactory dynamicinformer.DynamicSharedInformerFactory
+	discoveryClient discovery.ServerResourcesInterface
+	interval        time.Duration
+	metricNames     *regexp.Regexp
+	scraper         *traffic.Scraper
+	logger          zerolog.Logger
+	client          api.Client
+	monitorGVRs     map[schema.GroupVersionResource]struct{}
+}
+
+func NewWorkloadScraper(
+	informerFactory dynamicinformer.DynamicSharedInformerFactory,
+	discoveryClient discovery.ServerResourcesInterface,
+	interval time.Duration,
+	scrapeTimeout time.Duration,
+	scrapeConcurrency int,
+	metricNames *regexp.Regexp,
+	logger zerolog.Logger,
+	client api.Client,
+) *WorkloadScraper {
+	return &WorkloadScraper{
+		informerFactory: informerFactory,
+		discoveryClient: discoveryClient,
+		interval:        interval,
+		metricNames:     metricNames,
+		scraper:         traffic.NewScraper(scrapeTimeout, scrapeConcurrency),
+		logger:          logger,
+		client:          client,
+		monitorGVRs:     make(map[schema.GroupVersionResource]struct{}),
+	}
+}
+
+func (s *WorkloadScraper) Start(ctx context.Context) error {
+	allResources, err := GetAllResources(s.discoveryClient)
+	if err != nil {
+		return err
+	}
+	gvrs := []schema.GroupVersionResource{podGVR}
+	for _, gvr := range []schema.GroupVersionResource{podMonitorGVR, serviceMonitorGVR} {
+		if _, ok := allResources[gvr]; ok {
+			klog.Infof("discovering scrape targets from %v", gvr)
+			s.monitorGVRs[gvr] = struct{}{}
+			gvrs = append(gvrs, gvr)
+		}
+	}
+	if _, ok := s.monitorGVRs[serviceMonitorGVR]; ok {
+		gvrs = append(gvrs, serviceGVR, endpointsGVR)
+	}
+
+	var hasSynced []cache.InformerSynced
+	for _, gvr := range gvrs {
+		hasSynced = append(hasSynced, s.informerFactory.ForResource(gvr).Informer().HasSynced)
+	}
+	// starts the informers of monitors, the others are started by the change collector
+	s.informerFactory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), hasSynced...) {
+		return nil
+	}
+
+	klog.Infof("starting to scrape workload metrics every %v", s.interval)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.scrape(ctx)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (s *WorkloadScraper) scrape(ctx context.Context) {
+	targets := s.discoverTargets()
+	results, writeRequest := s.scraper.Scrape(ctx, targets)
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			// workloads failing to be scraped are common, e.g. a stale annotation, and show up in the up series
+			failed++
+			klog.V(4).Infof("error scraping %s in %v: %v", result.Target.URL, result.Duration, result.Err)
+		}
+	}
+	writeRequest = traffic.FilterByName(writeRequest, s.metricNames)
+	klog.Infof("scraped %d workload targets, %d failed, sending %d series", len(targets), failed, len(writeRequest.Timeseries))
+	if len(writeRequest.Timeseries) == 0 {
+		return
+	}
+	// only the series sent, whole expositions can be large
+	s.logger.Info().Any("payload", writeRequest.Timeseries).Msg("metrics")
+	if err := s.client.SendTrafficMetrics(writeRequest); err != nil {
+		klog.Error(err)
+	}
+}
+
+// discoverTargets returns the targets of annotated pods, PodMonitors and ServiceMonitors, in that order of precedence
+// when they share a url
+func (s *WorkloadScraper) discoverTargets() []traffic.Target {
+	var pods []*corev1.Pod
+	objects, err := s.informerFactory.ForResource(podGVR).Lister().List(labels.Everything())
+	if err != nil {
+		klog.Error(err)
+	}
+	for _, object := range objects {
+		pod, err := util.UnstructuredToPod(object.(*unstructured.Unstructured))
+		if err != nil {
+			klog.Error(err)
+			continue
+		}
+		if pod.Status.Phase == corev1.PodRunning && pod.Status.PodIP != "" {
+			pods = append(pods, pod)
+		}
+	}
+
+	var targets []traffic.Target
+	for _, pod := range pods {
+		if target, ok := annotatedPodTarget(pod); ok {
+			targets = append(targets, target)
+		}
+	}
+	if _, ok := s.monitorGVRs[podMonitorGVR]; ok {
+		for _, monitor := range s.listMonitors(podMonitorGVR) {
+			targets = append(targets, podMonitorTargets(monitor, pods)...)
+		}
+	}
+	if _, ok := s.monitorGVRs[serviceMonitorGVR]; ok {
+		for _, monitor := range s.listMonitors(serviceMonitorGVR) {
+			targets = append(targets, s.serviceMonitorTargets(monitor)...)
+		}
+	}
+
+	seen := make(map[string]struct{}, len(targets))
+	unique := targets[:0]
+	for _, target := range targets {
+		if _, found := seen[target.URL]; !found {
+			seen[target.URL] = struct{}{}
+			unique = append(unique, target)
+		}
+	}
+	sort.Slice(unique, func(i, j int) bool {
+		return unique[i].URL < unique[j].URL
+	})
+	return unique
+}
+
+func (s *WorkloadScraper) listMonitors(gvr schema.GroupVersionResource) []*unstructured.Unstructured {
+	objects, err := s.informerFactory.ForResource(gvr).Lister().List(labels.Everything())
+	if err != nil {
+		klog.Error(err)
+		return nil
+	}
+	monitors := make([]*unstructured.Unstructured, 0, len(objects))
+	for _, object := range objects {
+		monitors = append(monitors, object.(*unstructured.Unstructured))
+	}
+	return monitors
+}
+
+// annotatedPodTarget returns the target of a pod annotated with prometheus.io/scrape: "true". The port is the
+// prometheus.io/port annotation, or the first declared tcp container port.
+func annotatedPodTarget(pod *corev1.Pod) (traffic.Target, bool) {
+	if pod.Annotations[scrapeAnnotation] != "true" {
+		return traffic.Target{}, false
+	}
+	port, err := strconv.Atoi(pod.Annotations[portAnnotation])
+	if err != nil {
+		port = firstContainerPort(pod)
+	}
+	if port <= 0 {
+		return traffic.Target{}, false
+	}
+	return newPodTarget(pod, port, pod.Annotations[schemeAnnotation], pod.Annotations[pathAnnotation], annotatedPodsJob), true
+}
+
+func firstContainerPort(pod *corev1.Pod) int {
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.Protocol == "" || port.Protocol == corev1.ProtocolTCP {
+				return int(port.ContainerPort)
+			}
+		}
+	}
+	return 0
+}
+
+func newPodTarget(pod *corev1.Pod, port int, scheme, path, job string) traffic.Target {
+	target := newTarget(pod.Status.PodIP, port, scheme, path, job, pod.Namespace)
+	target.Labels = append(target.Labels, prompb.Label{Name: "pod", Value: pod.Name})
+	return target
+}
+
+func newTarget(ip string, port int, scheme, path, job, namespace string) traffic.Target {
+	if scheme == "" {
+		scheme = "http"
+	}
+	if path == "" {
+		path = "/metrics"
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	instance := net.JoinHostPort(ip, strconv.Itoa(port))
+	return traffic.Target{
+		URL: fmt.Sprintf("%s://%s%s", scheme, instance, path),
+		Labels: []prompb.Label{
+			{Name: "instance", Value: instance},
+			{Name: "job", Value: job},
+			{Name: "namespace", Value: namespace},
+		},
+	}
+}
+
+func decodeMonitorSpec(monitor *unstructured.Unstructured) (*monitorSpec, labels.Selector, error) {
+	spec := &monitorSpec{}
+	content, _, _ := unstructured.NestedMap(monitor.Object, "spec")
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, spec); err != nil {
+		return nil, nil, fmt.Errorf("error decoding %s %s/%s: %w", monitor.GetKind(), monitor.GetNamespace(), monitor.GetName(), err)
+	}
+	selector, err := metav1.LabelSelectorAsSelector(&spec.Selector)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing selector of %s %s/%s: %w", monitor.GetKind(), monitor.GetNamespace(), monitor.GetName(), err)
+	}
+	return spec, selector, nil
+}
+
+// selectsNamespace tells whether a monitor selects a namespace, monitors select their own namespace by default
+func (m *monitorSpec) selectsNamespace(monitorNamespace, namespace string) bool {
+	if m.NamespaceSelector.Any {
+		return true
+	}
+	if len(m.NamespaceSelector.MatchNames) == 0 {
+		return namespace == monitorNamespace
+	}
+	for _, name := range m.NamespaceSelector.MatchNames {
+		if name == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// podMonitorTargets returns a target per endpoint of a PodMonitor and selected pod, endpoint ports are container
+// port names
+func podMonitorTargets(monitor *unstructured.Unstructured, pods []*corev1.Pod) []traffic.Target {
+	spec, selector, err := decodeMonitorSpec(monitor)
+	if err != nil {
+		klog.Warning(err)
+		return nil
+	}
+	job := fmt.Sprintf("podMonitor/%s/%s", monitor.GetNamespace(), monitor.GetName())
+	var targets []traffic.Target
+	for _, pod := range pods {
+		if !spec.selectsNamespace(monitor.GetNamespace(), pod.Namespace) || !selector.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+		for _, endpoint := range spec.PodMetricsEndpoints {
+			if port := containerPort(pod, endpoint); port > 0 {
+				targets = append(targets, newPodTarget(pod, port, endpoint.Scheme, endpoint.Path, job))
+			}
+		}
+	}
+	return targets
+}
+
+func containerPort(pod *corev1.Pod, endpoint monitorEndpoint) int {
+	name := endpoint.Port
+	if name == "" && endpoint.TargetPort != nil {
+		if endpoint.TargetPort.Type == intstr.Int {
+			return endpoint.TargetPort.IntValue()
+		}
+		name = endpoint.TargetPort.StrVal
+	}
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if name != "" && port.Name == name {
+				return int(port.ContainerPort)
+			}
+		}
+	}
+	return 0
+}
+
+// serviceMonitorTargets returns a target per endpoint of a ServiceMonitor and ready address of the endpoints of
+// the selected services. Endpoint ports are service port names, or target port numbers.
+func (s *WorkloadScraper) serviceMonitorTargets(monitor *unstructured.Unstructured) []traffic.Target {
+	spec, selector, err := decodeMonitorSpec(monitor)
+	if err != nil {
+		klog.Warning(err)
+		return nil
+	}
+	services, err := s.informerFactory.ForResource(serviceGVR).Lister().List(selector)
+	if err != nil {
+		klog.Error(err)
+		return nil
+	}
+
+	var targets []traffic.Target
+	for _, object := range services {
+		service := object.(*unstructured.Unstructured)
+		if !spec.selectsNamespace(monitor.GetNamespace(), service.GetNamespace()) {
+			continue
+		}
+		object, err := s.informerFactory.ForResource(endpointsGVR).Lister().ByNamespace(service.GetNamespace()).Get(service.GetName())
+		if err != nil {
+			continue
+		}
+		var endpoints corev1.Endpoints
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object.(*unstructured.Unstructured).Object, &endpoints); err != nil {
+			klog.Error(err)
+			continue
+		}
+		for _, subset := range endpoints.Subsets {
+			for _, endpoint := range spec.Endpoints {
+				port := endpointsPort(subset, endpoint)
+				if port <= 0 {
+					continue
+				}
+				for _, address := range subset.Addresses {
+					target := newTarget(address.IP, port, endpoint.Scheme, endpoint.Path, service.GetName(), service.GetNamespace())
+					target.Labels = append(target.Labels, prompb.Label{Name: "service", Value: service.GetName()})
+					if address.TargetRef != nil && address.TargetRef.Kind == "Pod" {
+						target.Labels = append(target.Labels, prompb.Label{Name: "pod", Value: address.TargetRef.Name})
+					}
+					targets = append(targets, target)
+				}
+			}
+		}
+	}
+	return targets
+}
+
+func endpointsPort(subset corev1.EndpointSubset, endpoint monitorEndpoint) int {
+	for _, port := range subset.Ports {
+		if endpoint.Port != "" && port.Name == endpoint.Port {
+			return int(port.Port)
+		}
+		if endpoint.Port == "" && endpoint.TargetPort != nil && endpoint.TargetPort.Type == intstr.Int &&
+			int(port.Port) == endpoint.TargetPort.IntValue() {
+			return int(port.Port)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,102 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func newRunningPod(namespace, name, ip string, podLabels map[string]interface{}, annotations map[string]interface{}) *unstructured.Unstructured {
+	pod := newObject("v1", "Pod", namespace, name, name+"-uid", nil)
+	_ = unstructured.SetNestedMap(pod.Object, podLabels, "metadata", "labels")
+	_ = unstructured.SetNestedMap(pod.Object, annotations, "metadata", "annotations")
+	_ = unstructured.SetNestedSlice(pod.Object, []interface{}{map[string]interface{}{
+		"name":  "app",
+		"ports": []interface{}{map[string]interface{}{"name": "http-metrics", "containerPort": int64(9102)}},
+	}}, "spec", "containers")
+	pod.Object["status"] = map[string]interface{}{"phase": "Running", "podIP": ip}
+	return pod
+}
+
+func TestDiscoverTargets(t *testing.T) {
+	annotated := newRunningPod("shop", "cart", "10.0.0.1", nil, map[string]interface{}{
+		scrapeAnnotation: "true",
+		pathAnnotation:   "/stats",
+	})
+	monitored := newRunningPod("shop", "checkout", "10.0.0.2", map[string]interface{}{"app": "checkout"}, nil)
+	other := newRunningPod("payments", "ledger", "10.0.0.3", map[string]interface{}{"app": "checkout"}, nil)
+
+	podMonitor := newObject("monitoring.coreos.com/v1", "PodMonitor", "shop", "checkout", "pm-uid", nil)
+	podMonitor.Object["spec"] = map[string]interface{}{
+		"selector":            map[string]interface{}{"matchLabels": map[string]interface{}{"app": "checkout"}},
+		"podMetricsEndpoints": []interface{}{map[string]interface{}{"port": "http-metrics"}},
+	}
+	service := newObject("v1", "Service", "payments", "ledger", "svc-uid", nil)
+	service.SetLabels(map[string]string{"team": "payments"})
+	endpoints := newObject("v1", "Endpoints", "payments", "ledger", "ep-uid", nil)
+	endpoints.Object["subsets"] = []interface{}{map[string]interface{}{
+		"addresses": []interface{}{map[string]interface{}{
+			"ip":        "10.0.0.3",
+			"targetRef": map[string]interface{}{"kind": "Pod", "name": "ledger", "namespace": "payments"},
+		}},
+		"ports": []interface{}{map[string]interface{}{"name": "metrics", "port": int64(8081)}},
+	}}
+	serviceMonitor := newObject("monitoring.coreos.com/v1", "ServiceMonitor", "monitoring", "ledger", "sm-uid", nil)
+	serviceMonitor.Object["spec"] = map[string]interface{}{
+		"selector":          map[string]interface{}{"matchLabels": map[string]interface{}{"team": "payments"}},
+		"namespaceSelector": map[string]interface{}{"matchNames": []interface{}{"payments"}},
+		"endpoints":         []interface{}{map[string]interface{}{"port": "metrics", "scheme": "https"}},
+	}
+
+	gvrs := []schema.GroupVersionResource{podGVR, serviceGVR, endpointsGVR, podMonitorGVR, serviceMonitorGVR}
+	listKinds := map[schema.GroupVersionResource]string{}
+	for _, gvr := range gvrs {
+		listKinds[gvr] = "List"
+	}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds,
+		annotated, monitored, other, podMonitor, service, endpoints, serviceMonitor)
+	informerFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, time.Minute)
+	for _, gvr := range gvrs {
+		informerFactory.ForResource(gvr)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	informerFactory.Start(ctx.Done())
+	informerFactory.WaitForCacheSync(ctx.Done())
+
+	scraper := &WorkloadScraper{
+		informerFactory: informerFactory,
+		monitorGVRs:     map[schema.GroupVersionResource]struct{}{podMonitorGVR: {}, serviceMonitorGVR: {}},
+	}
+	targets := scraper.discoverTargets()
+	urls := make([]string, 0, len(targets))
+	for _, target := range targets {
+		urls = append(urls, target.URL)
+	}
+	// the pod monitor doesn't select the ledger pod, from another namespace
+	assert.Equal(t, []string{
+		"http://10.0.0.1:9102/stats",
+		"http://10.0.0.2:9102/metrics",
+		"https://10.0.0.3:8081/metrics",
+	}, urls)
+
+	labelValues := func(index int) map[string]string {
+		values := make(map[string]string)
+		for _, label := range targets[index].Labels {
+			values[label.Name] = label.Value
+		}
+		return values
+	}
+	assert.Equal(t, map[string]string{"instance": "10.0.0.1:9102", "job": annotatedPodsJob, "namespace": "shop", "pod": "cart"}, labelValues(0))
+	assert.Equal(t, "podMonitor/shop/checkout", labelValues(1)["job"])
+	assert.Equal(t, map[string]string{
+		"instance": "10.0.0.3:8081", "job": "ledger", "namespace": "payments", "service": "ledger", "pod": "ledger",
+	}, labelValues(2))
+}
@@ -0,0 +1,188 @@
+package provenance
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/webb-ai/k8s-agent/pkg/util"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Provenance is where an object comes from, as recorded by deployment tools in its labels, annotations and image tags
+type Provenance struct {
+	// Tool is the tool deploying the object, e.g. argocd, flux or helm
+	Tool string `json:"tool,omitempty"`
+	// App is the application of the tool the object belongs to, e.g. the argo cd application or helm release
+	App      string `json:"app,omitempty"`
+	Repo     string `json:"repo,omitempty"`
+	Revision string `json:"revision,omitempty"`
+	Version  string `json:"version,omitempty"`
+}
+
+// Fields of the provenance that extra keys can be configured for
+const (
+	FieldTool     = "tool"
+	FieldApp      = "app"
+	FieldRepo     = "repo"
+	FieldRevision = "revision"
+	FieldVersion  = "version"
+)
+
+var fields = map[string]struct{}{
+	FieldTool:     {},
+	FieldApp:      {},
+	FieldRepo:     {},
+	FieldRevision: {},
+	FieldVersion:  {},
+}
+
+const (
+	argocdTrackingId       = "argocd.argoproj.io/tracking-id"
+	argocdInstance         = "argocd.argoproj.io/instance"
+	fluxKustomizeName      = "kustomize.toolkit.fluxcd.io/name"
+	fluxKustomizeNamespace = "kustomize.toolkit.fluxcd.io/namespace"
+	fluxHelmName           = "helm.toolkit.fluxcd.io/name"
+	fluxHelmNamespace      = "helm.toolkit.fluxcd.io/namespace"
+	helmReleaseName        = "meta.helm.sh/release-name"
+	managedBy              = "app.kubernetes.io/managed-by"
+	appName                = "app.kubernetes.io/name"
+	appVersion             = "app.kubernetes.io/version"
+	ociSource              = "org.opencontainers.image.source"
+	ociRevision            = "org.opencontainers.image.revision"
+)
+
+// commitTag matches image tags built from a commit sha, e.g. 9fceb02, sha-9fceb02 or v1.2.0-g9fceb02
+var commitTag = regexp.MustCompile(`(?:^|[-_.+]g?)([0-9a-f]{7,40})$`)
+
+// Extractor extracts the provenance of objects from well-known labels, annotations and image tags,
+// and from extra keys of in-house tooling which take precedence
+type Extractor struct {
+	extraKeys map[string][]string
+}
+
+func NewExtractor(extraKeys map[string][]string) *Extractor {
+	return &Extractor{extraKeys: extraKeys}
+}
+
+// ParseKeys parses comma separated field=key pairs, e.g. repo=acme.com/git-repo,revision=acme.com/git-sha.
+// Keys are looked up in annotations, then labels. A field can be given several keys, the first found wins.
+func ParseKeys(value string) (map[string][]string, error) {
+	keys := make(map[string][]string)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		field, key, found := strings.Cut(pair, "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf("invalid provenance key %q, expected field=key", pair)
+		}
+		if _, ok := fields[field]; !ok {
+			return nil, fmt.Errorf("invalid provenance field %q, expected one of tool, app, repo, revision or version", field)
+		}
+		keys[field] = append(keys[field], key)
+	}
+	return keys, nil
+}
+
+// Extract returns the provenance of an object, or nil if nothing is known about it
+func (e *Extractor) Extract(object *unstructured.Unstructured) *Provenance {
+	if object == nil {
+		return nil
+	}
+	metadata := newMetadata(object)
+	provenance := &Provenance{
+		Tool:     metadata.lookup(e.keys(FieldTool)...),
+		App:      metadata.lookup(e.keys(FieldApp)...),
+		Repo:     metadata.lookup(e.keys(FieldRepo, ociSource)...),
+		Revision: metadata.lookup(e.keys(FieldRevision, ociRevision)...),
+		Version:  metadata.lookup(e.keys(FieldVersion, appVersion)...),
+	}
+
+	tool, app := deploymentTool(metadata)
+	if provenance.Tool == "" {
+		provenance.Tool = tool
+	}
+	if provenance.App == "" {
+		provenance.App = app
+	}
+	if provenance.Revision == "" {
+		provenance.Revision = imageRevision(util.ContainerImages(object))
+	}
+
+	if *provenance == (Provenance{}) {
+		return nil
+	}
+	return provenance
+}
+
+// keys returns the extra keys of a field followed by its well-known keys
+func (e *Extractor) keys(field string, wellKnown ...string) []string {
+	keys := make([]string, 0, len(e.extraKeys[field])+len(wellKnown))
+	keys = append(keys, e.extraKeys[field]...)
+	return append(keys, wellKnown...)
+}
+
+// deploymentTool returns the gitops or packaging tool managing the object and its application
+func deploymentTool(metadata *metadata) (string, string) {
+	if trackingId := metadata.lookup(argocdTrackingId); trackingId != "" {
+		// <app>:<group>/<kind>:<namespace>/<name>
+		app, _, _ := strings.Cut(trackingId, ":")
+		return "argocd", app
+	}
+	if instance := metadata.lookup(argocdInstance); instance != "" {
+		return "argocd", instance
+	}
+	if name := metadata.lookup(fluxKustomizeName); name != "" {
+		return "flux", metadata.lookup(fluxKustomizeNamespace) + "/" + name
+	}
+	if name := metadata.lookup(fluxHelmName); name != "" {
+		return "flux", metadata.lookup(fluxHelmNamespace) + "/" + name
+	}
+	if release := metadata.lookup(helmReleaseName); release != "" {
+		return "helm", release
+	}
+	return strings.ToLower(metadata.lookup(managedBy)), metadata.lookup(appName)
+}
+
+func imageRevision(images []string) string {
+	for _, image := range images {
+		if match := commitTag.FindStringSubmatch(util.ImageTag(image)); match != nil && isCommit(match[1]) {
+			return match[1]
+		}
+	}
+	return ""
+}
+
+// isCommit tells shas from hex looking words and dates, shas have both digits and letters
+func isCommit(value string) bool {
+	return strings.ContainsAny(value, "0123456789") && strings.ContainsAny(value, "abcdef")
+}
+
+// metadata are the labels and annotations of an object, and of its pod template if it has one
+type metadata struct {
+	maps []map[string]string
+}
+
+func newMetadata(object *unstructured.Unstructured) *metadata {
+	templateLabels, templateAnnotations := util.PodTemplateMetadata(object)
+	return &metadata{maps: []map[string]string{
+		object.GetAnnotations(),
+		object.GetLabels(),
+		templateAnnotations,
+		templateLabels,
+	}}
+}
+
+// lookup returns the value of the first key found
+func (m *metadata) lookup(keys ...string) string {
+	for _, key := range keys {
+		for _, values := range m.maps {
+			if value := values[key]; value != "" {
+				return value
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,70 @@
+package provenance
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func newDeployment(labels, annotations map[string]string, image string) *unstructured.Unstructured {
+	object := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"spec": map[string]interface{}{"template": map[string]interface{}{
+			"metadata": map[string]interface{}{"labels": map[string]interface{}{"app.kubernetes.io/version": "1.4.0"}},
+			"spec": map[string]interface{}{"containers": []interface{}{
+				map[string]interface{}{"name": "app", "image": image},
+			}},
+		}},
+	}}
+	object.SetName("checkout")
+	object.SetLabels(labels)
+	object.SetAnnotations(annotations)
+	return object
+}
+
+func TestExtract(t *testing.T) {
+	extractor := NewExtractor(nil)
+
+	argocd := newDeployment(nil, map[string]string{
+		argocdTrackingId: "shop:apps/Deployment:shop/checkout",
+	}, "ghcr.io/acme/checkout:sha-9fceb02")
+	assert.Equal(t, &Provenance{Tool: "argocd", App: "shop", Revision: "9fceb02", Version: "1.4.0"}, extractor.Extract(argocd))
+
+	flux := newDeployment(map[string]string{
+		fluxKustomizeName:      "apps",
+		fluxKustomizeNamespace: "flux-system",
+		helmReleaseName:        "ignored",
+		appVersion:             "1.5.0",
+	}, nil, "acme/checkout:v1.5.0-g1a2b3c4d")
+	assert.Equal(t, &Provenance{Tool: "flux", App: "flux-system/apps", Revision: "1a2b3c4d", Version: "1.5.0"}, extractor.Extract(flux))
+
+	helm := newDeployment(map[string]string{managedBy: "Helm"}, map[string]string{helmReleaseName: "checkout"}, "acme/checkout:20231001")
+	assert.Equal(t, &Provenance{Tool: "helm", App: "checkout", Version: "1.4.0"}, extractor.Extract(helm))
+
+	configMap := &unstructured.Unstructured{Object: map[string]interface{}{"kind": "ConfigMap"}}
+	assert.Nil(t, extractor.Extract(configMap))
+}
+
+func TestExtractExtraKeys(t *testing.T) {
+	keys, err := ParseKeys("repo=acme.com/git-repo, revision=acme.com/git-sha,revision=acme.com/commit")
+	assert.NoError(t, err)
+	extractor := NewExtractor(keys)
+
+	object := newDeployment(nil, map[string]string{
+		"acme.com/git-repo": "github.com/acme/shop",
+		"acme.com/commit":   "9fceb02d0ae598e95dc970b74767f19372d61af8",
+		ociSource:           "github.com/acme/checkout",
+	}, "acme/checkout:1a2b3c4d")
+	assert.Equal(t, &Provenance{
+		Repo:     "github.com/acme/shop",
+		Revision: "9fceb02d0ae598e95dc970b74767f19372d61af8",
+		Version:  "1.4.0",
+	}, extractor.Extract(object))
+
+	_, err = ParseKeys("branch=acme.com/branch")
+	assert.Error(t, err)
+	_, err = ParseKeys("repo")
+	assert.Error(t, err)
+}
@@ -0,0 +1,102 @@
+package redact
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+// SaltSecretKey is the key of the salt in the secret persisting it
+const SaltSecretKey = "salt"
+
+// podSpecPaths are the locations of pod specs in pods, workloads and cronjobs
+var podSpecPaths = []string{
+	".spec",
+	".spec.template.spec",
+	".spec.jobTemplate.spec.template.spec",
+}
+
+var containerFields = []string{"containers", "initContainers", "ephemeralContainers"}
+
+// DefaultRules are always evaluated after user defined rules. They drop secret data, helm releases are
+// decoded before that, and the last applied configuration of secrets since it holds the data in plain text.
+// Helm values often hold credentials, so they are hashed unless a rule keeps them, and dropped without a salt.
+func DefaultRules() []Rule {
+	return []Rule{
+		{
+			Name:   "hash-helm-values",
+			Match:  Match{Kinds: []string{HelmReleaseKind}},
+			Path:   ".values",
+			Action: ActionHash,
+		},
+		{
+			Name:   "drop-secret-data",
+			Match:  Match{Kinds: []string{"Secret"}},
+			Path:   ".data",
+			Action: ActionDrop,
+		},
+		{
+			Name:   "drop-secret-string-data",
+			Match:  Match{Kinds: []string{"Secret"}},
+			Path:   ".stringData",
+			Action: ActionDrop,
+		},
+		{
+			Name:   "drop-secret-last-applied",
+			Match:  Match{Kinds: []string{"Secret"}},
+			Path:   ".metadata.annotations['kubectl.kubernetes.io/last-applied-configuration']",
+			Action: ActionDrop,
+		},
+	}
+}
+
+// EnvVarRules redacts env and envFrom of every container, init container and ephemeral container
+func EnvVarRules(action Action) []Rule {
+	rules := make([]Rule, 0, len(podSpecPaths)*len(containerFields)*2)
+	for _, podSpec := range podSpecPaths {
+		for _, field := range containerFields {
+			for _, env := range []string{"env", "envFrom"} {
+				rules = append(rules, Rule{
+					Name:   "redact-" + env,
+					Path:   podSpec + "." + field + "[*]." + env,
+					Action: action,
+				})
+			}
+		}
+	}
+	return rules
+}
+
+// HashedEnvVarRules keep env var names and valueFrom references, but replace literal values with a salted hmac,
+// so changes to a variable are visible without exposing its value
+func HashedEnvVarRules() []Rule {
+	rules := make([]Rule, 0, len(podSpecPaths)*len(containerFields))
+	for _, podSpec := range podSpecPaths {
+		for _, field := range containerFields {
+			rules = append(rules, Rule{
+				Name:   "hash-env-value",
+				Path:   podSpec + "." + field + "[*].env[*].value",
+				Action: ActionHash,
+			})
+		}
+	}
+	return rules
+}
+
+// GenerateSalt generates secret data holding a random salt for the hash action
+func GenerateSalt() (map[string][]byte, error) {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("error generating redaction salt: %w", err)
+	}
+	return map[string][]byte{SaltSecretKey: []byte(hex.EncodeToString(salt))}, nil
+}
+
+// DefaultPolicy returns the policy used when no policy file is configured
+func DefaultPolicy() *Policy {
+	policy, err := NewPolicy("", nil, DefaultRules()...)
+	if err != nil {
+		panic(err)
+	}
+	return policy
+}
@@ -0,0 +1,170 @@
+package redact
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// segment is one step of a parsed path. An empty name with index -1 is a wildcard.
+type segment struct {
+	name     string
+	index    int
+	wildcard bool
+}
+
+// parsePath parses the subset of JSONPath supported by rules:
+// dotted field names, [*] or .* wildcards, [n] list indexes and ['key'] for keys containing dots,
+// e.g. $.spec.template.spec.containers[*].env or .metadata.annotations['kubectl.kubernetes.io/last-applied-configuration'].
+// The leading $ and surrounding {} of kubectl style JSONPath are optional.
+func parsePath(path string) ([]segment, error) {
+	p := strings.TrimSpace(path)
+	p = strings.TrimSuffix(strings.TrimPrefix(p, "{"), "}")
+	p = strings.TrimPrefix(p, "$")
+
+	var segments []segment
+	for i := 0; i < len(p); {
+		switch p[i] {
+		case '.':
+			i++
+		case '[':
+			end := strings.IndexByte(p[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path %q: unterminated [", path)
+			}
+			seg, err := parseBracket(p[i+1 : i+end])
+			if err != nil {
+				return nil, fmt.Errorf("invalid path %q: %w", path, err)
+			}
+			segments = append(segments, seg)
+			i += end + 1
+		default:
+			end := strings.IndexAny(p[i:], ".[")
+			if end < 0 {
+				end = len(p) - i
+			}
+			name := p[i : i+end]
+			if name == "*" {
+				segments = append(segments, segment{wildcard: true})
+			} else {
+				segments = append(segments, segment{name: name, index: -1})
+			}
+			i += end
+		}
+	}
+
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("invalid path %q: path must select a field", path)
+	}
+	return segments, nil
+}
+
+func parseBracket(content string) (segment, error) {
+	content = strings.TrimSpace(content)
+	if content == "*" {
+		return segment{wildcard: true}, nil
+	}
+	if len(content) >= 2 && (content[0] == '\'' || content[0] == '"') && content[len(content)-1] == content[0] {
+		return segment{name: content[1 : len(content)-1], index: -1}, nil
+	}
+	index, err := strconv.Atoi(content)
+	if err != nil || index < 0 {
+		return segment{}, fmt.Errorf("unsupported selector [%s]", content)
+	}
+	return segment{index: index}, nil
+}
+
+// transform walks node along the path and replaces every selected value with the result of fn.
+// If fn returns false the selected value is removed from its parent map or list.
+// Protected paths are relative to node; values at or below them are never passed to fn. If a selected value
+// contains protected values, fn is applied to each of its unprotected children instead.
+func transform(node interface{}, path []segment, protected [][]segment, fn func(interface{}) (interface{}, bool)) (interface{}, bool) {
+	if len(path) == 0 {
+		if len(protected) == 0 {
+			return fn(node)
+		}
+		// a protected value is below this one, so redact around it
+		return transformChildren(node, segment{wildcard: true}, nil, protected, fn)
+	}
+	return transformChildren(node, path[0], path[1:], protected, fn)
+}
+
+func transformChildren(
+	node interface{},
+	seg segment,
+	rest []segment,
+	protected [][]segment,
+	fn func(interface{}) (interface{}, bool),
+) (interface{}, bool) {
+	switch typed := node.(type) {
+	case map[string]interface{}:
+		for key, value := range typed {
+			if !seg.wildcard && (seg.name == "" || seg.name != key) {
+				continue
+			}
+			childProtected, skip := descend(protected, key, -1)
+			if skip {
+				continue
+			}
+			if newValue, keep := transform(value, rest, childProtected, fn); keep {
+				typed[key] = newValue
+			} else {
+				delete(typed, key)
+			}
+		}
+		return typed, true
+	case []interface{}:
+		result := typed[:0]
+		for i, value := range typed {
+			if seg.wildcard || (seg.name == "" && seg.index == i) {
+				childProtected, skip := descend(protected, "", i)
+				if !skip {
+					newValue, keep := transform(value, rest, childProtected, fn)
+					if !keep {
+						continue
+					}
+					value = newValue
+				}
+			}
+			result = append(result, value)
+		}
+		return result, true
+	default:
+		return node, true
+	}
+}
+
+// descend returns the protected paths that continue below the child with the given key or index,
+// and whether the child itself is protected
+func descend(protected [][]segment, key string, index int) ([][]segment, bool) {
+	var result [][]segment
+	for _, path := range protected {
+		seg := path[0]
+		if !seg.wildcard && !(key != "" && seg.name == key) && !(key == "" && seg.name == "" && seg.index == index) {
+			continue
+		}
+		if len(path) == 1 {
+			return nil, true
+		}
+		result = append(result, path[1:])
+	}
+	return result, false
+}
+
+// mapLeaves applies fn to every leaf below node, keeping the structure of maps and lists
+func mapLeaves(node interface{}, fn func(interface{}) interface{}) interface{} {
+	switch typed := node.(type) {
+	case map[string]interface{}:
+		for key, value := range typed {
+			typed[key] = mapLeaves(value, fn)
+		}
+		return typed
+	case []interface{}:
+		for i, value := range typed {
+			typed[i] = mapLeaves(value, fn)
+		}
+		return typed
+	default:
+		return fn(node)
+	}
+}
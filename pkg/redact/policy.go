@@ -0,0 +1,305 @@
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+type Action string
+
+const (
+	// ActionDrop removes the selected field
+	ActionDrop Action = "drop"
+	// ActionHash replaces every value below the selected field with a salted hmac
+	ActionHash Action = "hash"
+	// ActionMask replaces every value below the selected field with a fixed mask
+	ActionMask Action = "mask"
+	// ActionKeep leaves the selected field untouched and protects it from later rules
+	ActionKeep Action = "keep"
+
+	// IssueKind is the kind rules can match to redact issues, which are evaluated as {"kind": "Issue", "data": "..."},
+	// or {"kind": "Issue", "labels": {...}, "annotations": {...}} for typed issues
+	IssueKind = "Issue"
+	// HelmReleaseKind is the kind rules can match to redact helm values,
+	// which are evaluated as {"kind": "HelmRelease", "metadata": {"name": ..., "namespace": ...}, "values": {...}}
+	HelmReleaseKind = "HelmRelease"
+
+	Mask         = "********"
+	HashPrefix   = "hmac-sha256:"
+	ScrubbedText = "[REDACTED]"
+)
+
+// Match selects the objects a rule applies to. Empty lists match everything.
+type Match struct {
+	Kinds      []string `json:"kinds,omitempty"`
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Types matches the top level type field, e.g. the type of a secret or service
+	Types []string `json:"types,omitempty"`
+}
+
+// Rule redacts the values selected by Path in matching objects
+type Rule struct {
+	Name   string `json:"name,omitempty"`
+	Match  Match  `json:"match,omitempty"`
+	Path   string `json:"path"`
+	Action Action `json:"action,omitempty"`
+	// Scrubbers are names of built-in or policy defined scrubbers applied to the string values below Path
+	Scrubbers []string `json:"scrubbers,omitempty"`
+
+	segments []segment
+}
+
+// Policy is an ordered list of redaction rules. For a given object, the first keep rule on a path
+// protects that path and everything below it from the rules that follow.
+type Policy struct {
+	// Salt is the key of the hmac used by the hash action
+	Salt string `json:"salt,omitempty"`
+	// Scrubbers defines additional scrubbers as name to regular expression
+	Scrubbers map[string]string `json:"scrubbers,omitempty"`
+	Rules     []Rule            `json:"rules"`
+
+	scrubbers map[string]*scrubber
+}
+
+// LoadPolicy reads a yaml or json policy file
+func LoadPolicy(file string) (*Policy, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading redaction policy %s: %w", file, err)
+	}
+	var policy Policy
+	if err := yaml.UnmarshalStrict(content, &policy); err != nil {
+		return nil, fmt.Errorf("error parsing redaction policy %s: %w", file, err)
+	}
+	return NewPolicy(policy.Salt, policy.Scrubbers, policy.Rules...)
+}
+
+// NewPolicy validates the rules and compiles paths and scrubbers
+func NewPolicy(salt string, scrubbers map[string]string, rules ...Rule) (*Policy, error) {
+	policy := &Policy{
+		Salt:      salt,
+		Scrubbers: scrubbers,
+		Rules:     rules,
+		scrubbers: builtinScrubbers(),
+	}
+	for name, pattern := range scrubbers {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scrubber %s: %w", name, err)
+		}
+		policy.scrubbers[name] = &scrubber{pattern: re, replacement: ScrubbedText}
+	}
+
+	for i := range policy.Rules {
+		rule := &policy.Rules[i]
+		segments, err := parsePath(rule.Path)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d (%s): %w", i, rule.Name, err)
+		}
+		rule.segments = segments
+		switch rule.Action {
+		case ActionDrop, ActionHash, ActionMask, ActionKeep:
+		case "":
+			if len(rule.Scrubbers) == 0 {
+				return nil, fmt.Errorf("rule %d (%s): needs an action or scrubbers", i, rule.Name)
+			}
+		default:
+			return nil, fmt.Errorf("rule %d (%s): unknown action %q", i, rule.Name, rule.Action)
+		}
+		for _, name := range rule.Scrubbers {
+			if _, ok := policy.scrubbers[name]; !ok {
+				return nil, fmt.Errorf("rule %d (%s): unknown scrubber %q", i, rule.Name, name)
+			}
+		}
+	}
+	return policy, nil
+}
+
+// Merge returns a policy evaluating the rules of p before the rules of other
+func (p *Policy) Merge(other *Policy) (*Policy, error) {
+	salt := p.Salt
+	if salt == "" {
+		salt = other.Salt
+	}
+	scrubbers := map[string]string{}
+	for name, pattern := range other.Scrubbers {
+		scrubbers[name] = pattern
+	}
+	for name, pattern := range p.Scrubbers {
+		scrubbers[name] = pattern
+	}
+	rules := make([]Rule, 0, len(p.Rules)+len(other.Rules))
+	rules = append(rules, p.Rules...)
+	rules = append(rules, other.Rules...)
+	return NewPolicy(salt, scrubbers, rules...)
+}
+
+// WithSalt returns a copy of the policy using salt for the hash action
+func (p *Policy) WithSalt(salt string) (*Policy, error) {
+	return NewPolicy(salt, p.Scrubbers, p.Rules...)
+}
+
+// UsesHash returns whether any rule hashes values, and so needs a salt
+func (p *Policy) UsesHash() bool {
+	for _, rule := range p.Rules {
+		if rule.Action == ActionHash {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply redacts the object in place
+func (p *Policy) Apply(object *unstructured.Unstructured) {
+	if p == nil || object == nil {
+		return
+	}
+	p.apply(object.Object)
+}
+
+// ApplyToIssue redacts issue payloads with the rules matching the Issue kind and the .data path
+func (p *Policy) ApplyToIssue(data string) string {
+	if p == nil {
+		return data
+	}
+	object := map[string]interface{}{"kind": IssueKind, "data": data}
+	p.apply(object)
+	redacted, ok := object["data"].(string)
+	if !ok {
+		return ""
+	}
+	return redacted
+}
+
+// ApplyToIssueAttributes redacts copies of the labels and annotations of a typed issue with the rules matching
+// the Issue kind and the .labels and .annotations paths
+func (p *Policy) ApplyToIssueAttributes(labels, annotations map[string]string) (map[string]string, map[string]string) {
+	if p == nil {
+		return labels, annotations
+	}
+	object := map[string]interface{}{
+		"kind":        IssueKind,
+		"labels":      toInterfaceMap(labels),
+		"annotations": toInterfaceMap(annotations),
+	}
+	p.apply(object)
+	return toStringMap(object["labels"]), toStringMap(object["annotations"])
+}
+
+func toInterfaceMap(values map[string]string) map[string]interface{} {
+	result := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		result[key] = value
+	}
+	return result
+}
+
+func toStringMap(value interface{}) map[string]string {
+	values, ok := value.(map[string]interface{})
+	if !ok || len(values) == 0 {
+		return nil
+	}
+	result := make(map[string]string, len(values))
+	for key, value := range values {
+		result[key] = fmt.Sprint(value)
+	}
+	return result
+}
+
+// ApplyToHelmValues redacts the values of a helm release with the rules matching the HelmRelease kind and the .values path
+func (p *Policy) ApplyToHelmValues(namespace, name string, values map[string]interface{}) map[string]interface{} {
+	if p == nil {
+		return values
+	}
+	object := map[string]interface{}{
+		"kind":     HelmReleaseKind,
+		"metadata": map[string]interface{}{"name": name, "namespace": namespace},
+		"values":   values,
+	}
+	p.apply(object)
+	redacted, _ := object["values"].(map[string]interface{})
+	return redacted
+}
+
+func (p *Policy) apply(object map[string]interface{}) {
+	var protected [][]segment
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if !rule.Match.matches(object) {
+			continue
+		}
+		if rule.Action == ActionKeep {
+			protected = append(protected, rule.segments)
+			continue
+		}
+		transform(object, rule.segments, protected, func(value interface{}) (interface{}, bool) {
+			return p.redact(rule, value)
+		})
+	}
+}
+
+func (p *Policy) redact(rule *Rule, value interface{}) (interface{}, bool) {
+	switch rule.Action {
+	case ActionDrop:
+		return nil, false
+	case ActionHash:
+		// an unsalted hash of a low entropy value is easily reversed, drop the value instead
+		if p.Salt == "" {
+			return nil, false
+		}
+		value = mapLeaves(value, func(leaf interface{}) interface{} { return p.Hash(leaf) })
+	case ActionMask:
+		value = mapLeaves(value, func(leaf interface{}) interface{} { return Mask })
+	}
+	for _, name := range rule.Scrubbers {
+		s := p.scrubbers[name]
+		value = mapLeaves(value, func(leaf interface{}) interface{} {
+			if str, ok := leaf.(string); ok {
+				return s.scrub(str)
+			}
+			return leaf
+		})
+	}
+	return value, true
+}
+
+// Hash returns the salted hmac of a value. Equal values hash to the same result within a cluster,
+// so changes remain detectable without exposing the value.
+func (p *Policy) Hash(value interface{}) string {
+	var content []byte
+	if str, ok := value.(string); ok {
+		content = []byte(str)
+	} else {
+		content, _ = json.Marshal(value)
+	}
+	mac := hmac.New(sha256.New, []byte(p.Salt))
+	mac.Write(content)
+	return HashPrefix + hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func (m *Match) matches(object map[string]interface{}) bool {
+	kind, _, _ := unstructured.NestedString(object, "kind")
+	namespace, _, _ := unstructured.NestedString(object, "metadata", "namespace")
+	objectType, _, _ := unstructured.NestedString(object, "type")
+	return matchesAny(m.Kinds, kind) && matchesAny(m.Namespaces, namespace) && matchesAny(m.Types, objectType)
+}
+
+func matchesAny(candidates []string, value string) bool {
+	if len(candidates) == 0 {
+		return true
+	}
+	for _, candidate := range candidates {
+		if candidate == "*" || candidate == value {
+			return true
+		}
+	}
+	return false
+}
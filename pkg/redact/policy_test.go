@@ -0,0 +1,441 @@
+package redact
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestParsePath(t *testing.T) {
+	testCases := []struct {
+		path     string
+		expected []segment
+		err      bool
+	}{
+		{".data", []segment{{name: "data", index: -1}}, false},
+		{"$.spec.containers[*].env", []segment{
+			{name: "spec", index: -1}, {name: "containers", index: -1}, {wildcard: true}, {name: "env", index: -1},
+		}, false},
+		{"{.spec.containers[0]}", []segment{{name: "spec", index: -1}, {name: "containers", index: -1}, {index: 0}}, false},
+		{".metadata.annotations['a.b/c']", []segment{
+			{name: "metadata", index: -1}, {name: "annotations", index: -1}, {name: "a.b/c", index: -1},
+		}, false},
+		{".data.*", []segment{{name: "data", index: -1}, {wildcard: true}}, false},
+		{"$", nil, true},
+		{".spec[", nil, true},
+		{".spec[?(@.name)]", nil, true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.path, func(t *testing.T) {
+			segments, err := parsePath(testCase.path)
+			if testCase.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expected, segments)
+		})
+	}
+}
+
+func TestDefaultPolicy(t *testing.T) {
+	newSecret := func(secretType corev1.SecretType) *unstructured.Unstructured {
+		return toUnstructured(t, &corev1.Secret{
+			TypeMeta: metav1.TypeMeta{Kind: "Secret", APIVersion: "v1"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "secret",
+				Namespace:   "default",
+				Annotations: map[string]string{"kubectl.kubernetes.io/last-applied-configuration": "{}", "team": "a"},
+			},
+			Data: map[string][]byte{"password": []byte("hunter2")},
+			Type: secretType,
+		})
+	}
+
+	opaque := newSecret(corev1.SecretTypeOpaque)
+	DefaultPolicy().Apply(opaque)
+	_, found, _ := unstructured.NestedFieldNoCopy(opaque.Object, "data")
+	assert.False(t, found)
+	assert.Equal(t, map[string]string{"team": "a"}, opaque.GetAnnotations())
+
+	helm := newSecret("helm.sh/release.v1")
+	DefaultPolicy().Apply(helm)
+	_, found, _ = unstructured.NestedFieldNoCopy(helm.Object, "data")
+	assert.False(t, found, "helm releases are decoded instead of shipped raw")
+}
+
+func TestFingerprintSecret(t *testing.T) {
+	newSecret := func(data map[string][]byte) *unstructured.Unstructured {
+		return toUnstructured(t, &corev1.Secret{
+			TypeMeta:   metav1.TypeMeta{Kind: "Secret", APIVersion: "v1"},
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"},
+			Data:       data,
+		})
+	}
+	oldSecret := newSecret(map[string][]byte{"username": []byte("admin"), "password": []byte("hunter2"), "host": []byte("db")})
+	newSecret2 := newSecret(map[string][]byte{"username": []byte("admin"), "password": []byte("correct-horse"), "port": []byte("5432")})
+
+	assert.Nil(t, DefaultPolicy().FingerprintSecret(oldSecret), "no fingerprints without a salt")
+
+	policy, _ := DefaultPolicy().WithSalt("salt")
+	oldKeys := policy.FingerprintSecret(oldSecret)
+	newKeys := policy.FingerprintSecret(newSecret2)
+	assert.Equal(t, 13, newKeys["password"].Size)
+	assert.NotContains(t, newKeys["password"].Hash, "correct-horse")
+
+	added, removed, rotated := DiffFingerprints(oldKeys, newKeys)
+	assert.Equal(t, []string{"port"}, added)
+	assert.Equal(t, []string{"host"}, removed)
+	assert.Equal(t, []string{"password"}, rotated)
+}
+
+func TestRuleActions(t *testing.T) {
+	configMap := func() *unstructured.Unstructured {
+		return toUnstructured(t, &corev1.ConfigMap{
+			TypeMeta:   metav1.TypeMeta{Kind: "ConfigMap", APIVersion: "v1"},
+			ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "payments"},
+			Data: map[string]string{
+				"owner": "ops@example.com",
+				"db":    "postgres://admin:s3cret@db:5432/payments",
+				"token": "Authorization: Bearer abc.def",
+			},
+		})
+	}
+
+	policy, err := NewPolicy("salt", map[string]string{"digits": `[0-9]{4}`},
+		Rule{Match: Match{Namespaces: []string{"payments"}}, Path: ".data.owner", Action: ActionKeep},
+		Rule{Match: Match{Kinds: []string{"ConfigMap"}}, Path: ".data", Scrubbers: []string{ScrubberEmail, ScrubberConnectionString, ScrubberToken}},
+	)
+	assert.NoError(t, err)
+
+	object := configMap()
+	policy.Apply(object)
+	data, _, _ := unstructured.NestedStringMap(object.Object, "data")
+	assert.Equal(t, "ops@example.com", data["owner"], "protected by the keep rule")
+	assert.Equal(t, "postgres://[REDACTED]@db:5432/payments", data["db"])
+	assert.Equal(t, "Authorization: [REDACTED]", data["token"])
+
+	hashPolicy, err := NewPolicy("salt", nil, Rule{Path: ".data", Action: ActionHash})
+	assert.NoError(t, err)
+	object = configMap()
+	hashPolicy.Apply(object)
+	data, _, _ = unstructured.NestedStringMap(object.Object, "data")
+	assert.Equal(t, hashPolicy.Hash("ops@example.com"), data["owner"])
+	assert.NotEqual(t, data["owner"], data["db"])
+
+	unsaltedPolicy, err := NewPolicy("", nil, Rule{Path: ".data", Action: ActionHash})
+	assert.NoError(t, err)
+	object = configMap()
+	unsaltedPolicy.Apply(object)
+	assert.NotContains(t, object.Object, "data", "unsalted hashes are dropped")
+
+	maskPolicy, err := NewPolicy("", nil, Rule{Match: Match{Kinds: []string{"Secret"}}, Path: ".data", Action: ActionMask})
+	assert.NoError(t, err)
+	object = configMap()
+	maskPolicy.Apply(object)
+	data, _, _ = unstructured.NestedStringMap(object.Object, "data")
+	assert.Equal(t, "ops@example.com", data["owner"], "kind doesn't match")
+
+	_, err = NewPolicy("", nil, Rule{Path: ".data", Action: "encrypt"})
+	assert.Error(t, err)
+	_, err = NewPolicy("", nil, Rule{Path: ".data", Scrubbers: []string{"unknown"}})
+	assert.Error(t, err)
+}
+
+func TestHashedEnvVarRules(t *testing.T) {
+	policy, err := NewPolicy("salt", nil, HashedEnvVarRules()...)
+	assert.NoError(t, err)
+
+	newDeployment := func(databaseUrl string) *unstructured.Unstructured {
+		return toUnstructured(t, &appsv1.Deployment{
+			Spec: appsv1.DeploymentSpec{
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{
+							{
+								Name: "app",
+								Env: []corev1.EnvVar{
+									{Name: "DATABASE_URL", Value: databaseUrl},
+									{Name: "API_KEY", ValueFrom: &corev1.EnvVarSource{
+										SecretKeyRef: &corev1.SecretKeySelector{
+											LocalObjectReference: corev1.LocalObjectReference{Name: "api"},
+											Key:                  "key",
+										},
+									}},
+								},
+							},
+						},
+					},
+				},
+			},
+		})
+	}
+
+	envOf := func(object *unstructured.Unstructured) []corev1.EnvVar {
+		var deployment appsv1.Deployment
+		assert.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(object.Object, &deployment))
+		return deployment.Spec.Template.Spec.Containers[0].Env
+	}
+
+	first := newDeployment("postgres://prod")
+	second := newDeployment("postgres://prod")
+	changed := newDeployment("postgres://staging")
+	for _, object := range []*unstructured.Unstructured{first, second, changed} {
+		policy.Apply(object)
+	}
+
+	env := envOf(first)
+	assert.Equal(t, "DATABASE_URL", env[0].Name)
+	assert.Equal(t, policy.Hash("postgres://prod"), env[0].Value)
+	assert.Equal(t, "api", env[1].ValueFrom.SecretKeyRef.Name)
+	assert.Equal(t, env[0].Value, envOf(second)[0].Value)
+	assert.NotEqual(t, env[0].Value, envOf(changed)[0].Value)
+
+	otherCluster, _ := policy.WithSalt("other")
+	assert.NotEqual(t, policy.Hash("postgres://prod"), otherCluster.Hash("postgres://prod"))
+}
+
+func TestApplyToIssue(t *testing.T) {
+	policy, err := NewPolicy("", nil, Rule{Match: Match{Kinds: []string{IssueKind}}, Path: ".data", Scrubbers: []string{ScrubberEmail}})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"owner":"[REDACTED]"}`, policy.ApplyToIssue(`{"owner":"ops@example.com"}`))
+
+	policy, err = NewPolicy("", nil,
+		Rule{Match: Match{Kinds: []string{IssueKind}}, Path: ".annotations", Scrubbers: []string{ScrubberEmail}},
+		Rule{Match: Match{Kinds: []string{IssueKind}}, Path: ".labels.token", Action: ActionDrop},
+	)
+	assert.NoError(t, err)
+	labels, annotations := policy.ApplyToIssueAttributes(
+		map[string]string{"alertname": "HighLatency", "token": "secret"},
+		map[string]string{"summary": "paging ops@example.com"},
+	)
+	assert.Equal(t, map[string]string{"alertname": "HighLatency"}, labels)
+	assert.Equal(t, map[string]string{"summary": "paging [REDACTED]"}, annotations)
+}
+
+func TestEnvVarRules(t *testing.T) {
+	policy, err := NewPolicy("", nil, EnvVarRules(ActionDrop)...)
+	assert.NoError(t, err)
+
+	cases := []struct {
+		name     string
+		input    *unstructured.Unstructured
+		expected *unstructured.Unstructured
+	}{
+		{
+			name: "Pod with containers",
+			input: toUnstructured(t, &corev1.Pod{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name: "container1",
+							Env: []corev1.EnvVar{
+								{
+									Name:  "VAR1",
+									Value: "value1",
+								},
+							},
+						},
+					},
+				},
+			}),
+			expected: toUnstructured(t, &corev1.Pod{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name: "container1",
+						},
+					},
+				},
+			}),
+		},
+		{
+			name: "Pod with init containers",
+			input: toUnstructured(t, &corev1.Pod{
+				Spec: corev1.PodSpec{
+					InitContainers: []corev1.Container{
+						{
+							Name: "init-container1",
+							Env: []corev1.EnvVar{
+								{
+									Name:  "VAR1",
+									Value: "value1",
+								},
+							},
+						},
+					},
+				},
+			}),
+			expected: toUnstructured(t, &corev1.Pod{
+				Spec: corev1.PodSpec{
+					InitContainers: []corev1.Container{
+						{
+							Name: "init-container1",
+						},
+					},
+				},
+			}),
+		},
+		{
+			name: "CronJob with init containers and containers",
+			input: toUnstructured(t, &batchv1.CronJob{
+				Spec: batchv1.CronJobSpec{
+					JobTemplate: batchv1.JobTemplateSpec{
+						Spec: batchv1.JobSpec{
+							Template: corev1.PodTemplateSpec{
+								Spec: corev1.PodSpec{
+									InitContainers: []corev1.Container{
+										{
+											Name: "init-container1",
+											Env: []corev1.EnvVar{
+												{
+													Name:  "VAR1",
+													Value: "value1",
+												},
+											},
+										},
+									},
+									Containers: []corev1.Container{
+										{
+											Name: "container1",
+											Env: []corev1.EnvVar{
+												{
+													Name:  "VAR2",
+													Value: "value2",
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			}),
+			expected: toUnstructured(t, &batchv1.CronJob{
+				Spec: batchv1.CronJobSpec{
+					JobTemplate: batchv1.JobTemplateSpec{
+						Spec: batchv1.JobSpec{
+							Template: corev1.PodTemplateSpec{
+								Spec: corev1.PodSpec{
+									InitContainers: []corev1.Container{
+										{
+											Name: "init-container1",
+										},
+									},
+									Containers: []corev1.Container{
+										{
+											Name: "container1",
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			}),
+		},
+
+		{
+			name: "Deployment with containers",
+			input: toUnstructured(t, &appsv1.Deployment{
+				Spec: appsv1.DeploymentSpec{
+					Template: corev1.PodTemplateSpec{
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{
+								{
+									Name: "container1",
+									Env: []corev1.EnvVar{
+										{
+											Name:  "VAR1",
+											Value: "value1",
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			}),
+			expected: toUnstructured(t, &appsv1.Deployment{
+				Spec: appsv1.DeploymentSpec{
+					Template: corev1.PodTemplateSpec{
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{
+								{
+									Name: "container1",
+								},
+							},
+						},
+					},
+				},
+			}),
+		},
+
+		{
+			name: "Pod with envFrom and ephemeral containers",
+			input: toUnstructured(t, &corev1.Pod{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name: "container1",
+							EnvFrom: []corev1.EnvFromSource{
+								{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "db"}}},
+							},
+						},
+					},
+					EphemeralContainers: []corev1.EphemeralContainer{
+						{
+							EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+								Name: "debugger",
+								Env:  []corev1.EnvVar{{Name: "VAR1", Value: "value1"}},
+							},
+						},
+					},
+				},
+			}),
+			expected: toUnstructured(t, &corev1.Pod{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name: "container1",
+						},
+					},
+					EphemeralContainers: []corev1.EphemeralContainer{
+						{
+							EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+								Name: "debugger",
+							},
+						},
+					},
+				},
+			}),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			policy.Apply(tc.input)
+			if diff := cmp.Diff(tc.expected, tc.input); diff != "" {
+				t.Errorf("EnvVarRules mismatch (-expected +actual):\n%s", diff)
+			}
+		})
+	}
+}
+
+func toUnstructured(t *testing.T, obj runtime.Object) *unstructured.Unstructured {
+	t.Helper()
+	unstr, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		t.Fatalf("Failed to convert to unstructured: %v", err)
+	}
+	return &unstructured.Unstructured{Object: unstr}
+}
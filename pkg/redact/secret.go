@@ -0,0 +1,53 @@
+package redact
+
+import (
+	"encoding/base64"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// KeyFingerprint identifies the value of a secret key without exposing it
+type KeyFingerprint struct {
+	Hash string `json:"hash"`
+	Size int    `json:"size"`
+}
+
+// FingerprintSecret returns the salted hash and decoded size of every key of a secret.
+// It must be called before the policy drops the secret data, and returns nil if the policy has no salt.
+func (p *Policy) FingerprintSecret(object *unstructured.Unstructured) map[string]KeyFingerprint {
+	if p == nil || p.Salt == "" || object == nil || object.GetKind() != "Secret" {
+		return nil
+	}
+	data, _, _ := unstructured.NestedStringMap(object.Object, "data")
+	fingerprints := make(map[string]KeyFingerprint, len(data))
+	for key, value := range data {
+		size := len(value)
+		if decoded, err := base64.StdEncoding.DecodeString(value); err == nil {
+			size = len(decoded)
+		}
+		fingerprints[key] = KeyFingerprint{Hash: p.Hash(value), Size: size}
+	}
+	return fingerprints
+}
+
+// DiffFingerprints returns the sorted keys added, removed and rotated between two fingerprints
+func DiffFingerprints(oldKeys, newKeys map[string]KeyFingerprint) (added, removed, rotated []string) {
+	for key, newFingerprint := range newKeys {
+		oldFingerprint, found := oldKeys[key]
+		if !found {
+			added = append(added, key)
+		} else if oldFingerprint != newFingerprint {
+			rotated = append(rotated, key)
+		}
+	}
+	for key := range oldKeys {
+		if _, found := newKeys[key]; !found {
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(rotated)
+	return added, removed, rotated
+}
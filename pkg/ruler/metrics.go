@@ -0,0 +1,29 @@
+package ruler
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const ResultKey = "result"
+
+// rulerMetrics are shared by all rulers, metrics can only be registered once
+var rulerMetrics = NewMetrics()
+
+type Metrics struct {
+	SampleCounter *prometheus.CounterVec
+}
+
+func NewMetrics() *Metrics {
+	sampleCounter := promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ruler_sample_total",
+			Help: "Counts the samples appended to the storage of the rule evaluator. Labels: result(appended|rejected)",
+		},
+		[]string{ResultKey},
+	)
+
+	return &Metrics{
+		SampleCounter: sampleCounter,
+	}
+}
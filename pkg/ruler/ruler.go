@@ -0,0 +1,251 @@
+package ruler
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/rules"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/webb-ai/k8s-agent/pkg/alerts"
+	"github.com/webb-ai/k8s-agent/pkg/api"
+	"k8s.io/klog/v2"
+)
+
+// RulesSource is the issue source of the alerts of the rule evaluator
+const RulesSource = "rules"
+
+const (
+	// issueExpiry is how long issues are tracked without notification, alerts are resent every resendDelay
+	issueExpiry = 24 * time.Hour
+	resendDelay = time.Minute
+	// maxQuerySamples bounds the memory of a rule evaluation
+	maxQuerySamples = 10000000
+)
+
+// valueTemplate matches the annotation templates rendering the value of an alert
+var valueTemplate = regexp.MustCompile(`\$value\b|\.Value\b`)
+
+// Ruler evaluates prometheus alerting rules over the traffic metrics sent to webb.ai, so that problems are detected
+// even when webb.ai is unreachable. Series are kept in memory for the window, and the alerts of the rules are sent
+// as issues, opened when they fire and resolved when they stop firing.
+type Ruler struct {
+	api.Client
+	files    []string
+	interval time.Duration
+	enricher api.IssueEnricher
+	tracker  *alerts.Tracker
+	dir      string
+	db       *tsdb.DB
+	metrics  *Metrics
+}
+
+// NewRuler creates a ruler evaluating the rule files matching the patterns every interval, series are stored in
+// dataDir. Issues are enriched by enricher if not nil.
+func NewRuler(client api.Client, patterns []string, interval, window time.Duration, dataDir string, enricher api.IssueEnricher) (*Ruler, error) {
+	var files []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("error listing rule files %s: %w", pattern, err)
+		}
+		// most likely a typo or a ConfigMap not mounted, which would silently evaluate no rule
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no rule file matches %s", pattern)
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+	// annotations rendering the value of alerts, by alert name
+	valueAnnotations := make(map[string]map[string]struct{})
+	for _, file := range files {
+		groups, errs := rulefmt.ParseFile(file)
+		if len(errs) > 0 {
+			return nil, fmt.Errorf("error loading rule file %s: %w", file, errs[0])
+		}
+		for _, group := range groups.Groups {
+			for _, rule := range group.Rules {
+				for name, annotation := range rule.Annotations {
+					if rule.Alert.Value == "" || !valueTemplate.MatchString(annotation) {
+						continue
+					}
+					if valueAnnotations[rule.Alert.Value] == nil {
+						valueAnnotations[rule.Alert.Value] = make(map[string]struct{})
+					}
+					valueAnnotations[rule.Alert.Value][name] = struct{}{}
+				}
+			}
+		}
+	}
+
+	// the wal is disabled, the head block only writes full chunks to disk, series of a previous run are dropped
+	dir := filepath.Join(dataDir, "ruler")
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, fmt.Errorf("error clearing ruler storage: %w", err)
+	}
+	options := tsdb.DefaultOptions()
+	options.WALSegmentSize = -1
+	options.RetentionDuration = window.Milliseconds()
+	options.MinBlockDuration = window.Milliseconds()
+	options.MaxBlockDuration = window.Milliseconds()
+	db, err := tsdb.Open(dir, newLogger(), nil, options, nil)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, fmt.Errorf("error opening ruler storage: %w", err)
+	}
+
+	return &Ruler{
+		Client:   client,
+		files:    files,
+		interval: interval,
+		enricher: enricher,
+		tracker:  alerts.NewStateTracker(issueExpiry, issueState(valueAnnotations)),
+		dir:      dir,
+		db:       db,
+		metrics:  rulerMetrics,
+	}, nil
+}
+
+// SendTrafficMetrics stores the float samples of the series before sending them
+func (r *Ruler) SendTrafficMetrics(request *prompb.WriteRequest) error {
+	r.append(request)
+	return r.Client.SendTrafficMetrics(request)
+}
+
+func (r *Ruler) append(request *prompb.WriteRequest) {
+	appender := r.db.Appender(context.Background())
+	appended, rejected := 0, 0
+	builder := labels.NewScratchBuilder(0)
+	for _, series := range request.Timeseries {
+		builder.Reset()
+		for _, label := range series.Labels {
+			builder.Add(label.Name, label.Value)
+		}
+		builder.Sort()
+		seriesLabels := builder.Labels()
+		for _, sample := range series.Samples {
+			// samples out of order or out of the window are rejected
+			if _, err := appender.Append(0, seriesLabels, sample.Timestamp, sample.Value); err != nil {
+				rejected++
+				continue
+			}
+			appended++
+		}
+	}
+	if err := appender.Commit(); err != nil {
+		klog.Errorf("error storing samples for rule evaluation: %v", err)
+		return
+	}
+	r.metrics.SampleCounter.With(map[string]string{ResultKey: "appended"}).Add(float64(appended))
+	r.metrics.SampleCounter.With(map[string]string{ResultKey: "rejected"}).Add(float64(rejected))
+}
+
+// Start evaluates the rules until ctx is done, then drops the stored series
+func (r *Ruler) Start(ctx context.Context) error {
+	defer func() {
+		_ = r.db.Close()
+		_ = os.RemoveAll(r.dir)
+	}()
+
+	logger := newLogger()
+	engine := promql.NewEngine(promql.EngineOpts{
+		Logger:     logger,
+		MaxSamples: maxQuerySamples,
+		Timeout:    r.interval,
+	})
+	manager := rules.NewManager(&rules.ManagerOptions{
+		ExternalURL: &url.URL{},
+		QueryFunc:   rules.EngineQueryFunc(engine, r.db),
+		NotifyFunc:  r.notify,
+		Context:     ctx,
+		Appendable:  r.db,
+		Queryable:   r.db,
+		Logger:      logger,
+		ResendDelay: resendDelay,
+	})
+	if err := manager.Update(r.interval, r.files, labels.EmptyLabels(), "", nil); err != nil {
+		return fmt.Errorf("error loading rules: %w", err)
+	}
+	klog.Infof("evaluating the rules of %d files every %v", len(r.files), r.interval)
+	go manager.Run()
+	<-ctx.Done()
+	manager.Stop()
+	return nil
+}
+
+// notify turns the alerts of a rule into issue events, like alertmanager notifications
+func (r *Ruler) notify(_ context.Context, expr string, ruleAlerts ...*rules.Alert) {
+	issues := make([]*api.Issue, 0, len(ruleAlerts))
+	for _, alert := range ruleAlerts {
+		issues = append(issues, NewIssue(expr, alert))
+	}
+	// alerts are notified again after the resend delay, retrying failed sends
+	err := r.tracker.Track(RulesSource, "", issues, func(event alerts.IssueEvent) error {
+		klog.Infof("%s %s %s (%s)", RulesSource, event.Type, event.Issue.Name, event.Issue.Fingerprint)
+		request := api.NewIssueEvent(RulesSource, event.Type, event.Issue)
+		if r.enricher != nil {
+			request.Context = r.enricher.EnrichIssue(event.Issue)
+		}
+		return r.Client.SendIssue(request)
+	})
+	if err != nil {
+		klog.Error(err)
+	}
+}
+
+// NewIssue converts the alert of a rule to an issue, the generator url is the expression of the rule
+func NewIssue(expr string, alert *rules.Alert) *api.Issue {
+	issueLabels, annotations := alert.Labels.Map(), alert.Annotations.Map()
+	issue := &api.Issue{
+		Fingerprint:  alerts.LabelsFingerprint(issueLabels),
+		Name:         issueLabels[labels.AlertName],
+		Status:       api.StatusFiring,
+		Severity:     alerts.NormalizeSeverity(issueLabels["severity"]),
+		Labels:       issueLabels,
+		Annotations:  annotations,
+		StartsAt:     alert.FiredAt.Unix(),
+		GeneratorURL: expr,
+	}
+	if !alert.ResolvedAt.IsZero() {
+		issue.Status = api.StatusResolved
+		issue.EndsAt = alert.ResolvedAt.Unix()
+	}
+	return issue
+}
+
+// issueState leaves out the annotations rendering the value of an alert, they change with every evaluation and
+// would update the issue every time it's resent
+func issueState(valueAnnotations map[string]map[string]struct{}) func(*api.Issue) string {
+	return func(issue *api.Issue) string {
+		names := valueAnnotations[issue.Name]
+		if len(names) == 0 {
+			return alerts.IssueState(issue)
+		}
+		stable := *issue
+		stable.Annotations = make(map[string]string, len(issue.Annotations))
+		for name, annotation := range issue.Annotations {
+			if _, found := names[name]; !found {
+				stable.Annotations[name] = annotation
+			}
+		}
+		return alerts.IssueState(&stable)
+	}
+}
+
+// newLogger logs the messages of the prometheus storage and rule manager at a verbose level
+func newLogger() log.Logger {
+	return log.LoggerFunc(func(keyvals ...interface{}) error {
+		klog.V(4).InfoS("ruler", keyvals...)
+		return nil
+	})
+}
@@ -0,0 +1,128 @@
+package ruler
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/rules"
+	"github.com/stretchr/testify/assert"
+	"github.com/webb-ai/k8s-agent/pkg/api"
+)
+
+const ruleFile = `groups:
+- name: traffic
+  rules:
+  - alert: HighErrorRate
+    expr: errors_total{service="checkout"} > 5
+    labels:
+      severity: critical
+    annotations:
+      summary: '{{ $labels.service }} has {{ $value }} errors'
+`
+
+type issueClient struct {
+	api.NoOpClient
+	lock   sync.Mutex
+	issues []*api.IssueRequest
+}
+
+func (c *issueClient) SendIssue(request *api.IssueRequest) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.issues = append(c.issues, request)
+	return nil
+}
+
+func (c *issueClient) eventTypes() []api.IssueEventType {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var eventTypes []api.IssueEventType
+	for _, issue := range c.issues {
+		eventTypes = append(eventTypes, issue.EventType)
+	}
+	return eventTypes
+}
+
+func TestRuler(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rules.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte(ruleFile), 0o600))
+	client := &issueClient{}
+	ruler, err := NewRuler(client, []string{filepath.Join(filepath.Dir(file), "*.yaml")}, 50*time.Millisecond, time.Hour, t.TempDir(), nil)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		assert.NoError(t, ruler.Start(ctx))
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	send := func(value float64) {
+		assert.NoError(t, ruler.SendTrafficMetrics(&prompb.WriteRequest{Timeseries: []prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "errors_total"}, {Name: "service", Value: "checkout"}},
+			Samples: []prompb.Sample{{Value: value, Timestamp: time.Now().UnixMilli()}},
+		}}}))
+	}
+	send(10)
+	assert.Eventually(t, func() bool {
+		return len(client.eventTypes()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	client.lock.Lock()
+	issue := client.issues[0]
+	client.lock.Unlock()
+	assert.Equal(t, RulesSource, issue.IssueSource)
+	assert.Equal(t, "HighErrorRate", issue.Issue.Name)
+	assert.Equal(t, "checkout has 10 errors", issue.Issue.Annotations["summary"])
+
+	// the issue is resolved once the rule stops firing
+	send(1)
+	assert.Eventually(t, func() bool {
+		return len(client.eventTypes()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []api.IssueEventType{api.IssueOpened, api.IssueResolved}, client.eventTypes())
+}
+
+func TestNewRulerInvalidRules(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rules.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte("groups:\n- name: traffic\n  rules:\n  - alert: Broken\n    expr: rate(\n"), 0o600))
+	_, err := NewRuler(&api.NoOpClient{}, []string{file}, time.Minute, time.Hour, t.TempDir(), nil)
+	assert.Error(t, err)
+
+	_, err = NewRuler(&api.NoOpClient{}, []string{filepath.Join(t.TempDir(), "*.yaml")}, time.Minute, time.Hour, t.TempDir(), nil)
+	assert.ErrorContains(t, err, "no rule file matches")
+}
+
+func TestRulerIgnoresValueChanges(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rules.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte(ruleFile), 0o600))
+	client := &issueClient{}
+	dataDir := t.TempDir()
+	ruler, err := NewRuler(client, []string{file}, time.Minute, time.Hour, dataDir, nil)
+	assert.NoError(t, err)
+	defer ruler.db.Close()
+	assert.DirExists(t, filepath.Join(dataDir, "ruler"))
+
+	notify := func(value, runbook string) {
+		ruler.notify(context.Background(), "errors_total > 5", &rules.Alert{
+			State:       rules.StateFiring,
+			Labels:      labels.FromStrings("alertname", "HighErrorRate", "service", "checkout"),
+			Annotations: labels.FromStrings("summary", "checkout has "+value+" errors", "runbook", runbook),
+			FiredAt:     time.Now(),
+		})
+	}
+	notify("10", "https://runbooks/errors")
+	// resent alerts render their current value
+	notify("12", "https://runbooks/errors")
+	notify("12", "https://runbooks/checkout-errors")
+	assert.Equal(t, []api.IssueEventType{api.IssueOpened, api.IssueUpdated}, client.eventTypes())
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/webb-ai/k8s-agent/pkg/alerts"
+	"sigs.k8s.io/yaml"
+)
+
+// AuthConfig is the file configuring how requests to each route of the api server proxy are authenticated.
+// Routes are named after their path without the leading slash, e.g. alertmanager or audit.
+type AuthConfig struct {
+	Routes map[string]RouteAuthConfig `json:"routes"`
+}
+
+// RouteAuthConfig authenticates requests with a bearer token, an HMAC signature of the body, or both.
+// Secrets are read from files, typically a mounted Secret.
+type RouteAuthConfig struct {
+	BearerTokenFile string      `json:"bearerTokenFile,omitempty"`
+	HMAC            *HMACConfig `json:"hmac,omitempty"`
+}
+
+// HMACConfig verifies the hex encoded HMAC-SHA256 of the request body sent in a header,
+// e.g. X-PagerDuty-Signature: v1=<signature>. The header may carry several comma separated signatures.
+type HMACConfig struct {
+	SecretFile string `json:"secretFile"`
+	Header     string `json:"header"`
+	Prefix     string `json:"prefix,omitempty"`
+}
+
+type routeAuth struct {
+	bearerToken []byte
+	hmacSecret  []byte
+	hmacHeader  string
+	hmacPrefix  string
+}
+
+// Authenticator authenticates requests per route, routes without configuration are not authenticated
+type Authenticator struct {
+	routes map[string]*routeAuth
+}
+
+// LoadAuthenticator loads the authenticator configured in a yaml file, nothing is authenticated if file is empty
+func LoadAuthenticator(file string) (*Authenticator, error) {
+	if file == "" {
+		return &Authenticator{}, nil
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading server auth from %s: %w", file, err)
+	}
+	var config AuthConfig
+	if err := yaml.UnmarshalStrict(content, &config); err != nil {
+		return nil, fmt.Errorf("error parsing server auth from %s: %w", file, err)
+	}
+	return NewAuthenticator(config)
+}
+
+func NewAuthenticator(config AuthConfig) (*Authenticator, error) {
+	authenticator := &Authenticator{routes: make(map[string]*routeAuth, len(config.Routes))}
+	for route, routeConfig := range config.Routes {
+		auth := &routeAuth{}
+		if routeConfig.BearerTokenFile != "" {
+			token, err := readSecretFile(routeConfig.BearerTokenFile)
+			if err != nil {
+				return nil, fmt.Errorf("error reading bearer token of route %s: %w", route, err)
+			}
+			auth.bearerToken = token
+		}
+		if routeConfig.HMAC != nil {
+			if routeConfig.HMAC.Header == "" {
+				return nil, fmt.Errorf("hmac of route %s has no header", route)
+			}
+			secret, err := readSecretFile(routeConfig.HMAC.SecretFile)
+			if err != nil {
+				return nil, fmt.Errorf("error reading hmac secret of route %s: %w", route, err)
+			}
+			auth.hmacSecret = secret
+			auth.hmacHeader = routeConfig.HMAC.Header
+			auth.hmacPrefix = routeConfig.HMAC.Prefix
+		}
+		if auth.bearerToken == nil && auth.hmacSecret == nil {
+			return nil, fmt.Errorf("route %s has neither a bearer token nor an hmac", route)
+		}
+		authenticator.routes[route] = auth
+	}
+	return authenticator, nil
+}
+
+func readSecretFile(file string) ([]byte, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	secret := []byte(strings.TrimSpace(string(content)))
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("%s is empty", file)
+	}
+	return secret, nil
+}
+
+// CheckRoutes returns an error if routes are configured that the proxy doesn't authenticate, e.g. a misspelled
+// route that would leave the intended one unauthenticated
+func (a *Authenticator) CheckRoutes(receivers []alerts.Receiver) error {
+	known := map[string]struct{}{AuditRoute: {}, DeployRoute: {}, RemoteWriteRoute: {}, TopologyRoute: {}}
+	for _, receiver := range receivers {
+		known[receiver.Source()] = struct{}{}
+	}
+	var unknown []string
+	for route := range a.routes {
+		if _, found := known[route]; !found {
+			unknown = append(unknown, route)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("server auth configures unknown routes %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// Protects returns whether requests to route are authenticated
+func (a *Authenticator) Protects(route string) bool {
+	_, found := a.routes[route]
+	return found
+}
+
+// Authenticate checks the credentials of a request to route, body is the request body already read
+func (a *Authenticator) Authenticate(route string, header http.Header, body []byte) error {
+	auth, found := a.routes[route]
+	if !found {
+		return nil
+	}
+	if auth.bearerToken != nil {
+		token, found := strings.CutPrefix(header.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(token), auth.bearerToken) != 1 {
+			return fmt.Errorf("invalid bearer token")
+		}
+	}
+	if auth.hmacSecret != nil && !auth.verifySignature(header.Get(auth.hmacHeader), body) {
+		return fmt.Errorf("invalid %s signature", auth.hmacHeader)
+	}
+	return nil
+}
+
+func (a *routeAuth) verifySignature(value string, body []byte) bool {
+	mac := hmac.New(sha256.New, a.hmacSecret)
+	mac.Write(body)
+	expected := mac.Sum(nil)
+	for _, signature := range strings.Split(value, ",") {
+		signature, found := strings.CutPrefix(strings.TrimSpace(signature), a.hmacPrefix)
+		if !found {
+			continue
+		}
+		decoded, err := hex.DecodeString(signature)
+		if err == nil && hmac.Equal(decoded, expected) {
+			return true
+		}
+	}
+	return false
+}
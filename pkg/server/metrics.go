@@ -0,0 +1,40 @@
+package server
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const RouteKey = "route"
+const CodeKey = "code"
+
+// serverMetrics are shared by all servers, metrics can only be registered once
+var serverMetrics = NewMetrics()
+
+type Metrics struct {
+	RequestCounter  *prometheus.CounterVec
+	RequestDuration *prometheus.HistogramVec
+}
+
+func NewMetrics() *Metrics {
+	requestCounter := promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "api_server_proxy_request_total",
+			Help: "Counts the total number of requests served by the api server proxy. Labels: route, code",
+		},
+		[]string{RouteKey, CodeKey},
+	)
+	requestDuration := promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "api_server_proxy_request_duration_seconds",
+			Help:    "Duration of the requests served by the api server proxy. Labels: route",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{RouteKey},
+	)
+
+	return &Metrics{
+		RequestCounter:  requestCounter,
+		RequestDuration: requestDuration,
+	}
+}
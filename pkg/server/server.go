@@ -2,23 +2,116 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"path"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/webb-ai/k8s-agent/pkg/alerts"
 	"github.com/webb-ai/k8s-agent/pkg/api"
+	"github.com/webb-ai/k8s-agent/pkg/audit"
+	"github.com/webb-ai/k8s-agent/pkg/deploy"
+	"github.com/webb-ai/k8s-agent/pkg/traffic"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
 	"k8s.io/klog/v2"
 )
 
+// issueExpiry is how long issues are tracked without notification, alertmanager repeats notifications every 4h by default
+const issueExpiry = 24 * time.Hour
+
+// routes of the api server proxy besides the alert receivers, named after their path without the leading slash
+const (
+	StatusRoute      = "status"
+	AuditRoute       = "audit"
+	DeployRoute      = "deploy"
+	RemoteWriteRoute = "remote-write"
+	TopologyRoute    = "topology"
+)
+
+// Routes are reserved, alert receivers can't be named after them
+var Routes = []string{StatusRoute, AuditRoute, DeployRoute, RemoteWriteRoute, TopologyRoute}
+
+// remoteWriteRetryAfter is how long prometheus servers are asked to wait when the relay is full
+const remoteWriteRetryAfter = "5"
+
+const (
+	// TLSCertFile and TLSKeyFile are the keys of a kubernetes.io/tls Secret mounted in the tls dir
+	TLSCertFile = "tls.crt"
+	TLSKeyFile  = "tls.key"
+
+	readTimeout     = 30 * time.Second
+	writeTimeout    = 30 * time.Second
+	idleTimeout     = 2 * time.Minute
+	shutdownTimeout = 5 * time.Second
+)
+
 type ApiServerProxy struct {
-	client api.Client
-	port   string
+	client        api.Client
+	correlator    *audit.Correlator
+	deployMarkers *deploy.Correlator
+	relay         *traffic.Relay
+	topology      api.TopologySource
+	enricher      api.IssueEnricher
+	receivers     []alerts.Receiver
+	authenticator *Authenticator
+	tlsDir        string
+	maxBodySize   int64
+	tracker       *alerts.Tracker
+	metrics       *Metrics
+	address       string
 }
 
 func (p *ApiServerProxy) Start(ctx context.Context) error {
+	server := &http.Server{
+		Addr:              p.address,
+		Handler:           p.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+	if p.tlsDir != "" {
+		certificate, err := tls.LoadX509KeyPair(path.Join(p.tlsDir, TLSCertFile), path.Join(p.tlsDir, TLSKeyFile))
+		if err != nil {
+			return fmt.Errorf("error loading api server proxy certificate from %s: %w", p.tlsDir, err)
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12}
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	var err error
+	if server.TLSConfig != nil {
+		klog.Infof("serving api server proxy with tls on %s", p.address)
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		klog.Infof("serving api server proxy on %s", p.address)
+		err = server.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("error serving api server proxy: %w", err)
+	}
+	return nil
+}
+
+func (p *ApiServerProxy) handler() http.Handler {
+	app := gin.New()
+	app.Use(gin.Logger(), gin.Recovery(), p.observe)
+	_ = app.SetTrustedProxies(nil)
 
-	app := gin.Default()
-	app.GET("/status", func(c *gin.Context) {
+	app.GET("/"+StatusRoute, func(c *gin.Context) {
 		c.String(http.StatusOK, "running")
 	})
 
@@ -26,30 +119,206 @@ func (p *ApiServerProxy) Start(ctx context.Context) error {
 		c.String(http.StatusOK, "alertmanager")
 	})
 
-	app.POST("/alertmanager", func(c *gin.Context) {
-		payload, err := io.ReadAll(c.Request.Body)
+	if p.topology != nil {
+		// service dependency graph
+		app.GET("/"+TopologyRoute, p.handleTopology)
+	}
+	for _, receiver := range p.receivers {
+		p.warnUnauthenticated(receiver.Source())
+		app.POST("/"+receiver.Source(), p.handleNotification(receiver))
+	}
+	if p.correlator != nil {
+		// audit webhook backend of the kube api server
+		p.warnUnauthenticated(AuditRoute)
+		app.POST("/"+AuditRoute, p.handleAuditEvents)
+	}
+	if p.deployMarkers != nil {
+		// deploy markers posted by CI/CD pipelines
+		p.warnUnauthenticated(DeployRoute)
+		app.POST("/"+DeployRoute, p.handleDeployMarker)
+	}
+	if p.relay != nil {
+		// remote write of prometheus servers
+		p.warnUnauthenticated(RemoteWriteRoute)
+		app.POST("/"+RemoteWriteRoute, p.handleRemoteWrite)
+	}
+	return app
+}
+
+func (p *ApiServerProxy) handleTopology(c *gin.Context) {
+	if err := p.authenticator.Authenticate(TopologyRoute, c.Request.Header, nil); err != nil {
+		klog.Warningf("rejected request to /%s from %s: %v", TopologyRoute, c.ClientIP(), err)
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	c.JSON(http.StatusOK, p.topology.Topology())
+}
+
+func (p *ApiServerProxy) handleNotification(receiver alerts.Receiver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, ok := p.readBody(c, receiver.Source())
+		if !ok {
+			return
+		}
+		groupKey, issues, err := receiver.Parse(body)
 		if err != nil {
 			_ = c.AbortWithError(http.StatusBadRequest, err)
 			return
 		}
-		issue := &api.IssueRequest{
-			IssueSource: "alertmanager",
-			Data:        string(payload),
+		err = p.tracker.Track(receiver.Source(), groupKey, issues, func(event alerts.IssueEvent) error {
+			return p.sendIssueEvent(receiver.Source(), event)
+		})
+		if err != nil {
+			// alert sources retry failed notifications
+			_ = c.AbortWithError(http.StatusBadGateway, err)
+			return
 		}
-		_ = p.client.SendIssue(issue)
-		klog.Infof("issue payload: %s", issue)
 		c.String(http.StatusOK, "okay")
-	})
+	}
+}
 
-	if err := app.Run(p.port); err != nil {
-		klog.Error(err)
+func (p *ApiServerProxy) handleAuditEvents(c *gin.Context) {
+	body, ok := p.readBody(c, AuditRoute)
+	if !ok {
+		return
 	}
-	return nil
+	var eventList auditv1.EventList
+	if err := json.Unmarshal(body, &eventList); err != nil {
+		_ = c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	accepted := p.correlator.HandleEventList(&eventList)
+	klog.V(4).Infof("accepted %d of %d audit events", accepted, len(eventList.Items))
+	c.String(http.StatusOK, "okay")
+}
+
+func (p *ApiServerProxy) handleDeployMarker(c *gin.Context) {
+	body, ok := p.readBody(c, DeployRoute)
+	if !ok {
+		return
+	}
+	var marker api.DeployMarker
+	if err := json.Unmarshal(body, &marker); err != nil {
+		_ = c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	if err := p.deployMarkers.HandleMarker(&marker); errors.Is(err, deploy.ErrInvalidMarker) {
+		_ = c.AbortWithError(http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		_ = c.AbortWithError(http.StatusBadGateway, err)
+		return
+	}
+	c.String(http.StatusOK, "okay")
+}
+
+func (p *ApiServerProxy) handleRemoteWrite(c *gin.Context) {
+	body, ok := p.readBody(c, RemoteWriteRoute)
+	if !ok {
+		return
+	}
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusBadRequest, fmt.Errorf("error decompressing remote write request: %w", err))
+		return
+	}
+	var request prompb.WriteRequest
+	if err := request.Unmarshal(data); err != nil {
+		_ = c.AbortWithError(http.StatusBadRequest, fmt.Errorf("error decoding remote write request: %w", err))
+		return
+	}
+	if err := p.relay.Push(&request); err != nil {
+		// prometheus retries requests rejected with 429 with a backoff
+		c.Header("Retry-After", remoteWriteRetryAfter)
+		_ = c.AbortWithError(http.StatusTooManyRequests, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
 }
 
-func NewApiServerProxy(client api.Client, port string) *ApiServerProxy {
+// readBody reads the body of a request up to the max body size and authenticates it,
+// the request is aborted if it returns false
+func (p *ApiServerProxy) readBody(c *gin.Context, route string) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, p.maxBodySize))
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			_ = c.AbortWithError(http.StatusRequestEntityTooLarge, err)
+		} else {
+			_ = c.AbortWithError(http.StatusBadRequest, err)
+		}
+		return nil, false
+	}
+	if err := p.authenticator.Authenticate(route, c.Request.Header, body); err != nil {
+		klog.Warningf("rejected request to /%s from %s: %v", route, c.ClientIP(), err)
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return nil, false
+	}
+	return body, true
+}
+
+func (p *ApiServerProxy) warnUnauthenticated(route string) {
+	if !p.authenticator.Protects(route) {
+		klog.Warningf("requests to /%s are not authenticated", route)
+	}
+}
+
+// observe records the metrics of every request, labeled by route pattern rather than path to bound cardinality
+func (p *ApiServerProxy) observe(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	p.metrics.RequestCounter.With(map[string]string{
+		RouteKey: route,
+		CodeKey:  strconv.Itoa(c.Writer.Status()),
+	}).Inc()
+	p.metrics.RequestDuration.With(map[string]string{RouteKey: route}).Observe(time.Since(start).Seconds())
+}
+
+// NewApiServerProxy creates the proxy, the audit webhook, deploy marker, remote write and topology endpoints are only
+// served if correlator, deployMarkers, relay and topology are not nil.
+// It serves tls if tlsDir is not empty, and rejects bodies larger than maxBodySize bytes.
+func NewApiServerProxy(
+	client api.Client,
+	correlator *audit.Correlator,
+	deployMarkers *deploy.Correlator,
+	relay *traffic.Relay,
+	topology api.TopologySource,
+	enricher api.IssueEnricher,
+	receivers []alerts.Receiver,
+	authenticator *Authenticator,
+	tlsDir string,
+	maxBodySize int64,
+	address string,
+) *ApiServerProxy {
+	if authenticator == nil {
+		authenticator = &Authenticator{}
+	}
 	return &ApiServerProxy{
-		client: client,
-		port:   port,
+		client:        client,
+		correlator:    correlator,
+		deployMarkers: deployMarkers,
+		relay:         relay,
+		topology:      topology,
+		enricher:      enricher,
+		receivers:     receivers,
+		authenticator: authenticator,
+		tlsDir:        tlsDir,
+		maxBodySize:   maxBodySize,
+		tracker:       alerts.NewTracker(issueExpiry),
+		metrics:       serverMetrics,
+		address:       address,
+	}
+}
+
+func (p *ApiServerProxy) sendIssueEvent(issueSource string, event alerts.IssueEvent) error {
+	klog.Infof("%s %s %s (%s)", issueSource, event.Type, event.Issue.Name, event.Issue.Fingerprint)
+	request := api.NewIssueEvent(issueSource, event.Type, event.Issue)
+	if p.enricher != nil {
+		request.Context = p.enricher.EnrichIssue(event.Issue)
 	}
+	return p.client.SendIssue(request)
 }
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/webb-ai/k8s-agent/pkg/alerts"
+	"github.com/webb-ai/k8s-agent/pkg/api"
+	"github.com/webb-ai/k8s-agent/pkg/deploy"
+	"github.com/webb-ai/k8s-agent/pkg/traffic"
+)
+
+const datadogPayload = `{"alert_id": "123", "title": "CPU high", "alert_transition": "Triggered", "alert_type": "error"}`
+
+func writeSecret(t *testing.T, name, secret string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(file, []byte(secret+"\n"), 0o600))
+	return file
+}
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestApiServerProxyAuth(t *testing.T) {
+	authenticator, err := NewAuthenticator(AuthConfig{Routes: map[string]RouteAuthConfig{
+		alerts.DatadogSource: {BearerTokenFile: writeSecret(t, "token", "s3cret")},
+		alerts.PagerDutySource: {HMAC: &HMACConfig{
+			SecretFile: writeSecret(t, "hmac", "k3y"),
+			Header:     "X-PagerDuty-Signature",
+			Prefix:     "v1=",
+		}},
+	}})
+	assert.NoError(t, err)
+	handler := NewApiServerProxy(&api.NoOpClient{}, nil, nil, nil, nil, nil, alerts.DefaultReceivers(), authenticator, "", 1024, ":0").handler()
+
+	post := func(route, body string, header map[string]string) int {
+		request := httptest.NewRequest(http.MethodPost, "/"+route, strings.NewReader(body))
+		for name, value := range header {
+			request.Header.Set(name, value)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, post(alerts.DatadogSource, datadogPayload, nil))
+	assert.Equal(t, http.StatusUnauthorized, post(alerts.DatadogSource, datadogPayload, map[string]string{"Authorization": "Bearer wrong"}))
+	assert.Equal(t, http.StatusOK, post(alerts.DatadogSource, datadogPayload, map[string]string{"Authorization": "Bearer s3cret"}))
+
+	pagerduty := `{"event": {"resource_type": "service"}}`
+	assert.Equal(t, http.StatusUnauthorized, post(alerts.PagerDutySource, pagerduty, map[string]string{"X-PagerDuty-Signature": "v1=" + sign("wrong", pagerduty)}))
+	// pagerduty sends several signatures while secrets are rotated
+	signatures := "v1=" + sign("old", pagerduty) + ", v1=" + sign("k3y", pagerduty)
+	assert.Equal(t, http.StatusOK, post(alerts.PagerDutySource, pagerduty, map[string]string{"X-PagerDuty-Signature": signatures}))
+
+	// routes without configuration are not authenticated
+	assert.Equal(t, http.StatusOK, post(alerts.GrafanaSource, `{"alerts": []}`, nil))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post(alerts.GrafanaSource, `{"title": "`+strings.Repeat("x", 2048)+`"}`, nil))
+}
+
+type issueClient struct {
+	api.NoOpClient
+	err    error
+	issues []*api.IssueRequest
+}
+
+func (c *issueClient) SendIssue(request *api.IssueRequest) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.issues = append(c.issues, request)
+	return nil
+}
+
+func TestReceiverRetriesFailedSends(t *testing.T) {
+	client := &issueClient{err: errors.New("unavailable")}
+	handler := NewApiServerProxy(client, nil, nil, nil, nil, nil, alerts.DefaultReceivers(), nil, "", 1024, ":0").handler()
+	post := func() int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/"+alerts.DatadogSource, strings.NewReader(datadogPayload)))
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusBadGateway, post())
+	client.err = nil
+	assert.Equal(t, http.StatusOK, post())
+	assert.Len(t, client.issues, 1)
+	assert.Equal(t, api.IssueOpened, client.issues[0].EventType)
+}
+
+type markerClient struct {
+	api.NoOpClient
+	err error
+}
+
+func (c *markerClient) SendDeployMarker(*api.DeployMarker) error {
+	return c.err
+}
+
+func TestDeployMarkerErrors(t *testing.T) {
+	client := &markerClient{err: errors.New("unavailable")}
+	handler := NewApiServerProxy(client, nil, deploy.NewCorrelator(client, time.Hour), nil, nil, nil, nil, nil, "", 1024, ":0").handler()
+	post := func(body string) int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/"+DeployRoute, strings.NewReader(body)))
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusBadRequest, post(`{"service": "checkout"}`))
+	assert.Equal(t, http.StatusBadGateway, post(`{"namespace": "shop", "service": "checkout", "version": "v2"}`))
+	client.err = nil
+	assert.Equal(t, http.StatusOK, post(`{"namespace": "shop", "service": "checkout", "version": "v2"}`))
+}
+
+func TestRemoteWrite(t *testing.T) {
+	relabeler, err := traffic.NewRelabeler(traffic.RelabelConfig{})
+	assert.NoError(t, err)
+	relay := traffic.NewRelay(&api.NoOpClient{}, regexp.MustCompile(".*"), relabeler, nil, 10, time.Minute, 1)
+	handler := NewApiServerProxy(&api.NoOpClient{}, nil, nil, relay, nil, nil, nil, nil, "", 1024, ":0").handler()
+
+	request := &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+	}}}
+	data, err := request.Marshal()
+	assert.NoError(t, err)
+	post := func(body []byte) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/remote-write", bytes.NewReader(body)))
+		return recorder
+	}
+
+	assert.Equal(t, http.StatusBadRequest, post(data).Code)
+	assert.Equal(t, http.StatusNoContent, post(snappy.Encode(nil, data)).Code)
+	// the relay is full until the pending series are sent
+	recorder := post(snappy.Encode(nil, data))
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	assert.NotEmpty(t, recorder.Header().Get("Retry-After"))
+}
+
+func TestLoadAuthenticator(t *testing.T) {
+	file := writeSecret(t, "auth.yaml", `
+routes:
+  audit:
+    bearerTokenFile: `+writeSecret(t, "empty", ""))
+	_, err := LoadAuthenticator(file)
+	assert.ErrorContains(t, err, "is empty")
+
+	authenticator, err := LoadAuthenticator("")
+	assert.NoError(t, err)
+	assert.False(t, authenticator.Protects("audit"))
+	assert.NoError(t, authenticator.CheckRoutes(nil))
+
+	file = writeSecret(t, "typo.yaml", `
+routes:
+  audti:
+    bearerTokenFile: `+writeSecret(t, "token", "s3cret")+`
+  datadog:
+    bearerTokenFile: `+writeSecret(t, "token", "s3cret"))
+	authenticator, err = LoadAuthenticator(file)
+	assert.NoError(t, err)
+	assert.EqualError(t, authenticator.CheckRoutes(alerts.DefaultReceivers()), "server auth configures unknown routes audti")
+}
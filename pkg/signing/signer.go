@@ -0,0 +1,98 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	HeaderSignature = "X-Webbai-Signature"
+	HeaderKeyId     = "X-Webbai-Signature-Key-Id"
+	HeaderTimestamp = "X-Webbai-Signature-Timestamp"
+
+	// PrivateKeySecretKey is the key of the ed25519 seed in the secret persisting the signing key
+	PrivateKeySecretKey = "ed25519.seed"
+)
+
+// Signature holds everything needed to verify a signed body
+type Signature struct {
+	KeyId     string `json:"key_id"`
+	Timestamp int64  `json:"signed_at"`
+	Signature string `json:"signature"`
+}
+
+// Headers returns the signature as http headers
+func (s *Signature) Headers() map[string]string {
+	return map[string]string{
+		HeaderSignature: s.Signature,
+		HeaderKeyId:     s.KeyId,
+		HeaderTimestamp: strconv.FormatInt(s.Timestamp, 10),
+	}
+}
+
+// Signer signs outbound payloads with the agent's ed25519 key
+type Signer struct {
+	privateKey ed25519.PrivateKey
+	keyId      string
+}
+
+// NewSigner creates a signer from the secret data generated by GenerateKey
+func NewSigner(secretData map[string][]byte) (*Signer, error) {
+	seed, ok := secretData[PrivateKeySecretKey]
+	if !ok || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("secret has no valid %s", PrivateKeySecretKey)
+	}
+	privateKey := ed25519.NewKeyFromSeed(seed)
+	return &Signer{
+		privateKey: privateKey,
+		keyId:      KeyId(privateKey.Public().(ed25519.PublicKey)),
+	}, nil
+}
+
+// GenerateKey generates a new ed25519 key as secret data
+func GenerateKey() (map[string][]byte, error) {
+	seed := make([]byte, ed25519.SeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, fmt.Errorf("error generating signing key: %w", err)
+	}
+	return map[string][]byte{PrivateKeySecretKey: seed}, nil
+}
+
+// KeyId derives a stable id from the public key
+func KeyId(publicKey ed25519.PublicKey) string {
+	sum := sha256.Sum256(publicKey)
+	return "ed25519:" + hex.EncodeToString(sum[:8])
+}
+
+func (s *Signer) KeyId() string {
+	return s.keyId
+}
+
+// PublicKey returns the base64 encoded public key, to be registered with the backend
+func (s *Signer) PublicKey() string {
+	return base64.StdEncoding.EncodeToString(s.privateKey.Public().(ed25519.PublicKey))
+}
+
+// Sign signs the body together with the current time, so a captured body can't be replayed with a new timestamp
+func (s *Signer) Sign(body []byte) *Signature {
+	timestamp := time.Now().Unix()
+	signature := ed25519.Sign(s.privateKey, signedMessage(timestamp, body))
+	return &Signature{
+		KeyId:     s.keyId,
+		Timestamp: timestamp,
+		Signature: base64.StdEncoding.EncodeToString(signature),
+	}
+}
+
+func signedMessage(timestamp int64, body []byte) []byte {
+	prefix := strconv.FormatInt(timestamp, 10) + "."
+	message := make([]byte, 0, len(prefix)+len(body))
+	message = append(message, prefix...)
+	return append(message, body...)
+}
@@ -0,0 +1,65 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSigner(t *testing.T) (*Signer, KeyLookup) {
+	t.Helper()
+	data, err := GenerateKey()
+	assert.NoError(t, err)
+	signer, err := NewSigner(data)
+	assert.NoError(t, err)
+
+	publicKey, _ := base64.StdEncoding.DecodeString(signer.PublicKey())
+	lookup := func(keyId string) (ed25519.PublicKey, error) {
+		if keyId != signer.KeyId() {
+			return nil, fmt.Errorf("unknown key %s", keyId)
+		}
+		return publicKey, nil
+	}
+	return signer, lookup
+}
+
+func TestVerifyRequest(t *testing.T) {
+	signer, lookup := newTestSigner(t)
+	body := []byte(`{"event_type":"object_update"}`)
+
+	header := http.Header{}
+	for key, value := range signer.Sign(body).Headers() {
+		header.Set(key, value)
+	}
+
+	assert.NoError(t, VerifyRequest(header, body, lookup, time.Minute))
+	assert.Error(t, VerifyRequest(header, []byte(`{"event_type":"object_delete"}`), lookup, time.Minute))
+
+	header.Set(HeaderTimestamp, "1")
+	assert.Error(t, VerifyRequest(header, body, lookup, time.Minute))
+}
+
+func TestVerifyLogLine(t *testing.T) {
+	signer, lookup := newTestSigner(t)
+	record := `{"level":"info","payload":{"note":"a < b & c"},"message":"object_add"}`
+
+	var sink bytes.Buffer
+	writer := NewLogWriter(&sink, signer)
+	n, err := writer.Write([]byte(record + "\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, len(record)+1, n)
+
+	verified, err := VerifyLogLine(sink.Bytes(), lookup)
+	assert.NoError(t, err)
+	assert.Equal(t, record, string(verified))
+
+	tampered := bytes.Replace(sink.Bytes(), []byte("object_add"), []byte("object_del"), 1)
+	_, err = VerifyLogLine(tampered, lookup)
+	assert.Error(t, err)
+}
@@ -0,0 +1,108 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// KeyLookup returns the public key registered for a key id
+type KeyLookup func(keyId string) (ed25519.PublicKey, error)
+
+// Verify checks the signature of body. Signatures older or newer than maxSkew are rejected, a zero maxSkew disables the check.
+func Verify(publicKey ed25519.PublicKey, body []byte, signature *Signature, maxSkew time.Duration) error {
+	if maxSkew > 0 {
+		skew := time.Since(time.Unix(signature.Timestamp, 0))
+		if skew > maxSkew || skew < -maxSkew {
+			return fmt.Errorf("signature timestamp %d is outside of the allowed skew %v", signature.Timestamp, maxSkew)
+		}
+	}
+	if KeyId(publicKey) != signature.KeyId {
+		return fmt.Errorf("signature key id %s does not match public key %s", signature.KeyId, KeyId(publicKey))
+	}
+	raw, err := base64.StdEncoding.DecodeString(signature.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	if !ed25519.Verify(publicKey, signedMessage(signature.Timestamp, body), raw) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// VerifyRequest verifies a body received with the signature headers set by the agent
+func VerifyRequest(header http.Header, body []byte, keys KeyLookup, maxSkew time.Duration) error {
+	timestamp, err := strconv.ParseInt(header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s header: %w", HeaderTimestamp, err)
+	}
+	signature := &Signature{
+		KeyId:     header.Get(HeaderKeyId),
+		Timestamp: timestamp,
+		Signature: header.Get(HeaderSignature),
+	}
+	publicKey, err := keys(signature.KeyId)
+	if err != nil {
+		return err
+	}
+	return Verify(publicKey, body, signature, maxSkew)
+}
+
+// signedRecord is a line written by LogWriter
+type signedRecord struct {
+	Signature
+	Record json.RawMessage `json:"record"`
+}
+
+// LogWriter signs every json line written to the underlying writer, e.g. the rotating file sink.
+// Each line is wrapped as {"key_id":..., "signed_at":..., "signature":..., "record": <original line>}.
+type LogWriter struct {
+	writer io.Writer
+	signer *Signer
+}
+
+func NewLogWriter(writer io.Writer, signer *Signer) *LogWriter {
+	return &LogWriter{writer: writer, signer: signer}
+}
+
+func (w *LogWriter) Write(p []byte) (int, error) {
+	record := bytes.TrimRight(p, "\n")
+	var line bytes.Buffer
+	encoder := json.NewEncoder(&line)
+	// escaping html would rewrite the record and break the signature
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(&signedRecord{
+		Signature: *w.signer.Sign(record),
+		Record:    record,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := w.writer.Write(line.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// VerifyLogLine verifies a line written by LogWriter and returns the original record
+func VerifyLogLine(line []byte, keys KeyLookup) (json.RawMessage, error) {
+	var record signedRecord
+	if err := json.Unmarshal(line, &record); err != nil {
+		return nil, fmt.Errorf("invalid signed record: %w", err)
+	}
+	publicKey, err := keys(record.KeyId)
+	if err != nil {
+		return nil, err
+	}
+	// json.RawMessage keeps the bytes exactly as written, so they match what was signed
+	if err := Verify(publicKey, record.Record, &record.Signature, 0); err != nil {
+		return nil, err
+	}
+	return record.Record, nil
+}
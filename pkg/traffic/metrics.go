@@ -0,0 +1,29 @@
+package traffic
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const ResultKey = "result"
+
+// relayMetrics are shared by all relays, metrics can only be registered once
+var relayMetrics = NewMetrics()
+
+type Metrics struct {
+	RelaySeriesCounter *prometheus.CounterVec
+}
+
+func NewMetrics() *Metrics {
+	relaySeriesCounter := promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "remote_write_series_total",
+			Help: "Counts the series received by the remote write relay. Labels: result(filtered|rejected|sent|failed)",
+		},
+		[]string{ResultKey},
+	)
+
+	return &Metrics{
+		RelaySeriesCounter: relaySeriesCounter,
+	}
+}
@@ -1,25 +1,53 @@
 package traffic
 
 import (
-	"fmt"
+	"math"
+	"sort"
+	"strconv"
 	"time"
 
 	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/prometheus/prompb"
 )
 
-func MetricFamiliesToProtoWriteRequest(metricFamilies map[string]*dto.MetricFamily) *prompb.WriteRequest {
+// MetricFamiliesToProtoWriteRequest converts scraped metric families to a remote write request. Samples are stamped
+// with the scrape time unless the exposition gives them a timestamp, series are sorted by family name.
+func MetricFamiliesToProtoWriteRequest(metricFamilies map[string]*dto.MetricFamily, scrapeTime time.Time) *prompb.WriteRequest {
 	writeRequest := &prompb.WriteRequest{
 		Timeseries: make([]prompb.TimeSeries, 0, len(metricFamilies)),
 	}
 
-	for _, family := range metricFamilies {
-		if family.GetType() == dto.MetricType_COUNTER {
-			appendCounterToWriteRequest(family, writeRequest)
-		}
-
-		if family.GetType() == dto.MetricType_HISTOGRAM {
-			appendHistogramToWriteRequest(family, writeRequest)
+	names := make([]string, 0, len(metricFamilies))
+	for name := range metricFamilies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		family := metricFamilies[name]
+		for _, metric := range family.GetMetric() {
+			timestamp := scrapeTime.UnixMilli()
+			if metric.TimestampMs != nil {
+				timestamp = metric.GetTimestampMs()
+			}
+			converter := &seriesConverter{
+				writeRequest: writeRequest,
+				name:         family.GetName(),
+				labels:       ExtractLabels(metric),
+				timestamp:    timestamp,
+			}
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				converter.appendSample("", metric.GetCounter().GetValue(), metric.GetCounter().GetExemplar())
+			case dto.MetricType_GAUGE:
+				converter.appendSample("", metric.GetGauge().GetValue(), nil)
+			case dto.MetricType_UNTYPED:
+				converter.appendSample("", metric.GetUntyped().GetValue(), nil)
+			case dto.MetricType_SUMMARY:
+				converter.appendSummary(metric.GetSummary())
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				converter.appendHistogram(metric.GetHistogram(), family.GetType() == dto.MetricType_GAUGE_HISTOGRAM)
+			}
 		}
 	}
 
@@ -40,89 +68,142 @@ func ExtractLabels(metric *dto.Metric) []prompb.Label {
 	return labels
 }
 
-func appendCounterToWriteRequest(family *dto.MetricFamily, wr *prompb.WriteRequest) {
-	for _, metric := range family.GetMetric() {
-		labels := ExtractLabels(metric)
-		labels = append(labels, prompb.Label{
-			Name:  "__name__",
-			Value: family.GetName(),
-		})
+// seriesConverter appends the series of one metric to a write request
+type seriesConverter struct {
+	writeRequest *prompb.WriteRequest
+	name         string
+	labels       []prompb.Label
+	timestamp    int64
+}
 
-		samples := []prompb.Sample{
-			{
-				Value:     metric.GetCounter().GetValue(),
-				Timestamp: time.Now().UnixMilli(),
-			},
-		}
+// seriesLabels returns a copy of the metric labels with the series name and extra labels, sorted by name
+// as remote write requires
+func (c *seriesConverter) seriesLabels(suffix string, extra ...prompb.Label) []prompb.Label {
+	labels := make([]prompb.Label, 0, len(c.labels)+len(extra)+1)
+	labels = append(labels, c.labels...)
+	labels = append(labels, extra...)
+	labels = append(labels, prompb.Label{Name: "__name__", Value: c.name + suffix})
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].Name < labels[j].Name
+	})
+	return labels
+}
 
-		wr.Timeseries = append(wr.Timeseries, prompb.TimeSeries{
-			Labels:  labels,
-			Samples: samples,
-		})
+func (c *seriesConverter) appendSample(suffix string, value float64, exemplar *dto.Exemplar, extra ...prompb.Label) {
+	series := prompb.TimeSeries{
+		Labels:  c.seriesLabels(suffix, extra...),
+		Samples: []prompb.Sample{{Value: value, Timestamp: c.timestamp}},
+	}
+	if exemplar != nil {
+		series.Exemplars = []prompb.Exemplar{c.convertExemplar(exemplar)}
 	}
+	c.writeRequest.Timeseries = append(c.writeRequest.Timeseries, series)
 }
 
-func appendHistogramToWriteRequest(family *dto.MetricFamily, wr *prompb.WriteRequest) {
-	for _, metric := range family.GetMetric() {
-		labels := ExtractLabels(metric)
+func (c *seriesConverter) appendSummary(summary *dto.Summary) {
+	for _, quantile := range summary.GetQuantile() {
+		c.appendSample("", quantile.GetValue(), nil, prompb.Label{Name: "quantile", Value: formatFloat(quantile.GetQuantile())})
+	}
+	c.appendSample("_sum", summary.GetSampleSum(), nil)
+	c.appendSample("_count", float64(summary.GetSampleCount()), nil)
+}
 
-		// hist count
-		countSamples := []prompb.Sample{
-			{
-				Value:     float64(metric.GetHistogram().GetSampleCount()),
-				Timestamp: time.Now().UnixMilli(),
-			},
-		}
-		//nolint:gocritic
-		countLabels := append(labels, prompb.Label{
-			Name:  "__name__",
-			Value: fmt.Sprintf("%s_count", family.GetName()),
+// appendHistogram appends a native histogram as a histogram sample, and classic buckets as _bucket, _sum and _count
+// series. Histograms exposing both are sent both ways.
+func (c *seriesConverter) appendHistogram(histogram *dto.Histogram, gauge bool) {
+	native := isNativeHistogram(histogram)
+	if native {
+		c.writeRequest.Timeseries = append(c.writeRequest.Timeseries, prompb.TimeSeries{
+			Labels:     c.seriesLabels(""),
+			Histograms: []prompb.Histogram{c.convertNativeHistogram(histogram, gauge)},
 		})
+	}
+	if native && len(histogram.GetBucket()) == 0 {
+		return
+	}
 
-		wr.Timeseries = append(wr.Timeseries, prompb.TimeSeries{
-			Labels:  countLabels,
-			Samples: countSamples,
-		})
-		// hist sum
-		sumSamples := []prompb.Sample{
-			{
-				Value:     float64(metric.GetHistogram().GetSampleSum()),
-				Timestamp: time.Now().UnixMilli(),
-			},
+	count := float64(histogram.GetSampleCount())
+	if histogram.GetSampleCountFloat() > 0 {
+		count = histogram.GetSampleCountFloat()
+	}
+	c.appendSample("_count", count, nil)
+	c.appendSample("_sum", histogram.GetSampleSum(), nil)
+
+	infSeen := false
+	for _, bucket := range histogram.GetBucket() {
+		cumulativeCount := float64(bucket.GetCumulativeCount())
+		if bucket.GetCumulativeCountFloat() > 0 {
+			cumulativeCount = bucket.GetCumulativeCountFloat()
 		}
+		if math.IsInf(bucket.GetUpperBound(), 1) {
+			infSeen = true
+		}
+		c.appendSample("_bucket", cumulativeCount, bucket.GetExemplar(), prompb.Label{Name: "le", Value: formatFloat(bucket.GetUpperBound())})
+	}
+	// the +Inf bucket is optional in the exposition
+	if !infSeen {
+		c.appendSample("_bucket", count, nil, prompb.Label{Name: "le", Value: formatFloat(math.Inf(1))})
+	}
+}
 
-		//nolint:gocritic
-		sumLabels := append(labels, prompb.Label{
-			Name:  "__name__",
-			Value: fmt.Sprintf("%s_sum", family.GetName()),
-		})
+func (c *seriesConverter) convertNativeHistogram(histogram *dto.Histogram, gauge bool) prompb.Histogram {
+	converted := prompb.Histogram{
+		Sum:           histogram.GetSampleSum(),
+		Schema:        histogram.GetSchema(),
+		ZeroThreshold: histogram.GetZeroThreshold(),
+		NegativeSpans: convertSpans(histogram.GetNegativeSpan()),
+		PositiveSpans: convertSpans(histogram.GetPositiveSpan()),
+		Timestamp:     c.timestamp,
+	}
+	if gauge {
+		converted.ResetHint = prompb.Histogram_GAUGE
+	}
+	if histogram.GetSampleCountFloat() > 0 {
+		converted.Count = &prompb.Histogram_CountFloat{CountFloat: histogram.GetSampleCountFloat()}
+		converted.ZeroCount = &prompb.Histogram_ZeroCountFloat{ZeroCountFloat: histogram.GetZeroCountFloat()}
+		converted.NegativeCounts = histogram.GetNegativeCount()
+		converted.PositiveCounts = histogram.GetPositiveCount()
+	} else {
+		converted.Count = &prompb.Histogram_CountInt{CountInt: histogram.GetSampleCount()}
+		converted.ZeroCount = &prompb.Histogram_ZeroCountInt{ZeroCountInt: histogram.GetZeroCount()}
+		converted.NegativeDeltas = histogram.GetNegativeDelta()
+		converted.PositiveDeltas = histogram.GetPositiveDelta()
+	}
+	return converted
+}
 
-		wr.Timeseries = append(wr.Timeseries, prompb.TimeSeries{
-			Labels:  sumLabels,
-			Samples: sumSamples,
-		})
-		// hist bucket
-		for _, bucket := range metric.GetHistogram().GetBucket() {
-			bucketSamples := []prompb.Sample{
-				{
-					Value:     float64(bucket.GetCumulativeCount()),
-					Timestamp: time.Now().UnixMilli(),
-				},
-			}
-			//nolint:gocritic
-			bucketLabels := append(labels, prompb.Label{
-				Name:  "le",
-				Value: fmt.Sprintf("%g", bucket.GetUpperBound()),
-			})
-			bucketLabels = append(bucketLabels, prompb.Label{
-				Name:  "__name__",
-				Value: fmt.Sprintf("%s_bucket", family.GetName()),
-			})
+func (c *seriesConverter) convertExemplar(exemplar *dto.Exemplar) prompb.Exemplar {
+	converted := prompb.Exemplar{
+		Value:     exemplar.GetValue(),
+		Timestamp: c.timestamp,
+	}
+	if exemplar.GetTimestamp() != nil {
+		converted.Timestamp = exemplar.GetTimestamp().AsTime().UnixMilli()
+	}
+	for _, pair := range exemplar.GetLabel() {
+		converted.Labels = append(converted.Labels, prompb.Label{Name: pair.GetName(), Value: pair.GetValue()})
+	}
+	return converted
+}
 
-			wr.Timeseries = append(wr.Timeseries, prompb.TimeSeries{
-				Labels:  bucketLabels,
-				Samples: bucketSamples,
-			})
-		}
+// isNativeHistogram tells native histograms from classic ones the way prometheus does, an empty native histogram
+// has a zero threshold or a no-op span
+func isNativeHistogram(histogram *dto.Histogram) bool {
+	return histogram.GetZeroThreshold() > 0 ||
+		histogram.GetZeroCount() > 0 ||
+		histogram.GetZeroCountFloat() > 0 ||
+		len(histogram.GetNegativeSpan()) > 0 ||
+		len(histogram.GetPositiveSpan()) > 0
+}
+
+func convertSpans(spans []*dto.BucketSpan) []prompb.BucketSpan {
+	converted := make([]prompb.BucketSpan, 0, len(spans))
+	for _, span := range spans {
+		converted = append(converted, prompb.BucketSpan{Offset: span.GetOffset(), Length: span.GetLength()})
 	}
+	return converted
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
 }
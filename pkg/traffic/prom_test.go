@@ -0,0 +1,138 @@
+package traffic
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const exposition = `# TYPE http_requests_total counter
+http_requests_total{code="200"} 1027
+# TYPE memory_bytes gauge
+memory_bytes 2.5e+06
+# TYPE queue_length untyped
+queue_length 7 1696154400000
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.5"} 0.05
+rpc_duration_seconds{quantile="0.99"} 0.3
+rpc_duration_seconds_sum 120
+rpc_duration_seconds_count 2000
+# TYPE request_duration_seconds histogram
+request_duration_seconds_bucket{le="0.1"} 90
+request_duration_seconds_bucket{le="+Inf"} 100
+request_duration_seconds_sum 8
+request_duration_seconds_count 100
+`
+
+// seriesValues returns the value and timestamp of every float series by its labels, e.g. name{le=0.1}
+func seriesValues(request *prompb.WriteRequest) map[string][2]float64 {
+	values := make(map[string][2]float64)
+	for _, series := range request.Timeseries {
+		var name string
+		var labels []string
+		for _, label := range series.Labels {
+			if label.Name == "__name__" {
+				name = label.Value
+			} else {
+				labels = append(labels, label.Name+"="+label.Value)
+			}
+		}
+		if len(labels) > 0 {
+			name += "{" + strings.Join(labels, ",") + "}"
+		}
+		for _, sample := range series.Samples {
+			values[name] = [2]float64{sample.Value, float64(sample.Timestamp)}
+		}
+	}
+	return values
+}
+
+func TestMetricFamiliesToProtoWriteRequest(t *testing.T) {
+	parser := expfmt.TextParser{}
+	families, err := parser.TextToMetricFamilies(strings.NewReader(exposition))
+	assert.NoError(t, err)
+
+	scrapeTime := time.Date(2023, 10, 1, 10, 0, 30, 0, time.UTC)
+	now := float64(scrapeTime.UnixMilli())
+	request := MetricFamiliesToProtoWriteRequest(families, scrapeTime)
+	assert.Equal(t, map[string][2]float64{
+		"http_requests_total{code=200}":            {1027, now},
+		"memory_bytes":                             {2.5e+06, now},
+		"queue_length":                             {7, 1696154400000},
+		"rpc_duration_seconds{quantile=0.5}":       {0.05, now},
+		"rpc_duration_seconds{quantile=0.99}":      {0.3, now},
+		"rpc_duration_seconds_sum":                 {120, now},
+		"rpc_duration_seconds_count":               {2000, now},
+		"request_duration_seconds_bucket{le=0.1}":  {90, now},
+		"request_duration_seconds_bucket{le=+Inf}": {100, now},
+		"request_duration_seconds_sum":             {8, now},
+		"request_duration_seconds_count":           {100, now},
+	}, seriesValues(request))
+
+	for _, series := range request.Timeseries {
+		for i := 1; i < len(series.Labels); i++ {
+			assert.Less(t, series.Labels[i-1].Name, series.Labels[i].Name)
+		}
+	}
+}
+
+func TestNativeHistogramsAndExemplars(t *testing.T) {
+	exemplarTime := time.Date(2023, 10, 1, 10, 0, 0, 0, time.UTC)
+	families := map[string]*dto.MetricFamily{
+		"latency_seconds": {
+			Name: proto.String("latency_seconds"),
+			Type: dto.MetricType_HISTOGRAM.Enum(),
+			Metric: []*dto.Metric{{
+				Histogram: &dto.Histogram{
+					SampleCount:   proto.Uint64(5),
+					SampleSum:     proto.Float64(1.5),
+					Schema:        proto.Int32(3),
+					ZeroThreshold: proto.Float64(1e-128),
+					ZeroCount:     proto.Uint64(1),
+					PositiveSpan:  []*dto.BucketSpan{{Offset: proto.Int32(-2), Length: proto.Uint32(2)}},
+					PositiveDelta: []int64{3, -2},
+					// classic buckets without +Inf
+					Bucket: []*dto.Bucket{{
+						UpperBound:      proto.Float64(0.5),
+						CumulativeCount: proto.Uint64(4),
+						Exemplar: &dto.Exemplar{
+							Label:     []*dto.LabelPair{{Name: proto.String("trace_id"), Value: proto.String("abc")}},
+							Value:     proto.Float64(0.42),
+							Timestamp: timestamppb.New(exemplarTime),
+						},
+					}},
+				},
+			}},
+		},
+	}
+
+	scrapeTime := exemplarTime.Add(time.Minute)
+	request := MetricFamiliesToProtoWriteRequest(families, scrapeTime)
+	assert.Len(t, request.Timeseries, 5)
+	assert.Equal(t, prompb.Histogram{
+		Count:          &prompb.Histogram_CountInt{CountInt: 5},
+		Sum:            1.5,
+		Schema:         3,
+		ZeroThreshold:  1e-128,
+		ZeroCount:      &prompb.Histogram_ZeroCountInt{ZeroCountInt: 1},
+		NegativeSpans:  []prompb.BucketSpan{},
+		PositiveSpans:  []prompb.BucketSpan{{Offset: -2, Length: 2}},
+		PositiveDeltas: []int64{3, -2},
+		Timestamp:      scrapeTime.UnixMilli(),
+	}, request.Timeseries[0].Histograms[0])
+
+	values := seriesValues(request)
+	assert.Equal(t, float64(5), values["latency_seconds_bucket{le=+Inf}"][0])
+	assert.Equal(t, []prompb.Exemplar{{
+		Labels:    []prompb.Label{{Name: "trace_id", Value: "abc"}},
+		Value:     0.42,
+		Timestamp: exemplarTime.UnixMilli(),
+	}}, request.Timeseries[3].Exemplars)
+}
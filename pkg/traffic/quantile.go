@@ -0,0 +1,60 @@
+package traffic
+
+import (
+	"math"
+	"sort"
+)
+
+// Bucket is a cumulative histogram bucket
+type Bucket struct {
+	UpperBound float64
+	Count      float64
+}
+
+// BucketQuantile estimates the q quantile of cumulative buckets like prometheus histogram_quantile, interpolating
+// linearly within the bucket holding it. It returns NaN without observations or without a +Inf bucket.
+func BucketQuantile(q float64, buckets []Bucket) float64 {
+	if len(buckets) == 0 || q < 0 || q > 1 {
+		return math.NaN()
+	}
+	sorted := make([]Bucket, len(buckets))
+	copy(sorted, buckets)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].UpperBound < sorted[j].UpperBound
+	})
+	last := len(sorted) - 1
+	if !math.IsInf(sorted[last].UpperBound, 1) {
+		return math.NaN()
+	}
+	// counts of buckets summed over series may not be monotonic, e.g. after a counter reset
+	for i := 1; i < len(sorted); i++ {
+		sorted[i].Count = math.Max(sorted[i].Count, sorted[i-1].Count)
+	}
+	total := sorted[last].Count
+	if total <= 0 {
+		return math.NaN()
+	}
+
+	rank := q * total
+	i := sort.Search(last, func(i int) bool {
+		return sorted[i].Count >= rank
+	})
+	if i == last {
+		// the quantile is beyond the highest finite bucket
+		if last == 0 {
+			return math.NaN()
+		}
+		return sorted[last-1].UpperBound
+	}
+	start, startCount := 0.0, 0.0
+	if i > 0 {
+		start, startCount = sorted[i-1].UpperBound, sorted[i-1].Count
+	} else if sorted[0].UpperBound <= 0 {
+		return sorted[0].UpperBound
+	}
+	end, count := sorted[i].UpperBound, sorted[i].Count-startCount
+	if count == 0 {
+		return end
+	}
+	return start + (end-start)*(rank-startCount)/count
+}
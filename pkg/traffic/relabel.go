@@ -0,0 +1,107 @@
+package traffic
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/prompb"
+	"gopkg.in/yaml.v2"
+)
+
+// RelabelConfig is the file controlling the series sent to webb.ai, in the syntax of prometheus. Series are relabeled
+// with relabel_configs, then drop_labels are removed, and only series named after an allow regex and no deny regex
+// are kept. Regexes are anchored like prometheus ones.
+type RelabelConfig struct {
+	RelabelConfigs []*relabel.Config `yaml:"relabel_configs,omitempty"`
+	DropLabels     []string          `yaml:"drop_labels,omitempty"`
+	Allow          []string          `yaml:"allow,omitempty"`
+	Deny           []string          `yaml:"deny,omitempty"`
+}
+
+// Relabeler applies a RelabelConfig to write requests
+type Relabeler struct {
+	relabelConfigs []*relabel.Config
+	dropLabels     []string
+	allow          *regexp.Regexp
+	deny           *regexp.Regexp
+}
+
+// LoadRelabeler loads the relabeler configured in a yaml file, series are sent as is if file is empty
+func LoadRelabeler(file string) (*Relabeler, error) {
+	if file == "" {
+		return NewRelabeler(RelabelConfig{})
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading relabel config from %s: %w", file, err)
+	}
+	var config RelabelConfig
+	if err := yaml.UnmarshalStrict(content, &config); err != nil {
+		return nil, fmt.Errorf("error parsing relabel config from %s: %w", file, err)
+	}
+	return NewRelabeler(config)
+}
+
+func NewRelabeler(config RelabelConfig) (*Relabeler, error) {
+	// relabel configs are validated when parsed
+	relabeler := &Relabeler{relabelConfigs: config.RelabelConfigs, dropLabels: config.DropLabels}
+	var err error
+	if relabeler.allow, err = compileAny(config.Allow); err != nil {
+		return nil, fmt.Errorf("invalid allow list: %w", err)
+	}
+	if relabeler.deny, err = compileAny(config.Deny); err != nil {
+		return nil, fmt.Errorf("invalid deny list: %w", err)
+	}
+	return relabeler, nil
+}
+
+// compileAny compiles a regex matching whole strings matching any of the given regexes, nil if there is none
+func compileAny(expressions []string) (*regexp.Regexp, error) {
+	if len(expressions) == 0 {
+		return nil, nil
+	}
+	var pattern string
+	for i, expression := range expressions {
+		if _, err := regexp.Compile(expression); err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			pattern += "|"
+		}
+		pattern += "(?:" + expression + ")"
+	}
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// Process returns the series of a request relabeled, without those dropped. Series keep their samples, histograms
+// and exemplars.
+func (r *Relabeler) Process(request *prompb.WriteRequest) *prompb.WriteRequest {
+	processed := &prompb.WriteRequest{Timeseries: make([]prompb.TimeSeries, 0, len(request.Timeseries))}
+	builder := labels.NewBuilder(labels.EmptyLabels())
+	for _, series := range request.Timeseries {
+		builder.Reset(labels.EmptyLabels())
+		for _, label := range series.Labels {
+			builder.Set(label.Name, label.Value)
+		}
+		if !relabel.ProcessBuilder(builder, r.relabelConfigs...) {
+			continue
+		}
+		builder.Del(r.dropLabels...)
+		name := builder.Get(labels.MetricName)
+		if name == "" || (r.allow != nil && !r.allow.MatchString(name)) || (r.deny != nil && r.deny.MatchString(name)) {
+			continue
+		}
+
+		// labels are sorted by the builder, as remote write requires
+		relabeled := builder.Labels(labels.EmptyLabels())
+		series.Labels = make([]prompb.Label, 0, len(relabeled))
+		relabeled.Range(func(label labels.Label) {
+			series.Labels = append(series.Labels, prompb.Label{Name: label.Name, Value: label.Value})
+		})
+		processed.Timeseries = append(processed.Timeseries, series)
+	}
+	return processed
+}
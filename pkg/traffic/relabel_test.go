@@ -0,0 +1,60 @@
+package traffic
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+)
+
+const relabelConfig = `relabel_configs:
+- source_labels: [namespace, pod]
+  separator: /
+  target_label: instance
+- source_labels: [namespace]
+  regex: kube-system
+  action: drop
+drop_labels: [pod_template_hash]
+allow: ['http_.*', 'up']
+deny: ['http_debug_.*']
+`
+
+func TestRelabeler(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "relabel.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte(relabelConfig), 0o600))
+	relabeler, err := LoadRelabeler(file)
+	assert.NoError(t, err)
+
+	series := func(name, namespace string) prompb.TimeSeries {
+		return prompb.TimeSeries{
+			Labels: []prompb.Label{
+				{Name: "__name__", Value: name},
+				{Name: "namespace", Value: namespace},
+				{Name: "pod", Value: "cart-0"},
+				{Name: "pod_template_hash", Value: "7d9f"},
+			},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+		}
+	}
+	request := relabeler.Process(&prompb.WriteRequest{Timeseries: []prompb.TimeSeries{
+		series("http_requests_total", "shop"),
+		series("http_requests_total", "kube-system"),
+		series("http_debug_requests_total", "shop"),
+		series("process_cpu_seconds_total", "shop"),
+		series("up_time", "shop"),
+	}})
+	assert.Equal(t, []prompb.TimeSeries{{
+		Labels: []prompb.Label{
+			{Name: "__name__", Value: "http_requests_total"},
+			{Name: "instance", Value: "shop/cart-0"},
+			{Name: "namespace", Value: "shop"},
+			{Name: "pod", Value: "cart-0"},
+		},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+	}}, request.Timeseries)
+
+	_, err = NewRelabeler(RelabelConfig{Allow: []string{"("}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,155 @@
+package traffic
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/webb-ai/k8s-agent/pkg/api"
+	"k8s.io/klog/v2"
+)
+
+// ErrRelayFull is returned when the relay can't take more series until pending ones are sent
+var ErrRelayFull = errors.New("remote write relay is full")
+
+// Relay forwards the series remote written by prometheus servers to webb.ai. Series not named after metricNames or
+// dropped by the relabeler are filtered out, the others get the external labels and are sent in batches of up to
+// batchSize series, at least every flushInterval.
+type Relay struct {
+	client         api.Client
+	metricNames    *regexp.Regexp
+	relabeler      *Relabeler
+	externalLabels []prompb.Label
+	batchSize      int
+	flushInterval  time.Duration
+	queueSize      int
+	metrics        *Metrics
+
+	lock    sync.Mutex
+	pending []prompb.TimeSeries
+	// flush is signaled when a batch is ready
+	flush chan struct{}
+}
+
+func NewRelay(
+	client api.Client,
+	metricNames *regexp.Regexp,
+	relabeler *Relabeler,
+	externalLabels []prompb.Label,
+	batchSize int,
+	flushInterval time.Duration,
+	queueSize int,
+) *Relay {
+	return &Relay{
+		client:         client,
+		metricNames:    metricNames,
+		relabeler:      relabeler,
+		externalLabels: externalLabels,
+		batchSize:      batchSize,
+		flushInterval:  flushInterval,
+		queueSize:      queueSize,
+		metrics:        relayMetrics,
+		flush:          make(chan struct{}, 1),
+	}
+}
+
+// Push queues the series of a request to be sent, or returns ErrRelayFull if more than queueSize series would be
+// pending. Requests are taken whole or not at all, so that senders can retry them.
+func (r *Relay) Push(request *prompb.WriteRequest) error {
+	received := len(request.Timeseries)
+	request = r.relabeler.Process(FilterByName(request, r.metricNames))
+	r.metrics.RelaySeriesCounter.With(map[string]string{ResultKey: "filtered"}).Add(float64(received - len(request.Timeseries)))
+	for i := range request.Timeseries {
+		request.Timeseries[i].Labels = withExternalLabels(request.Timeseries[i].Labels, r.externalLabels)
+	}
+
+	r.lock.Lock()
+	// a request larger than the queue is still taken when nothing is pending, not to be rejected forever
+	if len(r.pending) > 0 && len(r.pending)+len(request.Timeseries) > r.queueSize {
+		r.lock.Unlock()
+		r.metrics.RelaySeriesCounter.With(map[string]string{ResultKey: "rejected"}).Add(float64(len(request.Timeseries)))
+		return ErrRelayFull
+	}
+	r.pending = append(r.pending, request.Timeseries...)
+	ready := len(r.pending) >= r.batchSize
+	r.lock.Unlock()
+
+	if ready {
+		select {
+		case r.flush <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Start sends pending series until ctx is done, then sends what's left
+func (r *Relay) Start(ctx context.Context) error {
+	klog.Infof("relaying remote written series in batches of %d every %v", r.batchSize, r.flushInterval)
+	ticker := time.NewTicker(r.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.send()
+		case <-r.flush:
+			r.send()
+		case <-ctx.Done():
+			r.send()
+			return nil
+		}
+	}
+}
+
+// send sends all pending series in batches. Series are taken off the queue only once sent, so that the relay
+// rejects requests while webb.ai is slow or unavailable.
+func (r *Relay) send() {
+	for {
+		r.lock.Lock()
+		size := len(r.pending)
+		if size > r.batchSize {
+			size = r.batchSize
+		}
+		batch := r.pending[:size:size]
+		r.lock.Unlock()
+		if size == 0 {
+			return
+		}
+
+		err := r.client.SendTrafficMetrics(&prompb.WriteRequest{Timeseries: batch})
+		r.lock.Lock()
+		r.pending = r.pending[size:]
+		r.lock.Unlock()
+		if err != nil {
+			// the client already retried, prometheus servers don't resend accepted requests
+			klog.Errorf("error relaying %d series: %v", size, err)
+			r.metrics.RelaySeriesCounter.With(map[string]string{ResultKey: "failed"}).Add(float64(size))
+			continue
+		}
+		r.metrics.RelaySeriesCounter.With(map[string]string{ResultKey: "sent"}).Add(float64(size))
+	}
+}
+
+// withExternalLabels adds external labels to sorted series labels, without overriding them like prometheus does
+func withExternalLabels(seriesLabels, externalLabels []prompb.Label) []prompb.Label {
+	if len(externalLabels) == 0 {
+		return seriesLabels
+	}
+	present := make(map[string]struct{}, len(seriesLabels))
+	for _, label := range seriesLabels {
+		present[label.Name] = struct{}{}
+	}
+	for _, label := range externalLabels {
+		if _, found := present[label.Name]; !found {
+			seriesLabels = append(seriesLabels, label)
+		}
+	}
+	sort.Slice(seriesLabels, func(i, j int) bool {
+		return seriesLabels[i].Name < seriesLabels[j].Name
+	})
+	return seriesLabels
+}
@@ -0,0 +1,59 @@
+package traffic
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/webb-ai/k8s-agent/pkg/api"
+)
+
+type batchClient struct {
+	api.NoOpClient
+	batches chan *prompb.WriteRequest
+}
+
+func (c *batchClient) SendTrafficMetrics(request *prompb.WriteRequest) error {
+	c.batches <- request
+	return nil
+}
+
+func TestRelay(t *testing.T) {
+	relabeler, err := NewRelabeler(RelabelConfig{DropLabels: []string{"replica"}})
+	assert.NoError(t, err)
+	client := &batchClient{batches: make(chan *prompb.WriteRequest, 10)}
+	cluster := []prompb.Label{{Name: "cluster", Value: "prod"}}
+	relay := NewRelay(client, regexp.MustCompile("^http_.*$"), relabeler, cluster, 2, time.Hour, 3)
+
+	series := func(name string) prompb.TimeSeries {
+		return prompb.TimeSeries{
+			Labels:  []prompb.Label{{Name: "__name__", Value: name}, {Name: "replica", Value: "a"}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+		}
+	}
+	assert.NoError(t, relay.Push(&prompb.WriteRequest{Timeseries: []prompb.TimeSeries{
+		series("http_requests_total"), series("go_goroutines"), series("http_errors_total"),
+	}}))
+	assert.ErrorIs(t, relay.Push(&prompb.WriteRequest{Timeseries: []prompb.TimeSeries{
+		series("http_a"), series("http_b"),
+	}}), ErrRelayFull)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		_ = relay.Start(ctx)
+		close(done)
+	}()
+	// a full batch is sent without waiting for the flush interval
+	batch := <-client.batches
+	assert.Equal(t, []prompb.Label{{Name: "__name__", Value: "http_errors_total"}, {Name: "cluster", Value: "prod"}},
+		batch.Timeseries[1].Labels)
+
+	assert.NoError(t, relay.Push(&prompb.WriteRequest{Timeseries: []prompb.TimeSeries{series("http_a")}}))
+	cancel()
+	<-done
+	assert.Len(t, (<-client.batches).Timeseries, 1)
+}
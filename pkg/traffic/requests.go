@@ -0,0 +1,127 @@
+package traffic
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// match returns the labels of the series of the request metrics, nil for other series
+func (m RequestMetrics) match(series prompb.TimeSeries) map[string]string {
+	var name string
+	for _, label := range series.Labels {
+		if label.Name == "__name__" {
+			name = label.Value
+			break
+		}
+	}
+	if name != m.Requests && (m.Latency == "" || name != m.Latency+"_bucket") {
+		return nil
+	}
+	seriesLabels := make(map[string]string, len(series.Labels))
+	for _, label := range series.Labels {
+		seriesLabels[label.Name] = label.Value
+	}
+	return seriesLabels
+}
+
+// requestTraffic is the rate of requests, errors and latency buckets, summed over series
+type requestTraffic struct {
+	requests float64
+	errors   float64
+	buckets  map[float64]float64
+	// rated tells whether the rate of any series is known, it isn't on the first sample of series.
+	// ratedRequests tells the same of the series of the requests counter.
+	rated         bool
+	ratedRequests bool
+}
+
+func newRequestTraffic() *requestTraffic {
+	return &requestTraffic{buckets: make(map[float64]float64)}
+}
+
+// add adds the rate of a series matched by the request metrics
+func (r *requestTraffic) add(metrics RequestMetrics, counters *counterRates, series prompb.TimeSeries, seriesLabels map[string]string) {
+	if seriesLabels["__name__"] == metrics.Requests {
+		if rate, ok := counters.rate(series); ok {
+			r.rated, r.ratedRequests = true, true
+			r.requests += rate
+			if metrics.ErrorValues != nil && metrics.ErrorValues.MatchString(seriesLabels[metrics.ErrorLabel]) {
+				r.errors += rate
+			}
+		}
+	} else if upperBound, err := strconv.ParseFloat(seriesLabels["le"], 64); err == nil {
+		if rate, ok := counters.rate(series); ok {
+			r.rated = true
+			r.buckets[upperBound] += rate
+		}
+	}
+}
+
+// quantile returns the q quantile of the latency, 0 if unknown
+func (r *requestTraffic) quantile(q float64) float64 {
+	if len(r.buckets) == 0 {
+		return 0
+	}
+	buckets := make([]Bucket, 0, len(r.buckets))
+	for upperBound, count := range r.buckets {
+		buckets = append(buckets, Bucket{UpperBound: upperBound, Count: count})
+	}
+	if latency := BucketQuantile(q, buckets); !math.IsNaN(latency) {
+		return latency
+	}
+	return 0
+}
+
+func (r *requestTraffic) latencies() (float64, float64, float64) {
+	return r.quantile(0.5), r.quantile(0.95), r.quantile(0.99)
+}
+
+// counterRates computes the rates of counters from their previous samples
+type counterRates struct {
+	samples map[string]counterSample
+}
+
+type counterSample struct {
+	value     float64
+	timestamp int64
+}
+
+func newCounterRates() *counterRates {
+	return &counterRates{samples: make(map[string]counterSample)}
+}
+
+// rate returns the per second increase of a counter since its previous sample, handling counter resets
+func (c *counterRates) rate(series prompb.TimeSeries) (float64, bool) {
+	if len(series.Samples) == 0 {
+		return 0, false
+	}
+	sample := series.Samples[len(series.Samples)-1]
+	key := seriesKey(series.Labels)
+	if value.IsStaleNaN(sample.Value) {
+		delete(c.samples, key)
+		return 0, false
+	}
+	previous, found := c.samples[key]
+	c.samples[key] = counterSample{value: sample.Value, timestamp: sample.Timestamp}
+	if !found || sample.Timestamp <= previous.timestamp {
+		return 0, false
+	}
+	increase := sample.Value - previous.value
+	if increase < 0 {
+		increase = sample.Value
+	}
+	return increase / (float64(sample.Timestamp-previous.timestamp) / 1000), true
+}
+
+// expire forgets the counters not sampled since deadline
+func (c *counterRates) expire(deadline time.Time) {
+	for key, sample := range c.samples {
+		if sample.timestamp < deadline.UnixMilli() {
+			delete(c.samples, key)
+		}
+	}
+}
@@ -2,48 +2,339 @@ package traffic
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/hashicorp/go-retryablehttp"
 	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/expfmt"
-	corev1 "k8s.io/api/core/v1"
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/textparse"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/prompb"
 )
 
-// ScrapeTarget scrapes an http endpoint for prometheus metrics
-func ScrapeTarget(targetURL string) (string, map[string]*dto.MetricFamily, error) {
-	resp, err := retryablehttp.Get(targetURL)
-	if err != nil {
-		return "", nil, fmt.Errorf("error fetching metrics from target: %w", err)
+// acceptHeader prefers protobuf, the only format carrying native histograms, then OpenMetrics and text like prometheus
+const acceptHeader = "application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited;q=0.7," +
+	"application/openmetrics-text;version=1.0.0;q=0.5," +
+	"text/plain;version=0.0.4;q=0.3," +
+	"*/*;q=0.1"
+
+// maxBodySize bounds the size of scraped expositions
+const maxBodySize = 64 << 20
+
+// Target is an endpoint to scrape, its labels are added to every series scraped from it
+type Target struct {
+	URL    string
+	Labels []prompb.Label
+}
+
+// ScrapeResult is the outcome of scraping a target
+type ScrapeResult struct {
+	Target   Target
+	Duration time.Duration
+	Err      error
+}
+
+// Scraper scrapes targets concurrently, and remembers the series of every target to mark them stale
+// once they're gone, like prometheus does
+type Scraper struct {
+	client      *http.Client
+	timeout     time.Duration
+	concurrency int
+
+	mutex sync.Mutex
+	// series are the series last scraped from every target url, by labels
+	series map[string]map[string][]prompb.Label
+}
+
+func NewScraper(timeout time.Duration, concurrency int) *Scraper {
+	if concurrency < 1 {
+		concurrency = 1
 	}
-	//nolint:staticcheck // SA5001 Ignore error here
-	defer resp.Body.Close()
+	return &Scraper{
+		client:      &http.Client{},
+		timeout:     timeout,
+		concurrency: concurrency,
+		series:      make(map[string]map[string][]prompb.Label),
+	}
+}
 
-	body, err := io.ReadAll(resp.Body)
+// Scrape scrapes the targets with a pool of workers, each scrape is bounded by the scrape timeout. It returns the
+// result of every target, and a write request with the series of all targets, their up and scrape_duration_seconds
+// series, and staleness markers for the series gone since the previous scrape, including the series of targets
+// not given anymore.
+func (s *Scraper) Scrape(ctx context.Context, targets []Target) ([]*ScrapeResult, *prompb.WriteRequest) {
+	results := make([]*ScrapeResult, len(targets))
+	requests := make([]*prompb.WriteRequest, len(targets))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < s.concurrency && worker < len(targets); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i], requests[i] = s.scrape(ctx, targets[i])
+			}
+		}()
+	}
+	for i := range targets {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	writeRequest := &prompb.WriteRequest{}
+	for _, request := range requests {
+		writeRequest.Timeseries = append(writeRequest.Timeseries, request.Timeseries...)
+	}
+	writeRequest.Timeseries = append(writeRequest.Timeseries, s.markVanishedTargets(targets, time.Now())...)
+	return results, writeRequest
+}
+
+func (s *Scraper) scrape(ctx context.Context, target Target) (*ScrapeResult, *prompb.WriteRequest) {
+	scrapeTime := time.Now()
+	scrapeCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	request, err := ScrapeTarget(scrapeCtx, s.client, target.URL, scrapeTime)
+	result := &ScrapeResult{Target: target, Duration: time.Since(scrapeTime), Err: err}
+
+	// a failed scrape has no series but up and scrape_duration_seconds, the others go stale
+	up := 1.0
 	if err != nil {
-		return "", nil, fmt.Errorf("error reading response body: %w", err)
+		up = 0
+		request = &prompb.WriteRequest{}
+	}
+	for i := range request.Timeseries {
+		request.Timeseries[i].Labels = withTargetLabels(request.Timeseries[i].Labels, target.Labels)
+	}
+	for name, value := range map[string]float64{"up": up, "scrape_duration_seconds": result.Duration.Seconds()} {
+		request.Timeseries = append(request.Timeseries, prompb.TimeSeries{
+			Labels:  withTargetLabels([]prompb.Label{{Name: "__name__", Value: name}}, target.Labels),
+			Samples: []prompb.Sample{{Value: value, Timestamp: scrapeTime.UnixMilli()}},
+		})
 	}
 
-	parser := expfmt.TextParser{}
+	current := make(map[string][]prompb.Label, len(request.Timeseries))
+	for _, series := range request.Timeseries {
+		current[seriesKey(series.Labels)] = series.Labels
+	}
+	s.mutex.Lock()
+	previous := s.series[target.URL]
+	s.series[target.URL] = current
+	s.mutex.Unlock()
+	request.Timeseries = append(request.Timeseries, staleMarkers(previous, current, scrapeTime)...)
+	return result, request
+}
 
-	metricFamily, err := parser.TextToMetricFamilies(bytes.NewReader(body))
-	return string(body), metricFamily, err
+// markVanishedTargets forgets the targets not scraped anymore and returns staleness markers for all their series
+func (s *Scraper) markVanishedTargets(targets []Target, now time.Time) []prompb.TimeSeries {
+	scraped := make(map[string]struct{}, len(targets))
+	for _, target := range targets {
+		scraped[target.URL] = struct{}{}
+	}
+	var markers []prompb.TimeSeries
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for url, series := range s.series {
+		if _, found := scraped[url]; !found {
+			markers = append(markers, staleMarkers(series, nil, now)...)
+			delete(s.series, url)
+		}
+	}
+	return markers
 }
 
-// SetPodTargets tells traffic collector which pods to target
-func SetPodTargets(pods []*corev1.Pod, targetUrl string) error {
-	podsMarshalled, err := json.Marshal(pods)
-	if err != nil {
-		return fmt.Errorf("error marshalling pods to json: %w", err)
+// staleMarkers returns a stale sample for every previous series not in current
+func staleMarkers(previous, current map[string][]prompb.Label, now time.Time) []prompb.TimeSeries {
+	var markers []prompb.TimeSeries
+	for key, labels := range previous {
+		if _, found := current[key]; found {
+			continue
+		}
+		markers = append(markers, prompb.TimeSeries{
+			Labels:  labels,
+			Samples: []prompb.Sample{{Value: math.Float64frombits(value.StaleNaN), Timestamp: now.UnixMilli()}},
+		})
+	}
+	return markers
+}
+
+// withTargetLabels adds the target labels to the labels of a series. Like prometheus without honor_labels,
+// scraped labels conflicting with target labels are kept as exported_<name>.
+func withTargetLabels(seriesLabels, targetLabels []prompb.Label) []prompb.Label {
+	merged := make([]prompb.Label, 0, len(seriesLabels)+len(targetLabels))
+	merged = append(merged, targetLabels...)
+	for _, label := range seriesLabels {
+		for _, targetLabel := range targetLabels {
+			if label.Name == targetLabel.Name {
+				label.Name = "exported_" + label.Name
+				break
+			}
+		}
+		merged = append(merged, label)
 	}
-	resp, err := retryablehttp.Post(targetUrl, "application/json", bytes.NewBuffer(podsMarshalled))
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Name < merged[j].Name
+	})
+	return merged
+}
+
+// FilterByName keeps the series whose name matches names, including staleness markers
+func FilterByName(request *prompb.WriteRequest, names *regexp.Regexp) *prompb.WriteRequest {
+	filtered := &prompb.WriteRequest{Timeseries: make([]prompb.TimeSeries, 0, len(request.Timeseries))}
+	for _, series := range request.Timeseries {
+		for _, label := range series.Labels {
+			if label.Name == "__name__" {
+				if names.MatchString(label.Value) {
+					filtered.Timeseries = append(filtered.Timeseries, series)
+				}
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+func seriesKey(labels []prompb.Label) string {
+	var key strings.Builder
+	for _, label := range labels {
+		key.WriteString(label.Name)
+		key.WriteByte('=')
+		key.WriteString(label.Value)
+		key.WriteByte(0xff)
+	}
+	return key.String()
+}
+
+// readLimited reads r entirely, it fails instead of truncating what's past limit bytes
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r, limit+1))
 	if err != nil {
-		return fmt.Errorf("error setting pod target at %s: %w", targetUrl, err)
+		return nil, err
 	}
+	if int64(len(body)) > limit {
+		return nil, fmt.Errorf("body is larger than %d bytes", limit)
+	}
+	return body, nil
+}
 
+// ScrapeTarget scrapes an http endpoint for prometheus metrics in the protobuf, OpenMetrics or text format,
+// and returns its series
+func ScrapeTarget(ctx context.Context, client *http.Client, targetURL string, scrapeTime time.Time) (*prompb.WriteRequest, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, targetURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request to %s: %w", targetURL, err)
+	}
+	request.Header.Set("Accept", acceptHeader)
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching metrics from target: %w", err)
+	}
 	//nolint:staticcheck // SA5001 Ignore error here
 	defer resp.Body.Close()
-	return nil
+
+	body, err := readLimited(resp.Body, maxBodySize)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching metrics from %s: status %d", targetURL, resp.StatusCode)
+	}
+
+	writeRequest, err := ParseMetrics(body, resp.Header.Get("Content-Type"), scrapeTime)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing metrics from %s: %w", targetURL, err)
+	}
+	return writeRequest, nil
+}
+
+// ParseMetrics parses an exposition by its content type, anything but protobuf and OpenMetrics is parsed as text
+func ParseMetrics(body []byte, contentType string, scrapeTime time.Time) (*prompb.WriteRequest, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = ""
+	}
+	switch {
+	case mediaType == "application/vnd.google.protobuf" && params["proto"] == "io.prometheus.client.MetricFamily" && params["encoding"] == "delimited":
+		decoder := expfmt.NewDecoder(bytes.NewReader(body), expfmt.FmtProtoDelim)
+		metricFamilies := make(map[string]*dto.MetricFamily)
+		for {
+			family := &dto.MetricFamily{}
+			if err := decoder.Decode(family); errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			metricFamilies[family.GetName()] = family
+		}
+		return MetricFamiliesToProtoWriteRequest(metricFamilies, scrapeTime), nil
+	case mediaType == "application/openmetrics-text":
+		return openMetricsToProtoWriteRequest(body, scrapeTime)
+	default:
+		parser := expfmt.TextParser{}
+		metricFamilies, err := parser.TextToMetricFamilies(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		return MetricFamiliesToProtoWriteRequest(metricFamilies, scrapeTime), nil
+	}
+}
+
+// openMetricsToProtoWriteRequest converts every sample of an OpenMetrics exposition to a series, histograms and
+// summaries are exposed as their _bucket, _sum, _count and quantile samples already
+func openMetricsToProtoWriteRequest(body []byte, scrapeTime time.Time) (*prompb.WriteRequest, error) {
+	parser := textparse.NewOpenMetricsParser(body)
+	writeRequest := &prompb.WriteRequest{}
+	for {
+		entry, err := parser.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if entry != textparse.EntrySeries {
+			continue
+		}
+		_, timestamp, sampleValue := parser.Series()
+		sampleTime := scrapeTime.UnixMilli()
+		if timestamp != nil {
+			sampleTime = *timestamp
+		}
+		var seriesLabels labels.Labels
+		parser.Metric(&seriesLabels)
+		series := prompb.TimeSeries{
+			Labels:  labelsToProto(seriesLabels),
+			Samples: []prompb.Sample{{Value: sampleValue, Timestamp: sampleTime}},
+		}
+		var e exemplar.Exemplar
+		if parser.Exemplar(&e) {
+			converted := prompb.Exemplar{Labels: labelsToProto(e.Labels), Value: e.Value, Timestamp: sampleTime}
+			if e.HasTs {
+				converted.Timestamp = e.Ts
+			}
+			series.Exemplars = []prompb.Exemplar{converted}
+		}
+		writeRequest.Timeseries = append(writeRequest.Timeseries, series)
+	}
+	return writeRequest, nil
+}
+
+func labelsToProto(ls labels.Labels) []prompb.Label {
+	converted := make([]prompb.Label, 0, len(ls))
+	ls.Range(func(label labels.Label) {
+		converted = append(converted, prompb.Label{Name: label.Name, Value: label.Value})
+	})
+	return converted
 }
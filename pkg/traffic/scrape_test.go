@@ -0,0 +1,124 @@
+package traffic
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+const openMetrics = `# TYPE requests counter
+requests_total{path="/"} 5 1696154400.5 # {trace_id="abc"} 1.0
+# EOF
+`
+
+// staleSeries returns the names of the series with a stale marker, with the instance they were scraped from
+func staleSeries(request *prompb.WriteRequest) []string {
+	var stale []string
+	for _, series := range request.Timeseries {
+		if len(series.Samples) == 0 || !value.IsStaleNaN(series.Samples[0].Value) {
+			continue
+		}
+		var name, instance string
+		for _, label := range series.Labels {
+			switch label.Name {
+			case "__name__":
+				name = label.Value
+			case "instance":
+				instance = label.Value
+			}
+		}
+		stale = append(stale, instance+"/"+name)
+	}
+	return stale
+}
+
+func TestParseMetrics(t *testing.T) {
+	scrapeTime := time.Now()
+	request, err := ParseMetrics([]byte(openMetrics), "application/openmetrics-text; version=1.0.0; charset=utf-8", scrapeTime)
+	assert.NoError(t, err)
+	assert.Equal(t, []prompb.TimeSeries{{
+		Labels:    []prompb.Label{{Name: "__name__", Value: "requests_total"}, {Name: "path", Value: "/"}},
+		Samples:   []prompb.Sample{{Value: 5, Timestamp: 1696154400500}},
+		Exemplars: []prompb.Exemplar{{Labels: []prompb.Label{{Name: "trace_id", Value: "abc"}}, Value: 1, Timestamp: 1696154400500}},
+	}}, request.Timeseries)
+
+	_, err = ParseMetrics([]byte("not a metric\n"), "text/plain; version=0.0.4", scrapeTime)
+	assert.Error(t, err)
+}
+
+func TestReadLimited(t *testing.T) {
+	body, err := readLimited(strings.NewReader("third 3\n"), 8)
+	assert.NoError(t, err)
+	assert.Equal(t, "third 3\n", string(body))
+
+	_, err = readLimited(strings.NewReader("third 3\n"), 7)
+	assert.EqualError(t, err, "body is larger than 7 bytes")
+}
+
+func TestScraperStaleness(t *testing.T) {
+	// serves protobuf when asked for it, the first metric only until it's removed
+	withSecondMetric := true
+	protobufServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.True(t, strings.HasPrefix(r.Header.Get("Accept"), "application/vnd.google.protobuf"))
+		w.Header().Set("Content-Type", string(expfmt.FmtProtoDelim))
+		encoder := expfmt.NewEncoder(w, expfmt.FmtProtoDelim)
+		names := []string{"first"}
+		if withSecondMetric {
+			names = append(names, "second")
+		}
+		for _, name := range names {
+			_ = encoder.Encode(&dto.MetricFamily{
+				Name:   proto.String(name),
+				Type:   dto.MetricType_GAUGE.Enum(),
+				Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: proto.Float64(1)}}},
+			})
+		}
+	}))
+	defer protobufServer.Close()
+	failing := false
+	textServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte("third 3\n"))
+	}))
+	defer textServer.Close()
+
+	target := func(url, instance string) Target {
+		return Target{URL: url, Labels: []prompb.Label{{Name: "instance", Value: instance}}}
+	}
+	scraper := NewScraper(time.Second, 2)
+	ctx := context.Background()
+
+	results, request := scraper.Scrape(ctx, []Target{target(protobufServer.URL, "a"), target(textServer.URL, "b")})
+	assert.NoError(t, results[0].Err)
+	assert.NoError(t, results[1].Err)
+	assert.Len(t, request.Timeseries, 7)
+	assert.Empty(t, staleSeries(request))
+
+	withSecondMetric = false
+	failing = true
+	results, request = scraper.Scrape(ctx, []Target{target(protobufServer.URL, "a"), target(textServer.URL, "b")})
+	assert.Error(t, results[1].Err)
+	assert.ElementsMatch(t, []string{"a/second", "b/third"}, staleSeries(request))
+	for _, series := range request.Timeseries {
+		if series.Labels[0].Value == "up" && series.Labels[1].Value == "b" {
+			assert.Equal(t, float64(0), series.Samples[0].Value)
+		}
+	}
+
+	// targets gone are marked stale entirely
+	_, request = scraper.Scrape(ctx, []Target{target(textServer.URL, "b")})
+	assert.ElementsMatch(t, []string{"a/first", "a/up", "a/scrape_duration_seconds"}, staleSeries(request))
+}
@@ -0,0 +1,122 @@
+package traffic
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/webb-ai/k8s-agent/pkg/api"
+)
+
+// SignalHistory keeps the golden signals of the requests received by workloads, from the request metrics of
+// traffic collectors enriched with the metadata of the destination pods, for the retention
+type SignalHistory struct {
+	metrics   RequestMetrics
+	retention time.Duration
+
+	lock      sync.Mutex
+	counters  *counterRates
+	workloads map[workloadKey][]signalPoint
+}
+
+type workloadKey struct {
+	namespace string
+	name      string
+}
+
+// signalPoint is the traffic received by a workload in one write request, at the time of its latest sample
+type signalPoint struct {
+	timestamp int64
+	traffic   *requestTraffic
+}
+
+func NewSignalHistory(metrics RequestMetrics, retention time.Duration) *SignalHistory {
+	return &SignalHistory{
+		metrics:   metrics,
+		retention: retention,
+		counters:  newCounterRates(),
+		workloads: make(map[workloadKey][]signalPoint),
+	}
+}
+
+// Observe records the traffic of each destination workload in a write request
+func (h *SignalHistory) Observe(request *prompb.WriteRequest) {
+	observed := make(map[workloadKey]*requestTraffic)
+	var timestamp int64
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, series := range request.Timeseries {
+		seriesLabels := h.metrics.match(series)
+		if seriesLabels == nil {
+			continue
+		}
+		key := workloadKey{namespace: seriesLabels[DestinationPrefix+"namespace"], name: seriesLabels[DestinationPrefix+"workload"]}
+		if key.name == "" {
+			continue
+		}
+		received := observed[key]
+		if received == nil {
+			received = newRequestTraffic()
+			observed[key] = received
+		}
+		received.add(h.metrics, h.counters, series, seriesLabels)
+		if len(series.Samples) > 0 && series.Samples[len(series.Samples)-1].Timestamp > timestamp {
+			timestamp = series.Samples[len(series.Samples)-1].Timestamp
+		}
+	}
+	if len(observed) == 0 {
+		return
+	}
+
+	for key, received := range observed {
+		if !received.rated {
+			continue
+		}
+		h.workloads[key] = append(h.workloads[key], signalPoint{timestamp: timestamp, traffic: received})
+	}
+	deadline := time.UnixMilli(timestamp).Add(-h.retention)
+	h.counters.expire(deadline)
+	for key, points := range h.workloads {
+		i := sort.Search(len(points), func(i int) bool {
+			return points[i].timestamp >= deadline.UnixMilli()
+		})
+		if i == len(points) {
+			delete(h.workloads, key)
+		} else if i > 0 {
+			h.workloads[key] = append(points[:0:0], points[i:]...)
+		}
+	}
+}
+
+// Signals returns the golden signals of a workload between from and to, false if it received no traffic measured
+// in that window. The request rate is averaged over the scrapes, the points of a scrape split across several write
+// requests share its timestamp.
+func (h *SignalHistory) Signals(namespace, name string, from, to time.Time) (api.GoldenSignals, bool) {
+	signals := api.GoldenSignals{From: from.Unix(), To: to.Unix()}
+	total := newRequestTraffic()
+	scrapes := make(map[int64]struct{})
+	h.lock.Lock()
+	for _, point := range h.workloads[workloadKey{namespace: namespace, name: name}] {
+		if point.timestamp < from.UnixMilli() || point.timestamp >= to.UnixMilli() {
+			continue
+		}
+		scrapes[point.timestamp] = struct{}{}
+		total.requests += point.traffic.requests
+		total.errors += point.traffic.errors
+		for upperBound, rate := range point.traffic.buckets {
+			total.buckets[upperBound] += rate
+		}
+	}
+	h.lock.Unlock()
+	if len(scrapes) == 0 {
+		return signals, false
+	}
+
+	signals.RequestRate = total.requests / float64(len(scrapes))
+	if total.requests > 0 {
+		signals.ErrorRatio = total.errors / total.requests
+	}
+	signals.LatencyP95 = total.quantile(0.95)
+	return signals, true
+}
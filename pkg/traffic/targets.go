@@ -0,0 +1,141 @@
+package traffic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	updateTargetsPath = "/pods/update-targeted"
+	// setTargetsPath takes the full list of targets, it's served by collectors older than the update protocol
+	setTargetsPath = "/pods/set-targeted"
+)
+
+// TargetsUpdate changes the pods targeted by a traffic collector. Collectors apply an update only if they're at its
+// base version, and answer 409 Conflict otherwise so that they're sent a full update. Full updates replace all
+// targets of a collector.
+type TargetsUpdate struct {
+	Version     uint64        `json:"version"`
+	BaseVersion uint64        `json:"base_version"`
+	Full        bool          `json:"full"`
+	Added       []*corev1.Pod `json:"added,omitempty"`
+	// Removed are the uids of pods no longer targeted
+	Removed []string `json:"removed,omitempty"`
+}
+
+// PodTargets sends the pods to target to one traffic collector, as diffs from the targets it acknowledged. Collectors
+// not serving updates are sent the full list of their targets every time instead.
+type PodTargets struct {
+	url     string
+	client  *retryablehttp.Client
+	legacy  bool
+	version uint64
+	// acked are the IPs of the targets acknowledged by the collector by uid, nil until a full update is acknowledged
+	acked map[string]string
+}
+
+// NewPodTargets sends targets to the collector served at url, e.g. http://10.0.0.1:8080
+func NewPodTargets(url string, retryMax int) *PodTargets {
+	client := retryablehttp.NewClient()
+	client.RetryMax = retryMax
+	client.RetryWaitMax = 10 * time.Second
+	client.Logger = nil
+	return &PodTargets{url: url, client: client}
+}
+
+// Update sends the changes to the targeted pods since the last acknowledged update, if any. Targets aren't
+// acknowledged on errors so that the next update carries their changes again.
+func (t *PodTargets) Update(pods []*corev1.Pod) error {
+	if t.legacy {
+		return t.set(pods)
+	}
+	update := t.diff(pods)
+	if update == nil {
+		return nil
+	}
+	status, err := t.post(updateTargetsPath, update)
+	if err == nil && (status == http.StatusNotFound || status == http.StatusMethodNotAllowed) {
+		klog.Infof("traffic collector at %s doesn't serve %s, falling back to %s", t.url, updateTargetsPath, setTargetsPath)
+		t.legacy = true
+		return t.set(pods)
+	}
+	if err == nil && status == http.StatusConflict && !update.Full {
+		// the collector lost track of its targets, e.g. it restarted
+		t.acked = nil
+		update = t.diff(pods)
+		status, err = t.post(updateTargetsPath, update)
+	}
+	if err != nil {
+		return err
+	}
+	if status < 200 || status >= 300 {
+		return fmt.Errorf("error updating pod targets at %s: status %d", t.url, status)
+	}
+
+	t.version = update.Version
+	t.acked = make(map[string]string, len(pods))
+	for _, pod := range pods {
+		t.acked[string(pod.UID)] = pod.Status.PodIP
+	}
+	return nil
+}
+
+// diff returns the update from the acknowledged targets to pods, nil if there is no change
+func (t *PodTargets) diff(pods []*corev1.Pod) *TargetsUpdate {
+	update := &TargetsUpdate{Version: t.version + 1, BaseVersion: t.version, Full: t.acked == nil}
+	current := make(map[string]struct{}, len(pods))
+	for _, pod := range pods {
+		current[string(pod.UID)] = struct{}{}
+		if ip, found := t.acked[string(pod.UID)]; update.Full || !found || ip != pod.Status.PodIP {
+			update.Added = append(update.Added, pod)
+		}
+	}
+	for uid := range t.acked {
+		if _, found := current[uid]; !found {
+			update.Removed = append(update.Removed, uid)
+		}
+	}
+	if !update.Full && len(update.Added) == 0 && len(update.Removed) == 0 {
+		return nil
+	}
+	sort.Strings(update.Removed)
+	return update
+}
+
+// set sends all the targets of a legacy collector, which can't tell when it lost them
+func (t *PodTargets) set(pods []*corev1.Pod) error {
+	if pods == nil {
+		pods = []*corev1.Pod{}
+	}
+	status, err := t.post(setTargetsPath, pods)
+	if err != nil {
+		return err
+	}
+	if status < 200 || status >= 300 {
+		return fmt.Errorf("error setting pod targets at %s: status %d", t.url, status)
+	}
+	return nil
+}
+
+func (t *PodTargets) post(path string, targets interface{}) (int, error) {
+	body, err := json.Marshal(targets)
+	if err != nil {
+		return 0, fmt.Errorf("error marshalling pod targets to json: %w", err)
+	}
+	resp, err := t.client.Post(t.url+path, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return 0, fmt.Errorf("error updating pod targets at %s: %w", t.url, err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
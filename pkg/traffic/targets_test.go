@@ -0,0 +1,114 @@
+package traffic
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// targetsCollector applies target updates like traffic collectors do
+type targetsCollector struct {
+	version uint64
+	targets map[string]string
+	failing bool
+	updates []*TargetsUpdate
+}
+
+func (c *targetsCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if c.failing {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	update := &TargetsUpdate{}
+	_ = json.NewDecoder(r.Body).Decode(update)
+	c.updates = append(c.updates, update)
+	if update.Full {
+		c.targets = make(map[string]string)
+	} else if update.BaseVersion != c.version {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	for _, pod := range update.Added {
+		c.targets[string(pod.UID)] = pod.Status.PodIP
+	}
+	for _, uid := range update.Removed {
+		delete(c.targets, uid)
+	}
+	c.version = update.Version
+}
+
+func (c *targetsCollector) uids() []string {
+	var uids []string
+	for uid := range c.targets {
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids)
+	return uids
+}
+
+func newTargetPod(uid, ip string) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{UID: types.UID(uid)}, Status: corev1.PodStatus{PodIP: ip}}
+}
+
+func TestPodTargets(t *testing.T) {
+	collector := &targetsCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+	podTargets := NewPodTargets(server.URL, 0)
+
+	assert.NoError(t, podTargets.Update([]*corev1.Pod{newTargetPod("a", "10.0.0.1"), newTargetPod("b", "10.0.0.2")}))
+	assert.True(t, collector.updates[0].Full)
+	assert.Equal(t, []string{"a", "b"}, collector.uids())
+
+	// unchanged targets aren't sent again
+	assert.NoError(t, podTargets.Update([]*corev1.Pod{newTargetPod("a", "10.0.0.1"), newTargetPod("b", "10.0.0.2")}))
+	assert.Len(t, collector.updates, 1)
+
+	// failed updates are carried by the next one
+	collector.failing = true
+	assert.Error(t, podTargets.Update([]*corev1.Pod{newTargetPod("a", "10.0.0.1")}))
+	collector.failing = false
+	assert.NoError(t, podTargets.Update([]*corev1.Pod{newTargetPod("a", "10.0.0.1"), newTargetPod("c", "10.0.0.3")}))
+	assert.Equal(t, &TargetsUpdate{Version: 2, BaseVersion: 1, Added: []*corev1.Pod{newTargetPod("c", "10.0.0.3")}, Removed: []string{"b"}},
+		collector.updates[1])
+	assert.Equal(t, []string{"a", "c"}, collector.uids())
+
+	// collectors losing track of their version get a full update
+	collector.version = 0
+	assert.NoError(t, podTargets.Update([]*corev1.Pod{newTargetPod("c", "10.0.0.3")}))
+	assert.True(t, collector.updates[len(collector.updates)-1].Full)
+	assert.Equal(t, []string{"c"}, collector.uids())
+}
+
+func TestPodTargetsFallBackToLegacyCollectors(t *testing.T) {
+	var updates int
+	var targets [][]*corev1.Pod
+	mux := http.NewServeMux()
+	mux.HandleFunc(updateTargetsPath, func(w http.ResponseWriter, r *http.Request) {
+		updates++
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc(setTargetsPath, func(w http.ResponseWriter, r *http.Request) {
+		var pods []*corev1.Pod
+		_ = json.NewDecoder(r.Body).Decode(&pods)
+		targets = append(targets, pods)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	podTargets := NewPodTargets(server.URL, 0)
+
+	pods := []*corev1.Pod{newTargetPod("a", "10.0.0.1")}
+	assert.NoError(t, podTargets.Update(pods))
+	// legacy collectors get all their targets every time, they can't tell when they lost them
+	assert.NoError(t, podTargets.Update(pods))
+	assert.NoError(t, podTargets.Update(nil))
+	assert.Equal(t, 1, updates)
+	assert.Equal(t, [][]*corev1.Pod{pods, pods, {}}, targets)
+}
@@ -0,0 +1,254 @@
+package traffic
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/webb-ai/k8s-agent/pkg/api"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// SourcePrefix and DestinationPrefix prefix the labels of the pods sending and receiving requests
+	SourcePrefix      = "src_"
+	DestinationPrefix = "dst_"
+
+	externalKind = "External"
+	serviceKind  = "Service"
+)
+
+// RequestMetrics tells how the traffic collector measures requests. Requests is a counter of requests, they're
+// errors if their ErrorLabel matches ErrorValues, and Latency is a histogram of their duration.
+type RequestMetrics struct {
+	Requests    string
+	Latency     string
+	ErrorLabel  string
+	ErrorValues *regexp.Regexp
+}
+
+// Observer observes the traffic metrics sent to webb.ai
+type Observer interface {
+	Observe(request *prompb.WriteRequest)
+}
+
+// TopologyConfig tells how requests are measured, edges without requests for Expiry are removed
+type TopologyConfig struct {
+	RequestMetrics
+	Expiry time.Duration
+}
+
+// Topology builds the service dependency graph from the request metrics of traffic collectors, enriched with
+// the metadata of the source and destination pods. Edges go from workloads to the Services or workloads they send
+// requests to, and are sent as topology_change events when they appear or disappear.
+type Topology struct {
+	client api.Client
+	config TopologyConfig
+
+	lock     sync.Mutex
+	counters *counterRates
+	edges    map[edgeKey]*api.TopologyEdge
+	scrapes  map[edgeKey]*edgeScrape
+}
+
+type edgeKey struct {
+	source      api.TopologyNode
+	destination api.TopologyNode
+}
+
+// edgeScrape is the traffic of an edge in its latest scrape, which may be split across several write requests
+type edgeScrape struct {
+	timestamp int64
+	traffic   *requestTraffic
+}
+
+func NewTopology(client api.Client, config TopologyConfig) *Topology {
+	return &Topology{
+		client:   client,
+		config:   config,
+		counters: newCounterRates(),
+		edges:    make(map[edgeKey]*api.TopologyEdge),
+		scrapes:  make(map[edgeKey]*edgeScrape),
+	}
+}
+
+// Observe updates the edges with the counters of a write request, rates are computed from the previous samples
+// of the same series and summed over the series of the same scrape
+func (t *Topology) Observe(request *prompb.WriteRequest) {
+	observed := make(map[edgeKey]*edgeScrape)
+	t.lock.Lock()
+	for _, series := range request.Timeseries {
+		seriesLabels := t.config.match(series)
+		if seriesLabels == nil || len(series.Samples) == 0 {
+			continue
+		}
+		key, ok := newEdgeKey(seriesLabels)
+		if !ok {
+			continue
+		}
+		timestamp := series.Samples[len(series.Samples)-1].Timestamp
+		scrape := t.scrapes[key]
+		if scrape == nil || timestamp > scrape.timestamp {
+			scrape = &edgeScrape{timestamp: timestamp, traffic: newRequestTraffic()}
+			t.scrapes[key] = scrape
+		} else if timestamp < scrape.timestamp {
+			// a late series of a previous scrape
+			continue
+		}
+		scrape.traffic.add(t.config.RequestMetrics, t.counters, series, seriesLabels)
+		observed[key] = scrape
+	}
+
+	now := time.Now().Unix()
+	var added []api.TopologyEdge
+	for key, scrape := range observed {
+		if edge := t.updateEdge(key, scrape.traffic, now); edge != nil {
+			added = append(added, *edge)
+		}
+	}
+	t.lock.Unlock()
+
+	for _, edge := range added {
+		t.sendChange(api.EdgeAdded, edge)
+	}
+}
+
+// updateEdge updates an edge with the traffic observed so far in its latest scrape, it returns the edge if it's new
+func (t *Topology) updateEdge(key edgeKey, observed *requestTraffic, now int64) *api.TopologyEdge {
+	edge, found := t.edges[key]
+	if !found {
+		if observed.requests <= 0 {
+			return nil
+		}
+		edge = &api.TopologyEdge{Source: key.source, Destination: key.destination, FirstSeen: now}
+		t.edges[key] = edge
+	}
+	// a write request may hold only some series of a scrape, e.g. only the latency buckets
+	if observed.ratedRequests {
+		edge.RequestRate = observed.requests
+		edge.ErrorRate = observed.errors
+	}
+	if len(observed.buckets) > 0 {
+		edge.LatencyP50, edge.LatencyP95, edge.LatencyP99 = observed.latencies()
+	}
+	if observed.requests > 0 {
+		edge.LastSeen = now
+	}
+	if found {
+		return nil
+	}
+	return edge
+}
+
+// newEdgeKey returns the edge from the source workload to the destination Service, or workload if the destination
+// pod isn't part of a Service
+func newEdgeKey(seriesLabels map[string]string) (edgeKey, bool) {
+	source, ok := newNode(seriesLabels, SourcePrefix, false)
+	if !ok {
+		return edgeKey{}, false
+	}
+	destination, ok := newNode(seriesLabels, DestinationPrefix, true)
+	if !ok {
+		return edgeKey{}, false
+	}
+	return edgeKey{source: source, destination: destination}, true
+}
+
+func newNode(seriesLabels map[string]string, prefix string, preferService bool) (api.TopologyNode, bool) {
+	namespace := seriesLabels[prefix+"namespace"]
+	workload, service := seriesLabels[prefix+"workload"], seriesLabels[prefix+"service"]
+	switch {
+	case service != "" && (preferService || workload == ""):
+		return api.TopologyNode{Kind: serviceKind, Namespace: namespace, Name: service}, true
+	case workload != "":
+		return api.TopologyNode{Kind: seriesLabels[prefix+"workload_kind"], Namespace: namespace, Name: workload}, true
+	case seriesLabels[prefix+"ip"] != "":
+		return api.TopologyNode{Kind: externalKind, Name: seriesLabels[prefix+"ip"]}, true
+	}
+	return api.TopologyNode{}, false
+}
+
+// Start removes the edges without requests for the expiry, until ctx is done
+func (t *Topology) Start(ctx context.Context) error {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.expire(time.Now())
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (t *Topology) expire(now time.Time) {
+	deadline := now.Add(-t.config.Expiry)
+	var removed []api.TopologyEdge
+	t.lock.Lock()
+	for key, edge := range t.edges {
+		if edge.LastSeen < deadline.Unix() {
+			delete(t.edges, key)
+			removed = append(removed, *edge)
+		}
+	}
+	for key, scrape := range t.scrapes {
+		if scrape.timestamp < deadline.UnixMilli() {
+			delete(t.scrapes, key)
+		}
+	}
+	t.counters.expire(deadline)
+	t.lock.Unlock()
+
+	for _, edge := range removed {
+		t.sendChange(api.EdgeRemoved, edge)
+	}
+}
+
+func (t *Topology) sendChange(operation api.TopologyOperation, edge api.TopologyEdge) {
+	klog.Infof("topology %s: %s %s/%s -> %s %s/%s", operation, edge.Source.Kind, edge.Source.Namespace, edge.Source.Name,
+		edge.Destination.Kind, edge.Destination.Namespace, edge.Destination.Name)
+	if err := t.client.SendChangeEvent(api.NewTopologyChangeEvent(operation, edge)); err != nil {
+		klog.Error(err)
+	}
+}
+
+// Topology returns a copy of the graph, sorted to be stable across calls
+func (t *Topology) Topology() *api.Topology {
+	topology := &api.Topology{Nodes: []api.TopologyNode{}, Edges: []api.TopologyEdge{}, Time: time.Now().Unix()}
+	nodes := make(map[api.TopologyNode]struct{})
+	t.lock.Lock()
+	for _, edge := range t.edges {
+		topology.Edges = append(topology.Edges, *edge)
+		nodes[edge.Source] = struct{}{}
+		nodes[edge.Destination] = struct{}{}
+	}
+	t.lock.Unlock()
+
+	for node := range nodes {
+		topology.Nodes = append(topology.Nodes, node)
+	}
+	sort.Slice(topology.Nodes, func(i, j int) bool {
+		return nodeLess(topology.Nodes[i], topology.Nodes[j])
+	})
+	sort.Slice(topology.Edges, func(i, j int) bool {
+		if topology.Edges[i].Source != topology.Edges[j].Source {
+			return nodeLess(topology.Edges[i].Source, topology.Edges[j].Source)
+		}
+		return nodeLess(topology.Edges[i].Destination, topology.Edges[j].Destination)
+	})
+	return topology
+}
+
+func nodeLess(a, b api.TopologyNode) bool {
+	if a.Namespace != b.Namespace {
+		return a.Namespace < b.Namespace
+	}
+	if a.Name != b.Name {
+		return a.Name < b.Name
+	}
+	return a.Kind < b.Kind
+}
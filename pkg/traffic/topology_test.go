@@ -0,0 +1,136 @@
+package traffic
+
+import (
+	"math"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/webb-ai/k8s-agent/pkg/api"
+)
+
+type changeEventClient struct {
+	api.NoOpClient
+	events []*api.ChangeEvent
+}
+
+func (c *changeEventClient) SendChangeEvent(event *api.ChangeEvent) error {
+	c.events = append(c.events, event)
+	return nil
+}
+
+func TestBucketQuantile(t *testing.T) {
+	buckets := []Bucket{{UpperBound: math.Inf(1), Count: 100}, {UpperBound: 0.1, Count: 50}, {UpperBound: 0.5, Count: 90}}
+	assert.InDelta(t, 0.1, BucketQuantile(0.5, buckets), 1e-9)
+	assert.InDelta(t, 0.3, BucketQuantile(0.7, buckets), 1e-9)
+	// quantiles in the +Inf bucket are the highest finite bound
+	assert.Equal(t, 0.5, BucketQuantile(0.95, buckets))
+	assert.True(t, math.IsNaN(BucketQuantile(0.5, []Bucket{{UpperBound: 0.1, Count: 1}})))
+}
+
+func newTestTopology(client api.Client) *Topology {
+	return NewTopology(client, TopologyConfig{
+		RequestMetrics: RequestMetrics{
+			Requests:    "requests_total",
+			Latency:     "request_duration_seconds",
+			ErrorLabel:  "status_code",
+			ErrorValues: regexp.MustCompile("^(?:5..)$"),
+		},
+		Expiry: time.Minute,
+	})
+}
+
+var edgeLabels = []prompb.Label{
+	{Name: "dst_namespace", Value: "shop"},
+	{Name: "dst_service", Value: "payments"},
+	{Name: "dst_workload", Value: "payments"},
+	{Name: "src_namespace", Value: "shop"},
+	{Name: "src_workload", Value: "checkout"},
+	{Name: "src_workload_kind", Value: "Deployment"},
+}
+
+func edgeSeries(name string, value float64, timestamp int64, extra ...prompb.Label) prompb.TimeSeries {
+	labels := append([]prompb.Label{{Name: "__name__", Value: name}}, extra...)
+	return prompb.TimeSeries{
+		Labels:  append(labels, edgeLabels...),
+		Samples: []prompb.Sample{{Value: value, Timestamp: timestamp}},
+	}
+}
+
+// edgeRequests and edgeLatencies are the request counters and latency buckets of a scrape of the edge
+func edgeRequests(requests, errors float64, timestamp int64) []prompb.TimeSeries {
+	return []prompb.TimeSeries{
+		edgeSeries("requests_total", requests, timestamp, prompb.Label{Name: "status_code", Value: "200"}),
+		edgeSeries("requests_total", errors, timestamp, prompb.Label{Name: "status_code", Value: "503"}),
+	}
+}
+
+func edgeLatencies(fast, total float64, timestamp int64) []prompb.TimeSeries {
+	return []prompb.TimeSeries{
+		edgeSeries("request_duration_seconds_bucket", fast, timestamp, prompb.Label{Name: "le", Value: "0.1"}),
+		edgeSeries("request_duration_seconds_bucket", total, timestamp, prompb.Label{Name: "le", Value: "+Inf"}),
+	}
+}
+
+func TestTopology(t *testing.T) {
+	client := &changeEventClient{}
+	topology := newTestTopology(client)
+	scrape := func(requests, errors, fast float64, timestamp int64) *prompb.WriteRequest {
+		series := append(edgeRequests(requests, errors, timestamp), edgeLatencies(fast, requests+errors, timestamp)...)
+		// series without source aren't edges
+		series = append(series, prompb.TimeSeries{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "requests_total"}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: timestamp}},
+		})
+		return &prompb.WriteRequest{Timeseries: series}
+	}
+
+	topology.Observe(scrape(100, 0, 100, 0))
+	assert.Empty(t, client.events)
+	topology.Observe(scrape(640, 60, 400, 60000))
+	assert.Len(t, client.events, 1)
+	assert.Equal(t, api.TopologyChange, client.events[0].EventType)
+	assert.Equal(t, api.EdgeAdded, client.events[0].Topology.Operation)
+
+	graph := topology.Topology()
+	assert.Equal(t, []api.TopologyNode{
+		{Kind: "Deployment", Namespace: "shop", Name: "checkout"},
+		{Kind: "Service", Namespace: "shop", Name: "payments"},
+	}, graph.Nodes)
+	edge := graph.Edges[0]
+	assert.InDelta(t, 10, edge.RequestRate, 1e-9)
+	assert.InDelta(t, 1, edge.ErrorRate, 1e-9)
+	assert.Equal(t, 0.1, edge.LatencyP95)
+
+	topology.expire(time.Now().Add(2 * time.Minute))
+	assert.Equal(t, api.EdgeRemoved, client.events[1].Topology.Operation)
+	assert.Empty(t, topology.Topology().Edges)
+}
+
+func TestTopologyOfScrapesSplitAcrossRequests(t *testing.T) {
+	topology := newTestTopology(&api.NoOpClient{})
+	observe := func(series ...prompb.TimeSeries) {
+		topology.Observe(&prompb.WriteRequest{Timeseries: series})
+	}
+
+	// every scrape is sent in two write requests, one with half of the request counters
+	requests := edgeRequests(100, 0, 0)
+	observe(requests[0])
+	observe(append(requests[1:], edgeLatencies(100, 100, 0)...)...)
+	requests = edgeRequests(640, 60, 60000)
+	observe(requests[0])
+	observe(append(requests[1:], edgeLatencies(400, 700, 60000)...)...)
+
+	edge := topology.Topology().Edges[0]
+	assert.InDelta(t, 10, edge.RequestRate, 1e-9)
+	assert.InDelta(t, 1, edge.ErrorRate, 1e-9)
+	assert.Equal(t, 0.1, edge.LatencyP95)
+
+	// a write request with only the latency buckets of the next scrape updates the latency, not the request rate
+	observe(edgeLatencies(1000, 1300, 120000)...)
+	edge = topology.Topology().Edges[0]
+	assert.InDelta(t, 10, edge.RequestRate, 1e-9)
+	assert.InDelta(t, 0.095, edge.LatencyP95, 1e-9)
+}
@@ -0,0 +1,62 @@
+package util
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// podSpecPaths are the paths of the pod spec in pods, cronjobs and the workloads with a pod template
+var podSpecPaths = map[string][]string{
+	"Pod":     {"spec"},
+	"CronJob": {"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+var podTemplatePath = []string{"spec", "template"}
+
+// PodTemplateMetadata returns the labels and annotations of the pod template of a workload, or nil
+func PodTemplateMetadata(object *unstructured.Unstructured) (map[string]string, map[string]string) {
+	if object == nil {
+		return nil, nil
+	}
+	path := podTemplatePath
+	if object.GetKind() == "CronJob" {
+		path = []string{"spec", "jobTemplate", "spec", "template"}
+	}
+	labels, _, _ := unstructured.NestedStringMap(object.Object, append(path, "metadata", "labels")...)
+	annotations, _, _ := unstructured.NestedStringMap(object.Object, append(path, "metadata", "annotations")...)
+	return labels, annotations
+}
+
+// ContainerImages returns the images of the containers and init containers of a pod or of the pod template of a workload
+func ContainerImages(object *unstructured.Unstructured) []string {
+	if object == nil {
+		return nil
+	}
+	path, found := podSpecPaths[object.GetKind()]
+	if !found {
+		path = append(podTemplatePath, "spec")
+	}
+	var images []string
+	for _, field := range []string{"containers", "initContainers"} {
+		containers, _, _ := unstructured.NestedSlice(object.Object, append(path, field)...)
+		for _, container := range containers {
+			container, ok := container.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if image, _ := container["image"].(string); image != "" {
+				images = append(images, image)
+			}
+		}
+	}
+	return images
+}
+
+// ImageTag returns the tag of an image reference, e.g. v1.2 of registry:5000/app:v1.2@sha256:..., or "" if untagged
+func ImageTag(image string) string {
+	image, _, _ = strings.Cut(image, "@")
+	name := image[strings.LastIndex(image, "/")+1:]
+	_, tag, _ := strings.Cut(name, ":")
+	return tag
+}
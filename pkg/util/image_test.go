@@ -0,0 +1,28 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestImageTag(t *testing.T) {
+	assert.Equal(t, "v1.2", ImageTag("registry:5000/team/app:v1.2@sha256:abc"))
+	assert.Equal(t, "", ImageTag("registry:5000/team/app"))
+	assert.Equal(t, "latest", ImageTag("nginx:latest"))
+}
+
+func TestContainerImages(t *testing.T) {
+	cronJob := &unstructured.Unstructured{Object: map[string]interface{}{
+		"kind": "CronJob",
+		"spec": map[string]interface{}{"jobTemplate": map[string]interface{}{"spec": map[string]interface{}{
+			"template": map[string]interface{}{"spec": map[string]interface{}{
+				"initContainers": []interface{}{map[string]interface{}{"name": "migrate", "image": "acme/migrate:v1"}},
+				"containers":     []interface{}{map[string]interface{}{"name": "report", "image": "acme/report:v2"}},
+			}},
+		}}},
+	}}
+	assert.Equal(t, []string{"acme/report:v2", "acme/migrate:v1"}, ContainerImages(cronJob))
+	assert.Empty(t, ContainerImages(nil))
+}
@@ -0,0 +1,305 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/structured-merge-diff/v4/fieldpath"
+	"sigs.k8s.io/structured-merge-diff/v4/value"
+)
+
+// FieldAttribution is a field manager owning some of the fields that changed between two versions of an object
+type FieldAttribution struct {
+	Manager     string `json:"manager"`
+	Operation   string `json:"operation"`
+	Subresource string `json:"subresource,omitempty"`
+	Time        int64  `json:"time,omitempty"`
+	// Updated is true if the manager's entry changed with this update, i.e. it most likely made the change
+	Updated bool     `json:"updated"`
+	Fields  []string `json:"fields"`
+}
+
+// fieldStep is a step of a field path: a map field, or a list element which is kept to resolve keyed list entries
+type fieldStep struct {
+	field   string
+	index   int
+	element interface{}
+}
+
+type fieldPath []fieldStep
+
+// ignoredFields are changed by the api server on every update and owned by no manager
+var ignoredFields = map[string]struct{}{
+	".metadata.managedFields":   {},
+	".metadata.resourceVersion": {},
+	".metadata.generation":      {},
+}
+
+func (p fieldPath) String() string {
+	var builder strings.Builder
+	for _, step := range p {
+		if step.field != "" {
+			builder.WriteString("." + step.field)
+			continue
+		}
+		if element, ok := step.element.(map[string]interface{}); ok {
+			if name, ok := element["name"]; ok {
+				builder.WriteString(fmt.Sprintf("[name=%v]", name))
+				continue
+			}
+		}
+		builder.WriteString(fmt.Sprintf("[%d]", step.index))
+	}
+	return builder.String()
+}
+
+// DiffObjects returns the paths of the fields that differ between two versions of an object, and the field managers
+// of newObject owning them, most recent first. Elements of lists of maps are paired by name if they have one,
+// lists of scalars are compared as a whole.
+func DiffObjects(oldObject, newObject *unstructured.Unstructured) ([]string, []FieldAttribution) {
+	if oldObject == nil || newObject == nil {
+		return nil, nil
+	}
+	changes := changedFieldPaths(oldObject, newObject)
+	return pathStrings(changes), attribute(oldObject, newObject, changes)
+}
+
+func pathStrings(paths []fieldPath) []string {
+	result := make([]string, 0, len(paths))
+	for _, path := range paths {
+		result = append(result, path.String())
+	}
+	sort.Strings(result)
+	return result
+}
+
+func changedFieldPaths(oldObject, newObject *unstructured.Unstructured) []fieldPath {
+	var oldContent, newContent map[string]interface{}
+	if oldObject != nil {
+		oldContent = oldObject.Object
+	}
+	if newObject != nil {
+		newContent = newObject.Object
+	}
+	var changes []fieldPath
+	diffFields(nil, oldContent, newContent, &changes)
+	return changes
+}
+
+func diffFields(prefix fieldPath, oldValue, newValue interface{}, changes *[]fieldPath) {
+	if _, ignored := ignoredFields[prefix.String()]; ignored {
+		return
+	}
+	oldMap, oldIsMap := oldValue.(map[string]interface{})
+	newMap, newIsMap := newValue.(map[string]interface{})
+	if oldIsMap && newIsMap {
+		keys := make(map[string]struct{}, len(oldMap)+len(newMap))
+		for key := range oldMap {
+			keys[key] = struct{}{}
+		}
+		for key := range newMap {
+			keys[key] = struct{}{}
+		}
+		for key := range keys {
+			diffFields(appendStep(prefix, fieldStep{field: key}), oldMap[key], newMap[key], changes)
+		}
+		return
+	}
+
+	oldList, oldIsList := oldValue.([]interface{})
+	newList, newIsList := newValue.([]interface{})
+	if oldIsList && newIsList && isNamedList(oldList) && isNamedList(newList) {
+		diffNamedLists(prefix, oldList, newList, changes)
+		return
+	}
+
+	if !reflect.DeepEqual(oldValue, newValue) {
+		*changes = append(*changes, prefix)
+	}
+}
+
+func diffNamedLists(prefix fieldPath, oldList, newList []interface{}, changes *[]fieldPath) {
+	oldByName := make(map[interface{}]interface{}, len(oldList))
+	for _, element := range oldList {
+		oldByName[element.(map[string]interface{})["name"]] = element
+	}
+	newNames := make(map[interface{}]struct{}, len(newList))
+	for i, element := range newList {
+		name := element.(map[string]interface{})["name"]
+		newNames[name] = struct{}{}
+		diffFields(appendStep(prefix, fieldStep{index: i, element: element}), oldByName[name], element, changes)
+	}
+	for i, element := range oldList {
+		if _, found := newNames[element.(map[string]interface{})["name"]]; !found {
+			*changes = append(*changes, appendStep(prefix, fieldStep{index: i, element: element}))
+		}
+	}
+}
+
+func isNamedList(list []interface{}) bool {
+	for _, element := range list {
+		elementMap, ok := element.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if _, ok := elementMap["name"]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func appendStep(prefix fieldPath, step fieldStep) fieldPath {
+	path := make(fieldPath, 0, len(prefix)+1)
+	path = append(path, prefix...)
+	return append(path, step)
+}
+
+func attribute(oldObject, newObject *unstructured.Unstructured, changes []fieldPath) []FieldAttribution {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	oldEntries := make(map[string]string)
+	for _, entry := range oldObject.GetManagedFields() {
+		oldEntries[entryKey(entry)] = entryFingerprint(entry)
+	}
+
+	var attributions []FieldAttribution
+	for _, entry := range newObject.GetManagedFields() {
+		if entry.FieldsV1 == nil {
+			continue
+		}
+		owned := &fieldpath.Set{}
+		if err := owned.FromJSON(bytes.NewReader(entry.FieldsV1.Raw)); err != nil {
+			klog.Warningf("unable to parse managed fields of %s: %v", entry.Manager, err)
+			continue
+		}
+
+		var fields []string
+		for _, change := range changes {
+			if ownsField(owned, change) {
+				fields = append(fields, change.String())
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		sort.Strings(fields)
+
+		attribution := FieldAttribution{
+			Manager:     entry.Manager,
+			Operation:   string(entry.Operation),
+			Subresource: entry.Subresource,
+			Fields:      fields,
+		}
+		if entry.Time != nil {
+			attribution.Time = entry.Time.Unix()
+		}
+		attribution.Updated = oldEntries[entryKey(entry)] != entryFingerprint(entry)
+		attributions = append(attributions, attribution)
+	}
+
+	sort.SliceStable(attributions, func(i, j int) bool {
+		if attributions[i].Updated != attributions[j].Updated {
+			return attributions[i].Updated
+		}
+		return attributions[i].Time > attributions[j].Time
+	})
+	return attributions
+}
+
+func entryKey(entry metav1.ManagedFieldsEntry) string {
+	return entry.Manager + "/" + string(entry.Operation) + "/" + entry.Subresource
+}
+
+// entryFingerprint changes whenever the manager writes to the object
+func entryFingerprint(entry metav1.ManagedFieldsEntry) string {
+	fingerprint := ""
+	if entry.Time != nil {
+		fingerprint = entry.Time.UTC().String()
+	}
+	if entry.FieldsV1 != nil {
+		fingerprint += string(entry.FieldsV1.Raw)
+	}
+	return fingerprint
+}
+
+// ownsField returns whether a managed field set owns the field at path or anything below it
+func ownsField(owned *fieldpath.Set, path fieldPath) bool {
+	current := owned
+	for i, step := range path {
+		pe, found := resolveStep(current, step)
+		if !found {
+			return false
+		}
+		last := i == len(path)-1
+		if last && current.Members.Has(pe) {
+			return true
+		}
+		child, found := current.Children.Get(pe)
+		if !found {
+			return false
+		}
+		if last {
+			return !child.Empty()
+		}
+		current = child
+	}
+	return false
+}
+
+// resolveStep finds the path element of a set matching a step. List elements are matched by key fields,
+// by value for sets of scalars, or by index.
+func resolveStep(set *fieldpath.Set, step fieldStep) (fieldpath.PathElement, bool) {
+	if step.field != "" {
+		field := step.field
+		return fieldpath.PathElement{FieldName: &field}, true
+	}
+
+	var result fieldpath.PathElement
+	found := false
+	match := func(pe fieldpath.PathElement) {
+		if found {
+			return
+		}
+		switch {
+		case pe.Key != nil:
+			found = keyMatches(*pe.Key, step.element)
+		case pe.Value != nil:
+			found = scalarEquals((*pe.Value).Unstructured(), step.element)
+		case pe.Index != nil:
+			found = *pe.Index == step.index
+		}
+		if found {
+			result = pe
+		}
+	}
+	set.Members.Iterate(match)
+	set.Children.Iterate(match)
+	return result, found
+}
+
+func keyMatches(key value.FieldList, element interface{}) bool {
+	elementMap, ok := element.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	for _, field := range key {
+		if !scalarEquals(field.Value.Unstructured(), elementMap[field.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// scalarEquals compares values ignoring the numeric type, which differs between json decoders
+func scalarEquals(a, b interface{}) bool {
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
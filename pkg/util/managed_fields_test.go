@@ -0,0 +1,108 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/pointer"
+)
+
+const kubectlFields = `{"f:spec":{"f:template":{"f:spec":{"f:containers":{"k:{\"name\":\"nginx\"}":{".":{},"f:image":{},"f:name":{}}}}}}}`
+
+const hpaFields = `{"f:spec":{"f:replicas":{}}}`
+
+func newDeployment(t *testing.T, replicas int32, image string, hpaTime time.Time) *unstructured.Unstructured {
+	t.Helper()
+	kubectlTime := metav1.NewTime(time.Unix(1000, 0))
+	scaleTime := metav1.NewTime(hpaTime)
+	deployment := &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{Kind: "Deployment", APIVersion: "apps/v1"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "nginx",
+			Namespace:       "default",
+			ResourceVersion: scaleTime.String(),
+			ManagedFields: []metav1.ManagedFieldsEntry{
+				{
+					Manager:    "kubectl-client-side-apply",
+					Operation:  metav1.ManagedFieldsOperationUpdate,
+					Time:       &kubectlTime,
+					FieldsType: "FieldsV1",
+					FieldsV1:   &metav1.FieldsV1{Raw: []byte(kubectlFields)},
+				},
+				{
+					Manager:     "horizontal-pod-autoscaler",
+					Operation:   metav1.ManagedFieldsOperationUpdate,
+					Subresource: "scale",
+					Time:        &scaleTime,
+					FieldsType:  "FieldsV1",
+					FieldsV1:    &metav1.FieldsV1{Raw: []byte(hpaFields)},
+				},
+			},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: pointer.Int32(replicas),
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "nginx", Image: image}}},
+			},
+		},
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(deployment)
+	assert.NoError(t, err)
+	return &unstructured.Unstructured{Object: content}
+}
+
+func TestDiffObjects(t *testing.T) {
+	oldObject := newDeployment(t, 2, "nginx:1.24", time.Unix(2000, 0))
+	newObject := newDeployment(t, 3, "nginx:1.24", time.Unix(3000, 0))
+
+	changedFields, attribution := DiffObjects(oldObject, newObject)
+	assert.Equal(t, []string{".spec.replicas"}, changedFields)
+	assert.Equal(t, []FieldAttribution{
+		{
+			Manager:     "horizontal-pod-autoscaler",
+			Operation:   "Update",
+			Subresource: "scale",
+			Time:        3000,
+			Updated:     true,
+			Fields:      []string{".spec.replicas"},
+		},
+	}, attribution)
+
+	// the image is owned by kubectl, which did not touch its entry with this update
+	newObject = newDeployment(t, 3, "nginx:1.25", time.Unix(3000, 0))
+	changedFields, attribution = DiffObjects(oldObject, newObject)
+	assert.Equal(t, []string{
+		".spec.replicas",
+		".spec.template.spec.containers[name=nginx].image",
+	}, changedFields)
+	assert.Equal(t, []FieldAttribution{
+		{
+			Manager:     "horizontal-pod-autoscaler",
+			Operation:   "Update",
+			Subresource: "scale",
+			Time:        3000,
+			Updated:     true,
+			Fields:      []string{".spec.replicas"},
+		},
+		{
+			Manager:   "kubectl-client-side-apply",
+			Operation: "Update",
+			Time:      1000,
+			Fields:    []string{".spec.template.spec.containers[name=nginx].image"},
+		},
+	}, attribution)
+
+	changedFields, attribution = DiffObjects(oldObject, oldObject)
+	assert.Empty(t, changedFields)
+	assert.Nil(t, attribution)
+	changedFields, attribution = DiffObjects(nil, newObject)
+	assert.Nil(t, changedFields)
+	assert.Nil(t, attribution)
+}
@@ -1,13 +1,9 @@
 package util
 
 import (
-	"github.com/google/go-cmp/cmp"
-	batchv1 "k8s.io/api/batch/v1"
-
 	"testing"
 
 	"github.com/stretchr/testify/assert"
-	appsv1 "k8s.io/api/apps/v1"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -87,173 +83,6 @@ func TestHelmSecret(t *testing.T) {
 	}
 }
 
-func TestRedactEnvVar(t *testing.T) {
-	cases := []struct {
-		name     string
-		input    *unstructured.Unstructured
-		expected *unstructured.Unstructured
-	}{
-		{
-			name: "Pod with containers",
-			input: toUnstructured(t, &corev1.Pod{
-				Spec: corev1.PodSpec{
-					Containers: []corev1.Container{
-						{
-							Name: "container1",
-							Env: []corev1.EnvVar{
-								{
-									Name:  "VAR1",
-									Value: "value1",
-								},
-							},
-						},
-					},
-				},
-			}),
-			expected: toUnstructured(t, &corev1.Pod{
-				Spec: corev1.PodSpec{
-					Containers: []corev1.Container{
-						{
-							Name: "container1",
-						},
-					},
-				},
-			}),
-		},
-		{
-			name: "Pod with init containers",
-			input: toUnstructured(t, &corev1.Pod{
-				Spec: corev1.PodSpec{
-					InitContainers: []corev1.Container{
-						{
-							Name: "init-container1",
-							Env: []corev1.EnvVar{
-								{
-									Name:  "VAR1",
-									Value: "value1",
-								},
-							},
-						},
-					},
-				},
-			}),
-			expected: toUnstructured(t, &corev1.Pod{
-				Spec: corev1.PodSpec{
-					InitContainers: []corev1.Container{
-						{
-							Name: "init-container1",
-						},
-					},
-				},
-			}),
-		},
-		{
-			name: "CronJob with init containers and containers",
-			input: toUnstructured(t, &batchv1.CronJob{
-				Spec: batchv1.CronJobSpec{
-					JobTemplate: batchv1.JobTemplateSpec{
-						Spec: batchv1.JobSpec{
-							Template: corev1.PodTemplateSpec{
-								Spec: corev1.PodSpec{
-									InitContainers: []corev1.Container{
-										{
-											Name: "init-container1",
-											Env: []corev1.EnvVar{
-												{
-													Name:  "VAR1",
-													Value: "value1",
-												},
-											},
-										},
-									},
-									Containers: []corev1.Container{
-										{
-											Name: "container1",
-											Env: []corev1.EnvVar{
-												{
-													Name:  "VAR2",
-													Value: "value2",
-												},
-											},
-										},
-									},
-								},
-							},
-						},
-					},
-				},
-			}),
-			expected: toUnstructured(t, &batchv1.CronJob{
-				Spec: batchv1.CronJobSpec{
-					JobTemplate: batchv1.JobTemplateSpec{
-						Spec: batchv1.JobSpec{
-							Template: corev1.PodTemplateSpec{
-								Spec: corev1.PodSpec{
-									InitContainers: []corev1.Container{
-										{
-											Name: "init-container1",
-										},
-									},
-									Containers: []corev1.Container{
-										{
-											Name: "container1",
-										},
-									},
-								},
-							},
-						},
-					},
-				},
-			}),
-		},
-
-		{
-			name: "Deployment with containers",
-			input: toUnstructured(t, &appsv1.Deployment{
-				Spec: appsv1.DeploymentSpec{
-					Template: corev1.PodTemplateSpec{
-						Spec: corev1.PodSpec{
-							Containers: []corev1.Container{
-								{
-									Name: "container1",
-									Env: []corev1.EnvVar{
-										{
-											Name:  "VAR1",
-											Value: "value1",
-										},
-									},
-								},
-							},
-						},
-					},
-				},
-			}),
-			expected: toUnstructured(t, &appsv1.Deployment{
-				Spec: appsv1.DeploymentSpec{
-					Template: corev1.PodTemplateSpec{
-						Spec: corev1.PodSpec{
-							Containers: []corev1.Container{
-								{
-									Name: "container1",
-								},
-							},
-						},
-					},
-				},
-			}),
-		},
-	}
-
-	for _, tc := range cases {
-		t.Run(tc.name, func(t *testing.T) {
-			RedactEnvVar(tc.input)
-			if diff := cmp.Diff(tc.expected, tc.input); diff != "" {
-				t.Errorf("RedactEnvVar mismatch (-expected +actual):\n%s", diff)
-			}
-		})
-	}
-}
-
 func toUnstructured(t *testing.T, obj runtime.Object) *unstructured.Unstructured {
 	t.Helper()
 	unstr, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)